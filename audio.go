@@ -0,0 +1,222 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+const (
+	// trimWindow is the span over which loudness is measured when trimming.
+	trimWindow = 10 // milliseconds
+
+	// speechDBFS is the RMS level from which a frame counts as speech
+	// rather than silence for Config.MaxSilence.
+	speechDBFS = -50
+)
+
+// TrimSilence removes leading and trailing 16-bit PCM audio whose RMS level,
+// measured over 10ms windows, stays below thresholdDBFS (e.g. -50). Audio is
+// trimmed in whole windows, so up to 10ms of quiet audio may remain at each end.
+// Input that is silent throughout is returned empty.
+func TrimSilence(pcm []byte, sampleRate int, thresholdDBFS float64) []byte {
+	samples := bytesToInt16(pcm)
+	window := max(sampleRate*trimWindow/1000, 1)
+	threshold := 32768 * math.Pow(10, thresholdDBFS/20)
+
+	loud := func(start int) bool {
+		end := min(start+window, len(samples))
+		var sum float64
+		for _, s := range samples[start:end] {
+			sum += float64(s) * float64(s)
+		}
+		return math.Sqrt(sum/float64(end-start)) >= threshold
+	}
+
+	first := -1
+	for start := 0; start < len(samples); start += window {
+		if loud(start) {
+			first = start
+			break
+		}
+	}
+	if first < 0 {
+		return pcm[:0]
+	}
+
+	last := first
+	for start := first; start < len(samples); start += window {
+		if loud(start) {
+			last = min(start+window, len(samples))
+		}
+	}
+
+	return pcm[first*2 : last*2]
+}
+
+// isSpeech reports whether the RMS level of samples reaches speechDBFS.
+func isSpeech(samples []int16) bool {
+	if len(samples) == 0 {
+		return false
+	}
+
+	var sum float64
+	for _, s := range samples {
+		sum += float64(s) * float64(s)
+	}
+	return math.Sqrt(sum/float64(len(samples))) >= 32768*math.Pow(10, speechDBFS/20.0)
+}
+
+// ConvertPCM downmixes interleaved 16-bit PCM to mono and resamples it from
+// fromRate to toRate using linear interpolation.
+func ConvertPCM(pcm []byte, channels, fromRate, toRate int) []byte {
+	samples := bytesToInt16(pcm)
+	channels = max(channels, 1)
+
+	mono := make([]float64, len(samples)/channels)
+	for i := range mono {
+		var sum float64
+		for _, s := range samples[i*channels : (i+1)*channels] {
+			sum += float64(s)
+		}
+		mono[i] = sum / float64(channels)
+	}
+
+	if fromRate == toRate || len(mono) == 0 {
+		return float64ToPCM(mono)
+	}
+
+	out := make([]float64, int64(len(mono))*int64(toRate)/int64(fromRate))
+	step := float64(fromRate) / float64(toRate)
+	for i := range out {
+		pos := float64(i) * step
+		j := int(pos)
+		if j >= len(mono)-1 {
+			out[i] = mono[len(mono)-1]
+			continue
+		}
+		frac := pos - float64(j)
+		out[i] = mono[j] + (mono[j+1]-mono[j])*frac
+	}
+
+	return float64ToPCM(out)
+}
+
+// streamResampler resamples a mono stream delivered in pieces with the same
+// linear interpolation as ConvertPCM, carrying the position and the last
+// sample across calls so that piece boundaries add no clicks or drift.
+type streamResampler struct {
+	fromRate, toRate int
+	pos              float64 // position of the next output sample, relative to prev
+	prev             int16
+	primed           bool // prev holds the last sample of the previous piece
+}
+
+// Resample returns the samples of in at toRate.
+func (r *streamResampler) Resample(in []int16) []int16 {
+	if r.fromRate == r.toRate || len(in) == 0 {
+		return in
+	}
+
+	buf := in
+	if r.primed {
+		buf = append([]int16{r.prev}, in...)
+	}
+
+	step := float64(r.fromRate) / float64(r.toRate)
+	last := float64(len(buf) - 1)
+
+	out := make([]int16, 0, int(float64(len(in))/step)+1)
+	for ; r.pos <= last; r.pos += step {
+		j := int(r.pos)
+		if j == len(buf)-1 {
+			out = append(out, buf[j])
+			continue
+		}
+		frac := r.pos - float64(j)
+		v := float64(buf[j]) + (float64(buf[j+1])-float64(buf[j]))*frac
+		out = append(out, int16(math.Round(v)))
+	}
+
+	r.pos -= last
+	r.prev = buf[len(buf)-1]
+	r.primed = true
+	return out
+}
+
+// float64ToPCM rounds samples to little-endian 16-bit PCM.
+func float64ToPCM(samples []float64) []byte {
+	pcm := make([]byte, 0, len(samples)*2)
+	for _, s := range samples {
+		v := int16(math.Max(math.MinInt16, math.Min(math.MaxInt16, math.Round(s))))
+		pcm = binary.LittleEndian.AppendUint16(pcm, uint16(v))
+	}
+	return pcm
+}
+
+// convertBitDepth converts little-endian integer PCM of 8 (unsigned), 16,
+// 24 or 32 bits, or IEEE float PCM of 32 or 64 bits, to 16-bit PCM. Samples
+// with more precision are reduced with triangular dither so that quiet
+// passages keep their detail as noise rather than distortion; the dither
+// sequence is fixed, so conversions are reproducible.
+func convertBitDepth(data []byte, bitDepth int, float bool) ([]byte, error) {
+	width := bitDepth / 8
+	switch {
+	case float && bitDepth != 32 && bitDepth != 64:
+		return nil, fmt.Errorf("%w: %d-bit float samples", ErrInputFormat, bitDepth)
+	case !float && (bitDepth%8 != 0 || width < 1 || width > 4):
+		return nil, fmt.Errorf("%w: %d-bit samples", ErrInputFormat, bitDepth)
+	}
+
+	le := binary.LittleEndian
+	n := len(data) / width
+	pcm := make([]byte, 0, n*2)
+	var dither ditherSource
+
+	for i := 0; i < n; i++ {
+		b := data[i*width : (i+1)*width]
+
+		// Each sample as a fraction of full scale in [-1, 1)
+		var v float64
+		switch {
+		case float && width == 4:
+			v = float64(math.Float32frombits(le.Uint32(b)))
+		case float:
+			v = math.Float64frombits(le.Uint64(b))
+		case width == 1:
+			v = float64(int(b[0])-128) / 128
+		case width == 2:
+			pcm = append(pcm, b...)
+			continue
+		case width == 3:
+			v = float64(int32(uint32(b[0])<<8|uint32(b[1])<<16|uint32(b[2])<<24)) / (1 << 31)
+		default:
+			v = float64(int32(le.Uint32(b))) / (1 << 31)
+		}
+
+		s := v * 32768
+		if width > 2 {
+			s += dither.next()
+		}
+		s = math.Max(math.MinInt16, math.Min(math.MaxInt16, math.Round(s)))
+		pcm = le.AppendUint16(pcm, uint16(int16(s)))
+	}
+
+	return pcm, nil
+}
+
+// ditherSource produces triangular dither of up to ±1 LSB from a fixed
+// pseudo-random sequence.
+type ditherSource struct {
+	state uint32
+}
+
+func (d *ditherSource) next() float64 {
+	return d.uniform() - d.uniform()
+}
+
+// uniform returns a value in [0, 1) from a linear congruential generator.
+func (d *ditherSource) uniform() float64 {
+	d.state = d.state*1664525 + 1013904223
+	return float64(d.state>>8) / (1 << 24)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"log/slog"
+	"sync"
+)
+
+// audioOutput is the lazily started channel behind Session.AudioOut.
+type audioOutput struct {
+	rate      int  // resample to, 0 keeps the session rate
+	bigEndian bool // deliver big-endian PCM, see Config.PCMByteOrder
+	logger    *slog.Logger
+	once      sync.Once
+	ch        <-chan []byte
+}
+
+// get returns the channel, subscribing s to media_output on the first call.
+func (a *audioOutput) get(s Session) <-chan []byte {
+	a.once.Do(func() {
+		a.ch = decodeAudio(s, s.Subscribe(MessageTypeMediaOutput), a.rate, a.bigEndian, a.logger)
+	})
+	return a.ch
+}
+
+// decodeAudio turns the media_output messages from media into 16-bit PCM
+// frames in the session's format, resampled to rate unless it is 0, and
+// big-endian if bigEndian is set, as DecodeMedia also returns PCM. Audio
+// that fails to decode is dropped with a warning to logger. The returned
+// channel is closed once media is.
+func decodeAudio(s Session, media <-chan Message, rate int, bigEndian bool, logger *slog.Logger) <-chan []byte {
+	out := make(chan []byte, 10)
+
+	go func() {
+		defer close(out)
+
+		var resampler *streamResampler
+		for msg := range media {
+			m, ok := msg.(*MediaOutputMessage)
+			if !ok {
+				continue
+			}
+
+			data, err := s.DecodeMedia(m.Media.Payload)
+			if err != nil {
+				logger.Warn("Dropping agent audio", "err", err)
+				continue
+			}
+			if len(data) == 0 {
+				continue
+			}
+
+			cfg := s.Config()
+			if bigEndian && isPCM16(cfg.InputFormat) {
+				data = swapPCM16(data)
+			}
+			samples := decodeSamples(cfg.InputFormat, data)
+
+			if rate > 0 {
+				if resampler == nil || resampler.fromRate != cfg.Rate() {
+					resampler = &streamResampler{fromRate: cfg.Rate(), toRate: rate}
+				}
+				samples = resampler.Resample(samples)
+			}
+
+			pcm := pcm16Codec{}.Encode(samples)
+			if bigEndian {
+				pcm = swapPCM16(pcm)
+			}
+			out <- pcm
+		}
+	}()
+
+	return out
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+// nextAudio returns the next frame of out, failing the test if none comes.
+func nextAudio(t *testing.T, out <-chan []byte) []byte {
+	t.Helper()
+
+	select {
+	case frame, ok := <-out:
+		if !ok {
+			t.Fatal("AudioOut closed")
+		}
+		return frame
+	case <-time.After(5 * time.Second):
+		t.Fatal("no agent audio")
+		return nil
+	}
+}
+
+func TestAudioOut(t *testing.T) {
+	// The agent answers any text with two frames and a clear between them
+	frames := [][]byte{{1, 2, 3, 4}, {5, 6, 7, 8, 9, 10}}
+	srv := NewTestServer(&TestServerOptions{
+		Respond: func(msg Message) []Message {
+			m, ok := msg.(*CustomMessage)
+			if !ok {
+				return nil
+			}
+			return []Message{
+				NewMediaOutputFromPCM(m.StreamID, frames[0]),
+				&ClearMessage{Event: MessageTypeClear, StreamID: m.StreamID},
+				NewMediaOutputFromPCM(m.StreamID, frames[1]),
+			}
+		},
+	})
+	defer srv.Close()
+
+	// AudioOut only receives audio that arrives after the first call
+	session := newTestSession(t, srv, Config{})
+	out := session.AudioOut()
+	if err := session.SendText(context.Background(), "hello"); err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range frames {
+		if got := nextAudio(t, out); !bytes.Equal(got, want) {
+			t.Errorf("frame %d = %v, want %v", i, got, want)
+		}
+	}
+	// Other messages still go to Messages, without the audio
+	select {
+	case m := <-session.Messages():
+		if m.Type() != MessageTypeClear {
+			t.Errorf("Messages() delivered %s, want only the clear", m.Type())
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no clear")
+	}
+
+	session.Close()
+	select {
+	case _, ok := <-out:
+		if ok {
+			t.Error("AudioOut delivered audio after Close")
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("AudioOut not closed with the session")
+	}
+}
+
+func TestAudioOutDecodes(t *testing.T) {
+	// The agent answers any text with audio in the session's format
+	ulaw := []byte{0x00, 0x7f, 0x80, 0xff, 0x55}
+	srv := NewTestServer(&TestServerOptions{
+		Respond: func(msg Message) []Message {
+			m, ok := msg.(*CustomMessage)
+			if !ok {
+				return nil
+			}
+			if m.Metadata["text"] == "ulaw" {
+				return []Message{NewMediaOutputFromPCM(m.StreamID, ulaw)}
+			}
+			return []Message{NewMediaOutputFromPCM(m.StreamID, loudPCM(100*time.Millisecond, 16000))}
+		},
+	})
+	defer srv.Close()
+
+	audioOut := func(cfg Config, text string) []byte {
+		t.Helper()
+		session := newTestSession(t, srv, cfg)
+		out := session.AudioOut()
+		if err := session.SendText(context.Background(), text); err != nil {
+			t.Fatal(err)
+		}
+		return nextAudio(t, out)
+	}
+
+	// µ-law is delivered as 16-bit PCM
+	want := pcm16Codec{}.Encode(mulawCodec{}.Decode(ulaw))
+	if got := audioOut(Config{InputFormat: InputFormatMulaw8000}, "ulaw"); !bytes.Equal(got, want) {
+		t.Errorf("µ-law frame decodes to %v, want %v", got, want)
+	}
+
+	// and with AudioOutSampleRate, resampled
+	got := audioOut(Config{AudioOutSampleRate: 8000}, "pcm")
+	if n := len(got) / 2; n < 790 || n > 800 {
+		t.Errorf("100ms at 16 kHz resampled to %d samples, want about 800", n)
+	}
+	if !bytes.Equal(got[100:104], []byte{0, 0x40, 0, 0x40}) {
+		t.Errorf("resampled audio starts %v, want the level of the original", got[:8])
+	}
+}
+
+func TestAudioOutDropsUndecodable(t *testing.T) {
+	// The agent answers any text with a frame over the limit, then one within
+	small := []byte{1, 2, 3, 4}
+	srv := NewTestServer(&TestServerOptions{
+		Respond: func(msg Message) []Message {
+			m, ok := msg.(*CustomMessage)
+			if !ok {
+				return nil
+			}
+			return []Message{NewMediaOutputFromPCM(m.StreamID, make([]byte, 10)), NewMediaOutputFromPCM(m.StreamID, small)}
+		},
+	})
+	defer srv.Close()
+
+	var logs logBuffer
+	session := newTestSession(t, srv, Config{MaxMediaBytes: 4, Logger: slog.New(NewPlainHandler(&logs, nil))})
+	out := session.AudioOut()
+	if err := session.SendText(context.Background(), "hello"); err != nil {
+		t.Fatal(err)
+	}
+	if got := nextAudio(t, out); !bytes.Equal(got, small) {
+		t.Errorf("AudioOut delivered %v, want only %v", got, small)
+	}
+	session.Close()
+
+	if !strings.Contains(logs.String(), "WARN session Dropping agent audio err=") {
+		t.Errorf("session log has no warning about the dropped frame:\n%s", logs.String())
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+)
+
+// options holds the command line configuration of the example.
+type options struct {
+	AgentID     string
+	APIKey      string
+	BaseURL     string
+	Version     string
+	InputFormat InputFormat
+	SampleRate  int
+	RecordRate  int
+	Input       string
+	Output      string
+	Transcript  string
+	Events      string
+	WireLog     string
+	Locale      string
+	CallerID    string
+
+	TrimSilenceDBFS  float64
+	AutoConvertInput bool
+	MaxInputDuration time.Duration
+	Reconnect        bool
+	Timeline         bool
+	Debug            bool
+	LogFormat        string
+
+	// Config is the client configuration loaded with -config, the base
+	// that the flags are applied to. Settings without a flag come from it.
+	Config Config
+}
+
+// parseFlags reads options from args, defaulting to the constants in main.go.
+// The API key falls back to API_KEY and then the CARTESIA_API_KEY environment variable.
+// With -config, values from the file (and the environment, see LoadConfig)
+// replace the defaults of flags that were not set explicitly.
+func parseFlags(args []string) (options, error) {
+	var (
+		opts        options
+		inputFormat string
+		configPath  string
+	)
+
+	fs := flag.NewFlagSet("cartesia-agent-stream-example", flag.ContinueOnError)
+	fs.StringVar(&configPath, "config", "", "JSON config file with client settings")
+	fs.StringVar(&opts.AgentID, "agent", AGENT_ID, "agent ID to connect to (required)")
+	fs.StringVar(&opts.APIKey, "api-key", "", "API key (default API_KEY or $CARTESIA_API_KEY)")
+	fs.StringVar(&opts.BaseURL, "base-url", BASE_URL, "agent WebSocket base URL")
+	fs.StringVar(&opts.Version, "version", VERSION, "Cartesia API version")
+	fs.StringVar(&inputFormat, "input-format", string(INPUT_FORMAT), "audio format sent to the agent")
+	fs.IntVar(&opts.SampleRate, "sample-rate", 0, "sample rate for -input-format pcm")
+	fs.StringVar(&opts.Input, "input", INPUT_WAV, "audio file to send as the question, WAV unless built with -tags ffmpeg")
+	fs.StringVar(&opts.Output, "output", OUTPUT_WAV, "stereo WAV file to record the conversation to")
+	fs.IntVar(&opts.RecordRate, "record-rate", 0, "sample rate to write the recording at, e.g. 16000 (default the session's)")
+	fs.StringVar(&opts.Transcript, "transcript", OUTPUT_TXT, "transcript file (.txt or .jsonl)")
+	fs.StringVar(&opts.Events, "events", "", "JSONL file to log dtmf, custom and clear events to")
+	fs.StringVar(&opts.WireLog, "wire-log", "", "JSONL file to append every raw frame to, with audio redacted")
+	fs.BoolVar(&opts.Debug, "debug", false, "log debug details such as the size of each media frame")
+	fs.StringVar(&opts.LogFormat, "log-format", "emoji", `log format: "emoji" for interactive use or "plain" for "LEVEL component message key=value" lines`)
+	fs.BoolVar(&opts.Timeline, "timeline", false, "record audio at the time it happened, keeping silences (see TimelineRecorder)")
+	fs.BoolVar(&opts.Reconnect, "reconnect", false, "reconnect with backoff if the connection drops, resending the rest of an interrupted turn")
+	fs.BoolVar(&opts.AutoConvertInput, "convert", false, "convert input audio that does not match -input-format")
+	fs.DurationVar(&opts.MaxInputDuration, "max-input", 0, "reject input audio longer than this, e.g. 5m (0 disables)")
+	fs.Float64Var(&opts.TrimSilenceDBFS, "trim-silence", 0, "trim input silence below this dBFS level, e.g. -50 (0 disables)")
+	fs.StringVar(&opts.Locale, "locale", LOCALE, "caller locale sent as session metadata")
+	fs.StringVar(&opts.CallerID, "caller-id", CALLER_ID, "caller ID sent as session metadata")
+
+	if err := fs.Parse(args); err != nil {
+		return options{}, err
+	}
+
+	if configPath != "" {
+		cfg, err := LoadConfig(configPath)
+		if err != nil {
+			return options{}, err
+		}
+		applyConfig(fs, &opts, &inputFormat, cfg)
+		opts.Config = cfg
+	}
+
+	if opts.LogFormat != "emoji" && opts.LogFormat != "plain" {
+		return options{}, fmt.Errorf("unknown log format %q", opts.LogFormat)
+	}
+
+	opts.InputFormat = InputFormat(inputFormat)
+	if opts.InputFormat.SampleRate() == 0 && opts.InputFormat != InputFormatPCM {
+		return options{}, fmt.Errorf("unknown input format %q, supported: %v", inputFormat, SupportedInputFormats())
+	}
+
+	// Keep the key out of the usage text by resolving its default here
+	if opts.APIKey == "" {
+		opts.APIKey = API_KEY
+	}
+	if opts.APIKey == "" {
+		opts.APIKey = os.Getenv("CARTESIA_API_KEY")
+	}
+
+	if opts.AgentID == "" {
+		fs.Usage()
+		return options{}, errors.New("-agent is required")
+	}
+	if opts.APIKey == "" {
+		fs.Usage()
+		return options{}, errors.New("-api-key or CARTESIA_API_KEY is required")
+	}
+
+	return opts, nil
+}
+
+// applyConfig copies the values set in cfg to the options whose flags were
+// not given on the command line.
+func applyConfig(fs *flag.FlagSet, opts *options, inputFormat *string, cfg Config) {
+	explicit := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+
+	if !explicit["api-key"] && cfg.APIKey != "" {
+		opts.APIKey = cfg.APIKey
+	}
+	if !explicit["base-url"] && cfg.BaseURL != "" {
+		opts.BaseURL = cfg.BaseURL
+	}
+	if !explicit["version"] && cfg.Version != "" {
+		opts.Version = cfg.Version
+	}
+	if !explicit["input-format"] && cfg.InputFormat != "" {
+		*inputFormat = string(cfg.InputFormat)
+	}
+	if !explicit["sample-rate"] && cfg.SampleRate != 0 {
+		opts.SampleRate = cfg.SampleRate
+	}
+	if !explicit["convert"] && cfg.AutoConvertInput {
+		opts.AutoConvertInput = true
+	}
+	if !explicit["max-input"] && cfg.MaxInputDuration != 0 {
+		opts.MaxInputDuration = cfg.MaxInputDuration
+	}
+	if !explicit["trim-silence"] && cfg.TrimSilenceDBFS != 0 {
+		opts.TrimSilenceDBFS = cfg.TrimSilenceDBFS
+	}
+	if !explicit["wire-log"] && cfg.WireLogPath != "" {
+		opts.WireLog = cfg.WireLogPath
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeTestConfig writes a JSON config file to the test's temporary directory.
+func writeTestConfig(t *testing.T, json string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(json), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestClientConfigKeepsConfigFile(t *testing.T) {
+	t.Setenv(envAPIKey, "")
+	t.Setenv(envBaseURL, "")
+	t.Setenv(envVersion, "")
+
+	srv := NewTestServer(nil)
+	defer srv.Close()
+
+	path := writeTestConfig(t, `{
+		"base_url": "`+srv.URL()+`",
+		"api_key": "file-key",
+		"version": "2000-01-01",
+		"metadata": {"team": "qa"},
+		"ping_interval": "7s",
+		"max_media_bytes": 4096,
+		"send_retries": 2,
+		"strict_messages": true
+	}`)
+	opts, err := parseFlags([]string{"-config", path, "-agent", "agent", "-version", VERSION, "-locale", "fr-FR"})
+	if err != nil {
+		t.Fatalf("parseFlags: %v", err)
+	}
+	cfg := clientConfig(opts)
+
+	// Settings without a flag come from the file
+	if cfg.PingInterval != 7*time.Second || cfg.MaxMediaBytes != 4096 || cfg.SendRetries != 2 || !cfg.StrictMessages {
+		t.Errorf("config file settings lost: %v", cfg)
+	}
+	// Flags given explicitly win over the file
+	if cfg.APIKey != "file-key" || cfg.Version != VERSION {
+		t.Errorf("APIKey, Version = %q, %q, want the file's key and the -version flag", cfg.APIKey, cfg.Version)
+	}
+
+	client, err := NewClient(cfg)
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	session, err := client.NewSession(context.Background(), opts.AgentID, cfg.Metadata)
+	if err != nil {
+		t.Fatalf("NewSession: %v", err)
+	}
+	defer session.Close()
+
+	start, ok := srv.Received()[0].(*StartMessage)
+	if !ok {
+		t.Fatalf("first message is %T, want *StartMessage", srv.Received()[0])
+	}
+	if start.Metadata["team"] != "qa" || start.Metadata[MetadataKeyLocale] != "fr-FR" {
+		t.Errorf("start metadata = %v, want the file's team and the -locale flag", start.Metadata)
+	}
+}
+
+func TestMetadataFlags(t *testing.T) {
+	srv := NewTestServer(nil)
+	defer srv.Close()
+
+	opts, err := parseFlags([]string{"-agent", "agent", "-api-key", "key", "-base-url", srv.URL(),
+		"-locale", "en-GB", "-caller-id", "+15555550123"})
+	if err != nil {
+		t.Fatalf("parseFlags: %v", err)
+	}
+	cfg := clientConfig(opts)
+
+	client, err := NewClient(cfg)
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	session, err := client.NewSession(context.Background(), opts.AgentID, cfg.Metadata)
+	if err != nil {
+		t.Fatalf("NewSession: %v", err)
+	}
+	defer session.Close()
+
+	start := srv.Received()[0].(*StartMessage)
+	if start.Metadata[MetadataKeyLocale] != "en-GB" || start.Metadata[MetadataKeyCallerID] != "+15555550123" {
+		t.Errorf("start metadata = %v, want the -locale and -caller-id flags", start.Metadata)
+	}
+}
+
+func TestParseFlags(t *testing.T) {
+	t.Setenv("CARTESIA_API_KEY", "")
+
+	opts, err := parseFlags([]string{"-agent", "agent", "-api-key", "key",
+		"-input", "in.wav", "-output", "out.wav", "-input-format", "mulaw_8000",
+		"-record-rate", "16000", "-max-input", "2m", "-reconnect"})
+	if err != nil {
+		t.Fatalf("parseFlags: %v", err)
+	}
+	if opts.Input != "in.wav" || opts.Output != "out.wav" || opts.InputFormat != InputFormatMulaw8000 ||
+		opts.RecordRate != 16000 || opts.MaxInputDuration != 2*time.Minute || !opts.Reconnect {
+		t.Errorf("options = %+v, want the flags' values", opts)
+	}
+
+	for _, args := range [][]string{
+		{"-agent", "agent"}, // no API key
+		{"-api-key", "key", "-agent", ""},
+		{"-agent", "agent", "-api-key", "key", "-input-format", "opus"},
+		{"-agent", "agent", "-api-key", "key", "-log-format", "xml"},
+		{"-agent", "agent", "-api-key", "key", "-no-such-flag"},
+	} {
+		if _, err := parseFlags(args); err == nil {
+			t.Errorf("parseFlags(%q) succeeded, want an error", args)
+		}
+	}
+}
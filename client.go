@@ -2,9 +2,17 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/coder/websocket"
 	"github.com/google/uuid"
@@ -15,55 +23,370 @@ type InputFormat string
 
 const (
 	InputFormatMulaw8000 InputFormat = "mulaw_8000"
+	InputFormatAlaw8000  InputFormat = "alaw_8000"
 	InputFormatPCM16000  InputFormat = "pcm_16000"
 	InputFormatPCM24000  InputFormat = "pcm_24000"
 	InputFormatPCM44100  InputFormat = "pcm_44100"
+
+	// InputFormatPCM is 16-bit PCM whose rate is given separately by
+	// Config.SampleRate / StreamConfig.SampleRate, for rates without a
+	// dedicated constant such as 8000 or 48000.
+	InputFormatPCM InputFormat = "pcm"
+)
+
+// Sample rates accepted with InputFormatPCM
+const (
+	minSampleRate = 8000
+	maxSampleRate = 192000
+)
+
+var (
+	ErrHandshakeRejected = errors.New("handshake rejected")
+)
+
+const (
+	agentIDPlaceholder  = "{agentID}"
+	defaultPathTemplate = "/agents/stream/" + agentIDPlaceholder
 )
 
 // Config
 type Config struct {
-	BaseURL     string
-	APIKey      string
-	Version     string
-	InputFormat InputFormat
+	BaseURL      string
+	PathTemplate string // defaults to "/agents/stream/{agentID}"
+	APIKey       string
+	Version      string
+	InputFormat  InputFormat
+	SampleRate   int // required with InputFormatPCM, otherwise implied by InputFormat
+
+	// Metadata is sent with every session's start event. Metadata passed to
+	// NewSession is merged on top of it.
+	Metadata Metadata
+
+	// PingInterval is the time between WebSocket pings, defaulting to 20s.
+	// A negative interval disables pings, e.g. when keepalive is managed
+	// elsewhere.
+	PingInterval time.Duration
+
+	// ReadFrameTimeout bounds how long a message may take to arrive once
+	// its first frame header has been read, so a server dribbling a message
+	// out byte by byte cannot stall the session or hold a growing partial
+	// message. The wait for the next message is not bounded; pings detect
+	// a dead connection. When exceeded the session fails with an error
+	// matching ErrReadFrameTimeout. 0 means no limit.
+	ReadFrameTimeout time.Duration
+
+	// AppKeepalive, when positive, sends a small custom event at this
+	// interval in addition to protocol pings, for intermediaries that do not
+	// forward WebSocket pings.
+	AppKeepalive time.Duration
+
+	// StreamIDGenerator returns the stream ID for each new session,
+	// defaulting to uuid.NewString (random v4 UUIDs).
+	StreamIDGenerator func() string
+
+	// TrimSilenceDBFS, when negative, trims leading and trailing input audio
+	// quieter than this level (e.g. -50) before it is sent.
+	TrimSilenceDBFS float64
+
+	// AutoConvertInput resamples and downmixes input files that do not
+	// match InputFormat instead of sending them as is.
+	AutoConvertInput bool
+
+	// MaxInputDuration, when positive, rejects input audio longer than this
+	// with ErrInputTooLong instead of sending it, so a multi-hour file is
+	// not streamed by mistake. PrepareInput checks it, and so does
+	// Conversation.StreamAudio once set with SetMaxInputDuration.
+	MaxInputDuration time.Duration
+
+	// MaxMediaBytes, when positive, rejects media_output frames whose decoded
+	// audio would exceed this size instead of decoding them. Frames are
+	// also bounded by the WebSocket read limit of 32 KiB.
+	MaxMediaBytes int
+
+	// Reconnect controls how NewReconnectingSession replaces failed
+	// connections. nil uses the defaults of ReconnectConfig.
+	Reconnect *ReconnectConfig
+
+	// ShouldReconnect, when set, decides for each lost connection and each
+	// failed dial after it whether a ReconnectingSession tries again and
+	// after what delay. err is the reason the connection or the last dial
+	// failed and attempt counts the dials for this outage from 1. It
+	// replaces the MaxAttempts and backoff settings of Reconnect; the
+	// default gives up on ErrHandshakeRejected, ErrUnsupportedVersion and a
+	// DialStatusError with a 4xx status, e.g. a 401 for a bad API key, and
+	// otherwise waits the exponential backoff, or a RateLimitError's
+	// RetryAfter if longer. Sessions that ended deliberately, e.g. with
+	// Close or MaxSessionDuration, are never reconnected.
+	ShouldReconnect func(err error, attempt int) (retry bool, delay time.Duration)
+
+	// SendRetries is how many times a ReconnectingSession sends a control
+	// message again after it was lost with a dropped connection. Audio is
+	// only resent by Conversation.StreamAudio, see ReconnectConfig.ResendTurn.
+	// A plain session has nothing to retry on: the websocket library closes
+	// the connection whenever a write fails.
+	SendRetries int
+
+	// AgentResolver maps the agent passed to NewSession to an agent ID, e.g.
+	// by looking a name up in your own registry. It should return IDs
+	// unchanged. Results are cached per client, see Client.ResolveAgent.
+	AgentResolver func(ctx context.Context, name string) (string, error)
+
+	// HTTPClient dials the WebSocket connections. The default shares a TLS
+	// session cache across the client's sessions, so connections after the
+	// first resume TLS instead of repeating the full handshake. Each session
+	// still needs its own connection: the protocol carries one stream per
+	// connection.
+	HTTPClient *http.Client
+
+	// Logger receives the logs of the client and its sessions, and of the
+	// conversations, recorders and input preparation using them, each record
+	// tagged with a "component" attribute. It defaults to slog.Default, which
+	// drops debug records such as per-frame media sizes. NewPlainHandler
+	// formats them for log aggregation.
+	Logger *slog.Logger
+
+	// MaxSessionDuration closes each session gracefully once it has been open
+	// this long, regardless of the caller's context. WaitClosed then reports
+	// ErrMaxDurationExceeded, and a ReconnectingSession does not reconnect.
+	// 0 means no limit.
+	MaxSessionDuration time.Duration
+
+	// MaxSilence closes each session gracefully once neither side has
+	// spoken for this long, e.g. to hang up an unattended call. Audio sent
+	// and received both count, but only frames above -50 dBFS, so streamed
+	// silence does not keep the session open. WaitClosed then reports
+	// ErrSilenceTimeout, and a ReconnectingSession does not reconnect.
+	// 0 means no limit.
+	MaxSilence time.Duration
+
+	// WireLogPath, when set, appends every frame sent and received as raw
+	// JSON lines to this file for protocol debugging. Audio payloads are
+	// replaced by their size unless WireLogAudio is set.
+	WireLogPath  string
+	WireLogAudio bool
+
+	// StrictMessages ends a session with ErrInvalidMessage, quoting the
+	// frame, when a message cannot be parsed or has an unknown type, instead
+	// of logging and skipping it. Useful to catch protocol drift in CI.
+	StrictMessages bool
+
+	// PCMByteOrder is the byte order of the 16-bit PCM the application
+	// exchanges with sessions in a PCM format: the audio of media_input
+	// messages passed to Send, including SendEncodedMedia payloads, and the
+	// audio returned by DecodeMedia and AudioOut. The protocol carries
+	// little-endian PCM, so binary.BigEndian converts at the session, e.g.
+	// for telephony gateways that deliver big-endian audio. It is not
+	// detected from the audio. nil means little-endian. Recorders of the
+	// same audio need RecorderOptions.ByteOrder.
+	PCMByteOrder binary.ByteOrder
+
+	// AudioOutSampleRate resamples the PCM delivered by Session.AudioOut to
+	// this rate, e.g. to feed a playback device. 0 keeps the session's rate.
+	AudioOutSampleRate int
+
+	// LogMessages keeps every message a session sends and receives in
+	// memory for Session.Log, e.g. for test assertions. The log grows for
+	// the life of the session, so leave it off in production.
+	LogMessages bool
+
+	// Metrics receives message counts, audio bytes, handshake latency, ping
+	// round trips and reconnects. nil disables metrics; see NewPrometheusMetrics.
+	Metrics Metrics
+
+	// Clock drives pings and keepalives, and the pacing, silence timing and
+	// turn detection of conversations over the client's sessions, including
+	// RunConversation and RunScript, defaulting to the system clock.
+	Clock Clock
+
+	// Base64Encoding decodes media_output payloads, defaulting to standard
+	// base64. Other alphabets are tried automatically if it fails.
+	Base64Encoding *base64.Encoding
 }
 
 // Client
 type Client struct {
-	baseURL     string
-	headers     http.Header
-	inputFormat InputFormat
+	baseURL      string
+	pathTemplate string
+	version      string
+	headers      http.Header
+	httpClient   *http.Client
+	inputFormat  InputFormat
+	sampleRate   int
+	encoding     *base64.Encoding
+	metadata     Metadata
+	appKeepalive time.Duration
+	newStreamID  func() string
+	clock        Clock
+	maxMedia     int
+	reconnect    *ReconnectConfig
+	shouldRetry  func(err error, attempt int) (bool, time.Duration)
+	sendRetries  int
+	pingInterval time.Duration
+	frameTimeout time.Duration
+	logger       *slog.Logger
+	metrics      Metrics
+	maxDuration  time.Duration
+	maxSilence   time.Duration
+	wireLogPath  string
+	wireLogAudio bool
+	logMessages  bool
+	strict       bool
+	audioOutRate int
+	bigEndian    bool
+	resolveAgent func(ctx context.Context, name string) (string, error)
+
+	agentsMu sync.Mutex
+	agents   map[string]string // cached AgentResolver results
 }
 
 func NewClient(cfg Config) (*Client, error) {
+	pathTemplate := cfg.PathTemplate
+	if pathTemplate == "" {
+		pathTemplate = defaultPathTemplate
+	}
+	if !strings.Contains(pathTemplate, agentIDPlaceholder) {
+		return nil, fmt.Errorf("path template %q must contain %s", pathTemplate, agentIDPlaceholder)
+	}
+
+	if cfg.InputFormat == InputFormatPCM {
+		if cfg.SampleRate <= 0 {
+			return nil, fmt.Errorf("input format %s requires a sample rate", cfg.InputFormat)
+		}
+		if cfg.SampleRate < minSampleRate || cfg.SampleRate > maxSampleRate {
+			return nil, fmt.Errorf("sample rate %d is outside %d-%d Hz", cfg.SampleRate, minSampleRate, maxSampleRate)
+		}
+	} else if cfg.SampleRate != 0 && cfg.SampleRate != cfg.InputFormat.SampleRate() {
+		return nil, fmt.Errorf("sample rate %d conflicts with input format %s", cfg.SampleRate, cfg.InputFormat)
+	}
+
+	if err := cfg.Metadata.Validate(); err != nil {
+		return nil, err
+	}
+
+	newStreamID := cfg.StreamIDGenerator
+	if newStreamID == nil {
+		newStreamID = uuid.NewString
+	}
+
+	clock := cfg.Clock
+	if clock == nil {
+		clock = realClock{}
+	}
+
+	metrics := cfg.Metrics
+	if metrics == nil {
+		metrics = noopMetrics{}
+	}
+
+	logger := cfg.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	if !isSupportedVersion(cfg.Version) {
+		logger.Warn("API version is not among the supported versions", "component", "client",
+			"version", cfg.Version, "supported", SupportedVersions)
+	}
+
+	httpClient := cfg.HTTPClient
+	if httpClient == nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = &tls.Config{ClientSessionCache: tls.NewLRUClientSessionCache(0)}
+		httpClient = &http.Client{Transport: transport}
+	}
+
 	headers := http.Header{
 		"Authorization":    []string{fmt.Sprintf("Bearer %s", cfg.APIKey)},
 		"Cartesia-Version": []string{cfg.Version},
 	}
 
 	return &Client{
-		baseURL:     cfg.BaseURL,
-		headers:     headers,
-		inputFormat: cfg.InputFormat,
+		baseURL:      cfg.BaseURL,
+		pathTemplate: pathTemplate,
+		version:      cfg.Version,
+		headers:      headers,
+		httpClient:   httpClient,
+		inputFormat:  cfg.InputFormat,
+		sampleRate:   cfg.SampleRate,
+		encoding:     cfg.Base64Encoding,
+		metadata:     cfg.Metadata,
+		appKeepalive: cfg.AppKeepalive,
+		newStreamID:  newStreamID,
+		clock:        clock,
+		maxMedia:     cfg.MaxMediaBytes,
+		reconnect:    cfg.Reconnect,
+		shouldRetry:  cfg.ShouldReconnect,
+		sendRetries:  cfg.SendRetries,
+		pingInterval: cfg.PingInterval,
+		frameTimeout: cfg.ReadFrameTimeout,
+		logger:       logger,
+		metrics:      metrics,
+		maxDuration:  cfg.MaxSessionDuration,
+		maxSilence:   cfg.MaxSilence,
+		wireLogPath:  cfg.WireLogPath,
+		wireLogAudio: cfg.WireLogAudio,
+		logMessages:  cfg.LogMessages,
+		strict:       cfg.StrictMessages,
+		audioOutRate: cfg.AudioOutSampleRate,
+		bigEndian:    isBigEndian(cfg.PCMByteOrder),
+		resolveAgent: cfg.AgentResolver,
 	}, nil
 }
 
 func (c *Client) NewSession(ctx context.Context, agentID string, metadata map[string]interface{}) (Session, error) {
+	merged := c.metadata.merge(metadata)
+	if err := merged.Validate(); err != nil {
+		return nil, err
+	}
+
+	agentID, err := c.ResolveAgent(ctx, agentID)
+	if err != nil {
+		return nil, err
+	}
+
 	// Construct the proper URL for the agent stream endpoint
-	addr := fmt.Sprintf("%s/agents/stream/%s", c.baseURL, agentID)
+	addr := c.baseURL + strings.ReplaceAll(c.pathTemplate, agentIDPlaceholder, url.PathEscape(agentID))
 
 	opts := &websocket.DialOptions{
 		HTTPHeader: c.headers,
+		HTTPClient: c.httpClient,
 	}
 
-	conn, _, err := websocket.Dial(ctx, addr, opts)
+	dialStart := time.Now()
+	conn, resp, err := websocket.Dial(ctx, addr, opts)
 	if err != nil {
-		return nil, err
+		return nil, dialError(c.version, resp, err)
+	}
+
+	var wire *wireLog
+	if c.wireLogPath != "" {
+		if wire, err = openWireLog(c.wireLogPath, c.wireLogAudio); err != nil {
+			conn.CloseNow()
+			return nil, err
+		}
 	}
 
-	streamID := uuid.NewString()
+	streamID := c.newStreamID()
+	config := c.streamConfig()
 
-	s, err := newSession(streamID, conn)
+	s, err := newSession(streamID, config, conn, sessionOptions{
+		encoding:     c.encoding,
+		appKeepalive: c.appKeepalive,
+		clock:        c.clock,
+		maxMedia:     c.maxMedia,
+		pingInterval: c.pingInterval,
+		frameTimeout: c.frameTimeout,
+		logger:       c.logger,
+		metrics:      c.metrics,
+		maxDuration:  c.maxDuration,
+		maxSilence:   c.maxSilence,
+		wireLog:      wire,
+		logMessages:  c.logMessages,
+		strict:       c.strict,
+		audioOutRate: c.audioOutRate,
+		bigEndian:    c.bigEndian,
+		metadata:     merged,
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -71,32 +394,92 @@ func (c *Client) NewSession(ctx context.Context, agentID string, metadata map[st
 	start := &StartMessage{
 		Event:    MessageTypeStart,
 		StreamID: streamID,
-		Config: StreamConfig{
-			InputFormat: c.inputFormat,
-		},
-		Metadata: metadata,
+		Config:   config,
+		Metadata: merged,
 	}
 
-	if err := s.Send(ctx, start); err != nil {
+	fail := func(err error) (Session, error) {
 		if closeErr := s.Close(); closeErr != nil {
-			log.Printf("Failed to close session: %v", closeErr)
+			c.log().Error("Failed to close session", "err", closeErr)
 		}
 
 		return nil, err
 	}
 
+	if err := s.Send(ctx, start); err != nil {
+		return fail(err)
+	}
+
+	var m Message
 	select {
-	case m := <-s.Messages():
-		ack, ok := m.(*AckMessage)
-		if !ok {
-			return nil, fmt.Errorf("expected ack message, but got %s", m.Type())
+	case m = <-s.Messages():
+	case <-s.ctx.Done():
+		// The read worker stopped, but an ack it queued first still counts
+		select {
+		case m = <-s.Messages():
+		default:
+			err := context.Cause(s.ctx)
+			var closeErr websocket.CloseError
+			if errors.As(err, &closeErr) {
+				err = versionError(c.version, closeErr.Reason, err)
+			}
+			return fail(fmt.Errorf("connection closed during handshake: %w", err))
 		}
+	case <-ctx.Done():
+		return fail(fmt.Errorf("handshake timed out: %w", ctx.Err()))
+	}
 
-		log.Printf("Handshake successful - stream_id: %s, input_format: %s",
-			ack.StreamID, ack.Config.InputFormat)
+	ack, ok := m.(*AckMessage)
+	if !ok {
+		return fail(fmt.Errorf("expected ack message, but got %s", m.Type()))
+	}
+	if err := ack.Err(); err != nil {
+		return fail(versionError(c.version, ack.Error, err))
+	}
 
-		return s, nil
-	case <-ctx.Done():
-		return nil, fmt.Errorf("handshake timed out: %w", ctx.Err())
+	// The agent may require another format than the one requested; the
+	// read worker has already adopted it
+	switch {
+	case ack.Config.InputFormat == "":
+	case ack.Config.Rate() == 0:
+		c.log().Warn("Ignoring unsupported input format in ack",
+			"input_format", ack.Config.InputFormat, "sending", config.InputFormat)
+	case !ack.Config.SameFormat(config):
+		c.log().Info("Server changed the input format",
+			"requested", config.InputFormat, "requested_rate", config.Rate(),
+			"input_format", ack.Config.InputFormat, "sample_rate", ack.Config.Rate())
+	}
+
+	s.handshake = time.Since(dialStart)
+	c.metrics.Handshake(s.handshake)
+
+	c.log().Info("Handshake successful", "stream_id", ack.StreamID,
+		"input_format", ack.Config.InputFormat, "sample_rate", ack.Config.Rate(), "duration", s.handshake)
+
+	return s, nil
+}
+
+// log returns the logger for the client's own records.
+func (c *Client) log() *slog.Logger {
+	return c.logger.With("component", "client")
+}
+
+// configLogger returns the logger for records of component made with cfg,
+// Config.Logger or slog.Default if unset.
+func configLogger(cfg Config, component string) *slog.Logger {
+	logger := cfg.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return logger.With("component", component)
+}
+
+// streamConfig builds the start configuration. The sample rate is only sent
+// for InputFormatPCM, the enumerated formats already encode it.
+func (c *Client) streamConfig() StreamConfig {
+	cfg := StreamConfig{InputFormat: c.inputFormat}
+	if c.inputFormat == InputFormatPCM {
+		cfg.SampleRate = c.sampleRate
 	}
+	return cfg
 }
@@ -0,0 +1,342 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/coder/websocket"
+)
+
+func TestPCMSampleRate(t *testing.T) {
+	srv := NewTestServer(nil)
+	defer srv.Close()
+
+	session := newTestSession(t, srv, Config{InputFormat: InputFormatPCM, SampleRate: 48000})
+
+	start := srv.Received()[0].(*StartMessage)
+	if start.Config.InputFormat != InputFormatPCM || start.Config.SampleRate != 48000 {
+		t.Errorf("start config = %+v, want pcm at 48000 Hz", start.Config)
+	}
+	if got := session.Config().Rate(); got != 48000 {
+		t.Errorf("session rate = %d, want 48000", got)
+	}
+	if n := len(session.Config().Silence(time.Second)); n != 96000 {
+		t.Errorf("a second of silence is %d bytes, want 96000", n)
+	}
+
+	for _, cfg := range []Config{
+		{InputFormat: InputFormatPCM},
+		{InputFormat: InputFormatPCM, SampleRate: 500},
+		{InputFormat: InputFormatPCM16000, SampleRate: 8000},
+	} {
+		if _, err := NewClient(testConfig(srv, cfg)); err == nil {
+			t.Errorf("NewClient accepted %s at %d Hz", cfg.InputFormat, cfg.SampleRate)
+		}
+	}
+}
+
+func TestPCMCustomRateConversation(t *testing.T) {
+	for _, rate := range []int{8000, 48000} {
+		t.Run(fmt.Sprint(rate), func(t *testing.T) {
+			srv := newConversationServer(nil)
+			defer srv.Close()
+
+			input := writeTestWAV(t, "question.wav", loudPCM(500*time.Millisecond, rate), rate)
+			output := filepath.Join(t.TempDir(), "conversation.wav")
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			err := RunConversationWithOptions(ctx, testConfig(srv, Config{InputFormat: InputFormatPCM, SampleRate: rate}), "agent", input, output,
+				&ConversationOptions{Completion: SilenceCompletion{Threshold: 200 * time.Millisecond}})
+			if err != nil {
+				t.Fatalf("RunConversationWithOptions: %v", err)
+			}
+
+			// 100ms frames at the custom rate
+			if n := mediaFrames(srv); n != questionFrames {
+				t.Errorf("server received %d media_input frames, want %d", n, questionFrames)
+			}
+			for _, msg := range srv.Received() {
+				if m, ok := msg.(*MediaInputMessage); ok {
+					data, err := base64.StdEncoding.DecodeString(m.Media.Payload)
+					if err != nil || len(data) != rate/10*2 {
+						t.Fatalf("media_input frame of %d bytes (%v), want 100ms at %d Hz", len(data), err, rate)
+					}
+				}
+			}
+
+			in, err := readWAV(output)
+			if err != nil {
+				t.Fatalf("readWAV: %v", err)
+			}
+			if in.sampleRate != rate || in.channels != 2 {
+				t.Errorf("recording is %s, want stereo at %d Hz", in, rate)
+			}
+		})
+	}
+}
+
+func TestAckOverridesInputFormat(t *testing.T) {
+	// The agent requires 24 kHz audio where 16 kHz was requested
+	srv := newConversationServer(nil)
+	defer srv.Close()
+	srv.opts.AckConfig = &StreamConfig{InputFormat: InputFormatPCM24000}
+
+	input := writeTestWAV(t, "question.wav", loudPCM(500*time.Millisecond, 16000), 16000)
+	output := filepath.Join(t.TempDir(), "conversation.wav")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	err := RunConversationWithOptions(ctx, testConfig(srv, Config{InputFormat: InputFormatPCM16000, AutoConvertInput: true}), "agent", input, output,
+		&ConversationOptions{Completion: SilenceCompletion{Threshold: 200 * time.Millisecond}})
+	if err != nil {
+		t.Fatalf("RunConversationWithOptions: %v", err)
+	}
+
+	if start := srv.Received()[0].(*StartMessage); start.Config.InputFormat != InputFormatPCM16000 {
+		t.Errorf("start requested %s, want %s", start.Config.InputFormat, InputFormatPCM16000)
+	}
+	// The question is resampled and paced in 100ms frames at the confirmed rate
+	if n := mediaFrames(srv); n != questionFrames {
+		t.Errorf("server received %d media_input frames, want %d", n, questionFrames)
+	}
+	for _, msg := range srv.Received() {
+		if m, ok := msg.(*MediaInputMessage); ok {
+			data, err := base64.StdEncoding.DecodeString(m.Media.Payload)
+			if err != nil || len(data) != 4800 {
+				t.Fatalf("media_input frame of %d bytes (%v), want 100ms at 24000 Hz", len(data), err)
+			}
+		}
+	}
+
+	in, err := readWAV(output)
+	if err != nil {
+		t.Fatalf("readWAV: %v", err)
+	}
+	if in.sampleRate != 24000 {
+		t.Errorf("recording is %s, want the confirmed 24000 Hz", in)
+	}
+}
+
+func TestPathTemplate(t *testing.T) {
+	srv := NewTestServer(nil)
+	defer srv.Close()
+
+	paths := make(chan string, 1)
+	serve := srv.server.Config.Handler
+	srv.server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths <- r.URL.EscapedPath()
+		serve.ServeHTTP(w, r)
+	})
+
+	client := newTestClient(t, srv, Config{PathTemplate: "/v2/agents/{agentID}/ws"})
+	session, err := client.NewSession(context.Background(), "support desk", nil)
+	if err != nil {
+		t.Fatalf("NewSession: %v", err)
+	}
+	defer session.Close()
+
+	if path := <-paths; path != "/v2/agents/support%20desk/ws" {
+		t.Errorf("dialled path %q, want the template with the escaped agent ID", path)
+	}
+
+	if _, err := NewClient(testConfig(srv, Config{PathTemplate: "/v2/agents"})); err == nil {
+		t.Error("NewClient accepted a path template without {agentID}")
+	}
+}
+
+func TestStreamIDGenerator(t *testing.T) {
+	srv := NewTestServer(nil)
+	defer srv.Close()
+
+	n := 0
+	client := newTestClient(t, srv, Config{StreamIDGenerator: func() string {
+		n++
+		return fmt.Sprintf("stream-%d", n)
+	}})
+	for i := 1; i <= 2; i++ {
+		session, err := client.NewSession(context.Background(), "agent", nil)
+		if err != nil {
+			t.Fatalf("NewSession: %v", err)
+		}
+		session.Close()
+
+		want := fmt.Sprintf("stream-%d", i)
+		start := srv.Received()[i-1].(*StartMessage)
+		if session.StreamID() != want || start.StreamID != want {
+			t.Errorf("session %d has stream ID %q, sent as %q; want %q", i, session.StreamID(), start.StreamID, want)
+		}
+	}
+
+	// Without a generator stream IDs are random UUIDs
+	a, b := newTestSession(t, srv, Config{}), newTestSession(t, srv, Config{})
+	if len(a.StreamID()) != 36 || a.StreamID() == b.StreamID() {
+		t.Errorf("default stream IDs %q and %q, want distinct UUIDs", a.StreamID(), b.StreamID())
+	}
+}
+
+func TestHandshakeDuration(t *testing.T) {
+	srv := NewTestServer(nil)
+	defer srv.Close()
+
+	const delay = 200 * time.Millisecond
+	serve := srv.server.Config.Handler
+	srv.server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(delay)
+		serve.ServeHTTP(w, r)
+	})
+
+	session := newTestSession(t, srv, Config{})
+	if d := session.Stats().HandshakeDuration; d < delay || d > delay+2*time.Second {
+		t.Errorf("HandshakeDuration = %s, want a little over the server's %s delay", d, delay)
+	}
+}
+
+func TestAckError(t *testing.T) {
+	tests := []struct {
+		name string
+		ack  AckMessage
+		ok   bool
+	}{
+		{"error", AckMessage{Status: "error", Error: "unknown agent"}, false},
+		{"status", AckMessage{Status: "unauthorized"}, false},
+		{"ok", AckMessage{Status: "ok"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := NewTestServer(nil)
+			defer srv.Close()
+
+			// The server answers the start event with the scripted ack
+			srv.server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				conn, err := websocket.Accept(w, r, nil)
+				if err != nil {
+					return
+				}
+				defer conn.CloseNow()
+
+				_, data, err := conn.Read(r.Context())
+				if err != nil {
+					return
+				}
+				start, err := unmarshalClientMessage(data)
+				if err != nil {
+					return
+				}
+				ack := tt.ack
+				ack.Event = MessageTypeAck
+				ack.StreamID = start.(*StartMessage).StreamID
+				if writeMessage(r.Context(), conn, &ack) == nil {
+					conn.Read(r.Context())
+				}
+			})
+
+			session, err := newTestClient(t, srv, Config{}).NewSession(context.Background(), "agent", nil)
+			if tt.ok {
+				if err != nil {
+					t.Fatalf("NewSession = %v, want the ok ack accepted", err)
+				}
+				session.Close()
+				return
+			}
+			if !errors.Is(err, ErrHandshakeRejected) {
+				t.Fatalf("NewSession = %v, want ErrHandshakeRejected", err)
+			}
+			if want := tt.ack.Error; want != "" && !strings.Contains(err.Error(), want) {
+				t.Errorf("NewSession = %v, want it to give the reason %q", err, want)
+			}
+		})
+	}
+}
+
+func TestHandshakeServerClose(t *testing.T) {
+	srv := NewTestServer(nil)
+	defer srv.Close()
+
+	// The server turns the stream down as soon as it reads the start event
+	srv.server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := websocket.Accept(w, r, nil)
+		if err != nil {
+			return
+		}
+		conn.Read(r.Context())
+		conn.Close(websocket.StatusPolicyViolation, "agent disabled")
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	start := time.Now()
+	_, err := newTestClient(t, srv, Config{}).NewSession(ctx, "agent", nil)
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("NewSession took %s, want it to fail as soon as the server closed", elapsed)
+	}
+	var closeErr websocket.CloseError
+	if !errors.As(err, &closeErr) || closeErr.Code != websocket.StatusPolicyViolation || closeErr.Reason != "agent disabled" {
+		t.Fatalf("NewSession = %v, want the server's close code and reason", err)
+	}
+}
+
+// countingTransport counts the requests made through it.
+type countingTransport struct {
+	http.RoundTripper
+	requests atomic.Int32
+}
+
+func (t *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.requests.Add(1)
+	return t.RoundTripper.RoundTrip(req)
+}
+
+func TestTLSSessionResumption(t *testing.T) {
+	srv := NewTestServer(nil)
+	defer srv.Close()
+
+	// Serve the test server over TLS, noting which handshakes resumed
+	resumed := make(chan bool, 2)
+	handler := srv.server.Config.Handler
+	tlsSrv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		resumed <- r.TLS.DidResume
+		handler.ServeHTTP(w, r)
+	}))
+	tlsSrv.StartTLS()
+	defer tlsSrv.Close()
+
+	cfg := testConfig(srv, Config{})
+	cfg.BaseURL = "wss" + strings.TrimPrefix(tlsSrv.URL, "https")
+	client, err := NewClient(cfg)
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(tlsSrv.Certificate())
+	client.httpClient.Transport.(*http.Transport).TLSClientConfig.RootCAs = roots
+
+	// Each session has its own connection, the second resuming TLS
+	for i, want := range []bool{false, true} {
+		session, err := client.NewSession(context.Background(), "agent", nil)
+		if err != nil {
+			t.Fatalf("NewSession %d: %v", i, err)
+		}
+		session.Close()
+		if got := <-resumed; got != want {
+			t.Errorf("session %d resumed TLS: %t, want %t", i, got, want)
+		}
+	}
+
+	// A client of the caller's dials instead
+	transport := &countingTransport{RoundTripper: http.DefaultTransport}
+	session := newTestSession(t, srv, Config{HTTPClient: &http.Client{Transport: transport}})
+	session.Close()
+	if n := transport.requests.Load(); n != 1 {
+		t.Errorf("Config.HTTPClient made %d requests, want the 1 dial", n)
+	}
+}
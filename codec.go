@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/binary"
+	"sync"
+)
+
+// Codec converts between an input format's wire encoding and 16-bit samples.
+type Codec interface {
+	Decode(data []byte) []int16
+	Encode(samples []int16) []byte
+
+	// SampleRate returns the rate of the format, or 0 if it is configured
+	// separately as with InputFormatPCM.
+	SampleRate() int
+}
+
+var (
+	codecsMu sync.RWMutex
+	codecs   = map[InputFormat]Codec{
+		InputFormatMulaw8000: mulawCodec{},
+		InputFormatAlaw8000:  alawCodec{},
+		InputFormatPCM16000:  pcm16Codec{rate: 16000},
+		InputFormatPCM24000:  pcm16Codec{rate: 24000},
+		InputFormatPCM44100:  pcm16Codec{rate: 44100},
+		InputFormatPCM:       pcm16Codec{},
+	}
+)
+
+// RegisterCodec makes a codec available for format, replacing any codec
+// already registered for it. Sizes derived from the format (chunking,
+// silence) assume the codec encodes every sample in the same number of bytes.
+func RegisterCodec(format InputFormat, codec Codec) {
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+
+	codecs[format] = codec
+}
+
+// LookupCodec returns the codec registered for format.
+func LookupCodec(format InputFormat) (Codec, bool) {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+
+	codec, ok := codecs[format]
+	return codec, ok
+}
+
+// isPCM16 reports whether format is plain 16-bit PCM.
+func isPCM16(format InputFormat) bool {
+	codec, _ := LookupCodec(format)
+	_, ok := codec.(pcm16Codec)
+	return ok
+}
+
+// decodeSamples decodes audio in format to samples, treating formats
+// without a registered codec as 16-bit PCM.
+func decodeSamples(format InputFormat, data []byte) []int16 {
+	if codec, ok := LookupCodec(format); ok {
+		return codec.Decode(data)
+	}
+	return bytesToInt16(data)
+}
+
+// isBigEndian reports whether order stores the most significant byte first.
+// nil means little-endian, the byte order of the protocol and of WAV files.
+func isBigEndian(order binary.ByteOrder) bool {
+	return order != nil && order.Uint16([]byte{0, 1}) == 1
+}
+
+// swapPCM16 returns a copy of 16-bit samples with their byte order reversed,
+// converting between little- and big-endian PCM. A trailing odd byte is kept.
+func swapPCM16(data []byte) []byte {
+	out := make([]byte, len(data))
+	copy(out, data)
+	for i := 0; i+1 < len(out); i += 2 {
+		out[i], out[i+1] = out[i+1], out[i]
+	}
+	return out
+}
+
+// pcm16Codec is little-endian 16-bit PCM.
+type pcm16Codec struct {
+	rate int
+}
+
+func (c pcm16Codec) Decode(data []byte) []int16 { return bytesToInt16(data) }
+func (c pcm16Codec) SampleRate() int            { return c.rate }
+
+func (c pcm16Codec) Encode(samples []int16) []byte {
+	data := make([]byte, 0, len(samples)*2)
+	for _, s := range samples {
+		data = binary.LittleEndian.AppendUint16(data, uint16(s))
+	}
+	return data
+}
+
+const (
+	mulawBias = 0x84
+	mulawClip = 32635
+)
+
+// mulawCodec is G.711 µ-law at 8kHz.
+type mulawCodec struct{}
+
+func (mulawCodec) SampleRate() int { return 8000 }
+
+func (mulawCodec) Encode(samples []int16) []byte {
+	out := make([]byte, len(samples))
+	for i, s := range samples {
+		v := int(s)
+		var sign byte
+		if v < 0 {
+			v = -v
+			sign = 0x80
+		}
+		v = min(v, mulawClip) + mulawBias
+
+		exp := 7
+		for mask := 0x4000; v&mask == 0 && exp > 0; mask >>= 1 {
+			exp--
+		}
+		mantissa := (v >> (exp + 3)) & 0x0F
+
+		out[i] = ^(sign | byte(exp<<4) | byte(mantissa))
+	}
+	return out
+}
+
+func (mulawCodec) Decode(data []byte) []int16 {
+	samples := make([]int16, len(data))
+	for i, b := range data {
+		b = ^b
+		exp := (b >> 4) & 0x07
+		v := ((int(b&0x0F) << 3) + mulawBias) << exp
+		v -= mulawBias
+		if b&0x80 != 0 {
+			v = -v
+		}
+		samples[i] = int16(v)
+	}
+	return samples
+}
+
+// alawSegmentEnds are the upper bounds of the A-law segments for 13-bit magnitudes.
+var alawSegmentEnds = [8]int{0x1F, 0x3F, 0x7F, 0xFF, 0x1FF, 0x3FF, 0x7FF, 0xFFF}
+
+// alawCodec is G.711 A-law at 8kHz.
+type alawCodec struct{}
+
+func (alawCodec) SampleRate() int { return 8000 }
+
+func (alawCodec) Encode(samples []int16) []byte {
+	out := make([]byte, len(samples))
+	for i, s := range samples {
+		v := int(s) >> 3
+		mask := byte(0xD5)
+		if v < 0 {
+			mask = 0x55
+			v = -v - 1
+		}
+
+		seg := 0
+		for seg < len(alawSegmentEnds) && v > alawSegmentEnds[seg] {
+			seg++
+		}
+		if seg == len(alawSegmentEnds) {
+			out[i] = 0x7F ^ mask
+			continue
+		}
+
+		aval := byte(seg << 4)
+		if seg < 2 {
+			aval |= byte(v>>1) & 0x0F
+		} else {
+			aval |= byte(v>>seg) & 0x0F
+		}
+		out[i] = aval ^ mask
+	}
+	return out
+}
+
+func (alawCodec) Decode(data []byte) []int16 {
+	samples := make([]int16, len(data))
+	for i, b := range data {
+		b ^= 0x55
+		v := int(b&0x0F) << 4
+		switch seg := (b & 0x70) >> 4; seg {
+		case 0:
+			v += 8
+		case 1:
+			v += 0x108
+		default:
+			v = (v + 0x108) << (seg - 1)
+		}
+		if b&0x80 == 0 {
+			v = -v
+		}
+		samples[i] = int16(v)
+	}
+	return samples
+}
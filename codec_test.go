@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// pcm8Codec is 8-bit signed PCM at 8 kHz, a format the library lacks.
+type pcm8Codec struct{}
+
+func (pcm8Codec) SampleRate() int { return 8000 }
+
+func (pcm8Codec) Encode(samples []int16) []byte {
+	data := make([]byte, len(samples))
+	for i, s := range samples {
+		data[i] = byte(s >> 8)
+	}
+	return data
+}
+
+func (pcm8Codec) Decode(data []byte) []int16 {
+	samples := make([]int16, len(data))
+	for i, b := range data {
+		samples[i] = int16(int8(b)) << 8
+	}
+	return samples
+}
+
+func TestRegisterCodec(t *testing.T) {
+	const format InputFormat = "pcm8_8000"
+	RegisterCodec(format, pcm8Codec{})
+
+	if !slices.Contains(SupportedInputFormats(), format) {
+		t.Errorf("SupportedInputFormats() = %v, want it to include %s", SupportedInputFormats(), format)
+	}
+	if format.SampleRate() != 8000 || format.BytesPerSample() != 1 {
+		t.Errorf("%s has rate %d and %d bytes per sample, want 8000 and 1", format, format.SampleRate(), format.BytesPerSample())
+	}
+
+	// The agent greets in the registered format
+	greeting := make([]int16, 800)
+	for i := range greeting {
+		greeting[i] = int16(i%256-128) << 8
+	}
+	srv := NewTestServer(&TestServerOptions{
+		OnStart: func(start *StartMessage) []Message {
+			return []Message{NewMediaOutputFromPCM(start.StreamID, pcm8Codec{}.Encode(greeting))}
+		},
+	})
+	defer srv.Close()
+
+	session := newTestSession(t, srv, Config{InputFormat: format})
+	if session.Config().InputFormat != format || session.Config().Rate() != 8000 {
+		t.Fatalf("session config = %+v, want %s at 8000 Hz", session.Config(), format)
+	}
+
+	path := filepath.Join(t.TempDir(), "call.wav")
+	rec, err := NewDualChannelRecorder(path, 8000, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rec.CheckFormat(session.Config()) {
+		t.Fatalf("recorder rejected the %s session", format)
+	}
+	if err := NewConversation(session, rec).DrainUntilSilence(context.Background(), 300*time.Millisecond); err != nil {
+		t.Fatalf("DrainUntilSilence: %v", err)
+	}
+	if err := rec.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The recorder decodes the greeting with the codec
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	samples := decodeRecording(t, f)
+	if len(samples) != 2*len(greeting) {
+		t.Fatalf("recording has %d frames, want %d", len(samples)/2, len(greeting))
+	}
+	for i, want := range greeting {
+		if got := samples[2*i+1]; got != int(want) {
+			t.Fatalf("right sample %d = %d, want %d", i, got, want)
+		}
+	}
+}
+
+func TestAlawCodec(t *testing.T) {
+	// G.711 A-law codes and the PCM values of the reference decoder
+	alaw := []byte{0xd5, 0x55, 0xd4, 0x80, 0x00, 0xaa, 0x2a, 0xff, 0x7f}
+	pcm := []int{8, -8, 24, 5504, -5504, 32256, -32256, 848, -848}
+
+	if InputFormatAlaw8000.SampleRate() != 8000 || InputFormatAlaw8000.BytesPerSample() != 1 {
+		t.Errorf("%s has rate %d and %d bytes per sample, want 8000 and 1",
+			InputFormatAlaw8000, InputFormatAlaw8000.SampleRate(), InputFormatAlaw8000.BytesPerSample())
+	}
+
+	srv := NewTestServer(&TestServerOptions{
+		OnStart: func(start *StartMessage) []Message {
+			return []Message{NewMediaOutputFromPCM(start.StreamID, alaw)}
+		},
+	})
+	defer srv.Close()
+
+	session := newTestSession(t, srv, Config{InputFormat: InputFormatAlaw8000})
+	path := filepath.Join(t.TempDir(), "call.wav")
+	rec, err := NewDualChannelRecorder(path, 8000, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rec.CheckFormat(session.Config()) {
+		t.Fatal("recorder rejected the A-law session")
+	}
+	if err := NewConversation(session, rec).DrainUntilSilence(context.Background(), 300*time.Millisecond); err != nil {
+		t.Fatalf("DrainUntilSilence: %v", err)
+	}
+	if err := rec.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	samples := decodeRecording(t, f)
+	if len(samples) != 2*len(pcm) {
+		t.Fatalf("recording has %d frames, want %d", len(samples)/2, len(pcm))
+	}
+	for i, want := range pcm {
+		if got := samples[2*i+1]; got != want {
+			t.Errorf("A-law %#02x decoded to %d, want %d", alaw[i], got, want)
+		}
+	}
+
+	// Encoding the decoded values gives back the codes
+	codec, _ := LookupCodec(InputFormatAlaw8000)
+	encoded := codec.Encode(codec.Decode(alaw))
+	if !bytes.Equal(encoded, alaw) {
+		t.Errorf("Encode(Decode(%x)) = %x", alaw, encoded)
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Environment variables that override values loaded from a config file
+const (
+	envAPIKey  = "CARTESIA_API_KEY"
+	envBaseURL = "CARTESIA_BASE_URL"
+	envVersion = "CARTESIA_VERSION"
+)
+
+// fileConfig is the JSON layout of a config file.
+type fileConfig struct {
+	BaseURL            string      `json:"base_url"`
+	PathTemplate       string      `json:"path_template"`
+	APIKey             string      `json:"api_key"`
+	Version            string      `json:"version"`
+	InputFormat        InputFormat `json:"input_format"`
+	SampleRate         int         `json:"sample_rate"`
+	Metadata           Metadata    `json:"metadata"`
+	PingInterval       string      `json:"ping_interval"`        // e.g. "20s", negative disables pings
+	AppKeepalive       string      `json:"app_keepalive"`        // e.g. "30s"
+	MaxSessionDuration string      `json:"max_session_duration"` // e.g. "10m"
+	MaxInputDuration   string      `json:"max_input_duration"`   // e.g. "5m"
+	MaxSilence         string      `json:"max_silence"`          // e.g. "30s"
+	ReadFrameTimeout   string      `json:"read_frame_timeout"`   // e.g. "5s"
+	TrimSilenceDBFS    float64     `json:"trim_silence_dbfs"`
+	AutoConvertInput   bool        `json:"auto_convert_input"`
+	MaxMediaBytes      int         `json:"max_media_bytes"`
+	SendRetries        int         `json:"send_retries"`
+	WireLogPath        string      `json:"wire_log_path"`
+	WireLogAudio       bool        `json:"wire_log_audio"`
+	StrictMessages     bool        `json:"strict_messages"`
+}
+
+// LoadConfig reads a Config from a JSON file, then applies the
+// CARTESIA_API_KEY, CARTESIA_BASE_URL and CARTESIA_VERSION environment
+// variables on top. Fields set on the returned Config afterwards override both.
+// Unknown keys are rejected to catch typos. Errors never include the API key.
+func LoadConfig(path string) (Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Config{}, fmt.Errorf("read config error: %w", err)
+	}
+
+	var file fileConfig
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&file); err != nil {
+		// Syntax errors may quote the offending input, so only report where it is
+		if syntaxErr, ok := err.(*json.SyntaxError); ok {
+			return Config{}, fmt.Errorf("parse config error: %s: invalid JSON at offset %d", path, syntaxErr.Offset)
+		}
+		return Config{}, fmt.Errorf("parse config error: %s: %w", path, err)
+	}
+
+	cfg := Config{
+		BaseURL:          file.BaseURL,
+		PathTemplate:     file.PathTemplate,
+		APIKey:           file.APIKey,
+		Version:          file.Version,
+		InputFormat:      file.InputFormat,
+		SampleRate:       file.SampleRate,
+		Metadata:         file.Metadata,
+		TrimSilenceDBFS:  file.TrimSilenceDBFS,
+		AutoConvertInput: file.AutoConvertInput,
+		MaxMediaBytes:    file.MaxMediaBytes,
+		SendRetries:      file.SendRetries,
+		WireLogPath:      file.WireLogPath,
+		WireLogAudio:     file.WireLogAudio,
+		StrictMessages:   file.StrictMessages,
+	}
+
+	if file.PingInterval != "" {
+		cfg.PingInterval, err = time.ParseDuration(file.PingInterval)
+		if err != nil {
+			return Config{}, fmt.Errorf("parse config error: %s: ping_interval: %w", path, err)
+		}
+	}
+	if file.AppKeepalive != "" {
+		cfg.AppKeepalive, err = time.ParseDuration(file.AppKeepalive)
+		if err != nil {
+			return Config{}, fmt.Errorf("parse config error: %s: app_keepalive: %w", path, err)
+		}
+	}
+	if file.MaxSessionDuration != "" {
+		cfg.MaxSessionDuration, err = time.ParseDuration(file.MaxSessionDuration)
+		if err != nil {
+			return Config{}, fmt.Errorf("parse config error: %s: max_session_duration: %w", path, err)
+		}
+	}
+	if file.MaxInputDuration != "" {
+		cfg.MaxInputDuration, err = time.ParseDuration(file.MaxInputDuration)
+		if err != nil {
+			return Config{}, fmt.Errorf("parse config error: %s: max_input_duration: %w", path, err)
+		}
+	}
+	if file.MaxSilence != "" {
+		cfg.MaxSilence, err = time.ParseDuration(file.MaxSilence)
+		if err != nil {
+			return Config{}, fmt.Errorf("parse config error: %s: max_silence: %w", path, err)
+		}
+	}
+	if file.ReadFrameTimeout != "" {
+		cfg.ReadFrameTimeout, err = time.ParseDuration(file.ReadFrameTimeout)
+		if err != nil {
+			return Config{}, fmt.Errorf("parse config error: %s: read_frame_timeout: %w", path, err)
+		}
+	}
+
+	if v := os.Getenv(envAPIKey); v != "" {
+		cfg.APIKey = v
+	}
+	if v := os.Getenv(envBaseURL); v != "" {
+		cfg.BaseURL = v
+	}
+	if v := os.Getenv(envVersion); v != "" {
+		cfg.Version = v
+	}
+
+	return cfg, nil
+}
+
+// String formats the config with the API key redacted, so it is safe to log.
+func (c Config) String() string {
+	type plain Config
+	c.APIKey = redactKey(c.APIKey)
+	return fmt.Sprintf("%+v", plain(c))
+}
+
+// redactKey hides all but the last four characters of a key.
+func redactKey(key string) string {
+	if key == "" {
+		return ""
+	}
+	if len(key) <= 8 {
+		return "[redacted]"
+	}
+	return "[redacted]..." + key[len(key)-4:]
+}
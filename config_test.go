@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadConfigMaxSilence(t *testing.T) {
+	cfg, err := LoadConfig(writeTestConfig(t, `{"max_silence": "30s"}`))
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	if cfg.MaxSilence != 30*time.Second {
+		t.Fatalf("MaxSilence = %s, want 30s", cfg.MaxSilence)
+	}
+
+	// The loaded setting hangs up a silent session
+	srv := NewTestServer(nil)
+	defer srv.Close()
+
+	clock := NewFakeClock(time.Unix(0, 0))
+	cfg.Clock = clock
+	session := newTestSession(t, srv, cfg)
+	advanceUntilClosed(t, clock, session, 10*time.Second)
+	if got := session.Stats().TerminatedBy; got != TerminatedByIdle {
+		t.Errorf("TerminatedBy = %q, want %q", got, TerminatedByIdle)
+	}
+
+	if _, err := LoadConfig(writeTestConfig(t, `{"max_silence": "soon"}`)); err == nil || !strings.Contains(err.Error(), "max_silence") {
+		t.Errorf("LoadConfig with an invalid max_silence = %v, want an error naming it", err)
+	}
+}
+
+func TestLoadConfigReadFrameTimeout(t *testing.T) {
+	cfg, err := LoadConfig(writeTestConfig(t, `{"read_frame_timeout": "5s"}`))
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	if cfg.ReadFrameTimeout != 5*time.Second {
+		t.Fatalf("ReadFrameTimeout = %s, want 5s", cfg.ReadFrameTimeout)
+	}
+
+	// Whole frames arriving in time pass the limit
+	srv := NewTestServer(&TestServerOptions{
+		OnStart: func(start *StartMessage) []Message {
+			return []Message{NewMediaOutputFromPCM(start.StreamID, loudPCM(300*time.Millisecond, 16000))}
+		},
+	})
+	defer srv.Close()
+
+	session := newTestSession(t, srv, cfg)
+	select {
+	case <-session.AudioOut():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no greeting with a read frame timeout: %v", context.Cause(session.Context()))
+	}
+
+	if _, err := LoadConfig(writeTestConfig(t, `{"read_frame_timeout": "5"}`)); err == nil || !strings.Contains(err.Error(), "read_frame_timeout") {
+		t.Errorf("LoadConfig with an invalid read_frame_timeout = %v, want an error naming it", err)
+	}
+}
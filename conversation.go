@@ -0,0 +1,351 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// endOfTurnSilence is sent after user audio so the agent detects the end of the turn.
+const endOfTurnSilence = time.Second
+
+var (
+	ErrConversationStopped = errors.New("conversation stopped")
+)
+
+// Conversation streams user audio over a session in real time and records it
+// to the left channel. Streaming can be paused and resumed, e.g. for
+// push-to-talk, without ending the user's turn, and stopped for good.
+type Conversation struct {
+	session  Session
+	recorder Recorder
+	logger   *slog.Logger
+	clock    Clock         // the session's, paces chunks and times silences
+	stopped  chan struct{} // closed by Stop
+	stopOnce sync.Once
+
+	mu          sync.Mutex
+	resumed     chan struct{} // closed while not paused
+	flow        FlowControl
+	flowResumed chan struct{} // closed while the server has not paused the stream
+	slowed      bool          // the server asked for real-time pacing
+	maxInput    time.Duration // longest audio StreamAudio accepts, 0 for any
+}
+
+// NewConversation creates a conversation over session. A nil recorder
+// discards the audio.
+func NewConversation(session Session, recorder Recorder) *Conversation {
+	if recorder == nil {
+		recorder = DiscardRecorder{}
+	}
+
+	resumed := make(chan struct{})
+	close(resumed)
+	flowResumed := make(chan struct{})
+	close(flowResumed)
+
+	return &Conversation{
+		session:     session,
+		recorder:    recorder,
+		clock:       sessionClock(session),
+		logger:      sessionLogger(session, "conversation"),
+		stopped:     make(chan struct{}),
+		resumed:     resumed,
+		flowResumed: flowResumed,
+	}
+}
+
+// StreamAudio sends audio in the session's format in real-time chunks. It
+// blocks while the conversation is paused and carries on with the next chunk
+// once resumed, so no audio is skipped or sent in a burst. Flow-control hints
+// passed to HandleMessage slow down or pause the stream, see SetFlowControl.
+// After Stop it returns ErrConversationStopped once the chunk in flight is sent.
+// Audio longer than the limit set with SetMaxInputDuration is rejected with
+// ErrInputTooLong before anything is sent.
+//
+// Over a ReconnectingSession whose ReconnectConfig.ResendTurn is set, a
+// chunk lost with the connection does not fail the turn: StreamAudio waits
+// for the reconnect and sends the rest of the turn, or all of it, again.
+// Each part of the turn is recorded once however often it is sent.
+func (c *Conversation) StreamAudio(ctx context.Context, audio []byte) error {
+	c.mu.Lock()
+	maxInput := c.maxInput
+	c.mu.Unlock()
+
+	if err := checkInputDuration(c.session.Config(), audio, maxInput); err != nil {
+		return err
+	}
+
+	reconnecting, _ := c.session.(*ReconnectingSession)
+	resend := ResendNone
+	if reconnecting != nil {
+		resend = reconnecting.cfg.ResendTurn
+	}
+
+	t := &turnStream{audio: audio}
+	for {
+		err := c.streamFrom(ctx, t, reconnecting)
+		if err == nil || resend == ResendNone || !lostWithConnection(ctx, err) {
+			return err
+		}
+
+		// Resume on the connection that replaces the lost one
+		select {
+		case <-t.replaced:
+		case <-reconnecting.done:
+			return err
+		case <-c.stopped:
+			return ErrConversationStopped
+		case <-ctx.Done():
+			return streamStopped(ctx, t.sent, len(audio))
+		}
+
+		if resend == ResendTurn {
+			t.sent = 0
+		}
+		c.logger.Info("🔁 Reconnected, resending the turn",
+			"from", c.session.Config().Duration(t.sent), "duration", c.session.Config().Duration(len(audio)))
+	}
+}
+
+// turnStream tracks how far StreamAudio got with a turn.
+type turnStream struct {
+	audio    []byte
+	sent     int           // offset of the next chunk, where a resend starts
+	recorded int           // bytes written to the recorder
+	replaced chan struct{} // closed when the connection of the last send is replaced
+}
+
+// streamFrom sends t's audio from t.sent on, in chunks of the current
+// session format. With a reconnecting session it notes the connection each
+// chunk goes out on, so a failed send can wait for its replacement.
+func (c *Conversation) streamFrom(ctx context.Context, t *turnStream, reconnecting *ReconnectingSession) error {
+	chunkSize := c.session.Config().BytesForDuration(chunkDuration)
+
+	return streamChunks(ctx, c.clock, t.audio[t.sent:], chunkSize, func(chunk []byte) error {
+		if c.isStopped() {
+			return ErrConversationStopped
+		}
+		if err := c.waitResumed(ctx, t.sent, len(t.audio)); err != nil {
+			return err
+		}
+		if err := c.throttle(ctx, t.sent, len(t.audio)); err != nil {
+			return err
+		}
+
+		// Record to left channel
+		if t.sent >= t.recorded {
+			if err := c.recorder.WriteLeft(chunk); err != nil {
+				return fmt.Errorf("write audio error: %w", err)
+			}
+			t.recorded = t.sent + len(chunk)
+		}
+
+		// Send to agent
+		if reconnecting != nil {
+			_, t.replaced = reconnecting.sessionAndReplaced()
+		}
+		if err := c.session.Send(ctx, NewMediaInputFromPCM(c.session.StreamID(), chunk)); err != nil {
+			return fmt.Errorf("send audio error: %w", err)
+		}
+		t.sent += len(chunk)
+		return nil
+	})
+}
+
+// SetMaxInputDuration makes StreamAudio reject audio lasting longer than d,
+// e.g. Config.MaxInputDuration. Zero removes the limit.
+func (c *Conversation) SetMaxInputDuration(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.maxInput = d
+}
+
+// sessionClock returns the Config.Clock of the client that created s, or
+// the real clock for other Session implementations.
+func sessionClock(s Session) Clock {
+	switch s := s.(type) {
+	case *session:
+		return s.clock
+	case *ReconnectingSession:
+		return s.client.clock
+	}
+	return realClock{}
+}
+
+// sessionLogger returns the Config.Logger of the client that created s,
+// or slog.Default for other Session implementations, tagging its records
+// with component.
+func sessionLogger(s Session, component string) *slog.Logger {
+	logger := slog.Default()
+	switch s := s.(type) {
+	case *session:
+		logger = s.root
+	case *ReconnectingSession:
+		logger = s.client.logger
+	}
+	return logger.With("component", component)
+}
+
+// EndTurn sends a second of silence to signal the end of the user's turn.
+// After Stop it sends nothing and returns ErrConversationStopped.
+func (c *Conversation) EndTurn(ctx context.Context) error {
+	if err := c.waitResumed(ctx, 0, c.session.Config().BytesForDuration(endOfTurnSilence)); err != nil {
+		return err
+	}
+	if c.isStopped() {
+		return ErrConversationStopped
+	}
+
+	if err := c.recorder.WriteLeft(c.session.Config().Silence(endOfTurnSilence)); err != nil {
+		return fmt.Errorf("write audio error: %w", err)
+	}
+	if err := c.session.SendSilence(ctx, endOfTurnSilence); err != nil {
+		return fmt.Errorf("send silence error: %w", err)
+	}
+	return nil
+}
+
+// DrainUntilSilence records agent audio to the right channel until none has
+// arrived for silence, e.g. to wait for a greeting to finish. Messages other
+// than media_output are skipped. If the agent never speaks it returns after
+// silence. Stop ends it early with ErrConversationStopped.
+func (c *Conversation) DrainUntilSilence(ctx context.Context, silence time.Duration) error {
+	timeout := c.clock.After(silence)
+
+	for {
+		select {
+		case msg, ok := <-c.session.Messages():
+			if !ok {
+				return fmt.Errorf("message channel closed")
+			}
+
+			c.HandleMessage(msg)
+
+			m, isMedia := msg.(*MediaOutputMessage)
+			if !isMedia {
+				continue
+			}
+
+			audioData, err := c.session.DecodeMedia(m.Media.Payload)
+			if err != nil {
+				c.logger.Warn("⚠️  Decode error", "err", err)
+				continue
+			}
+			if len(audioData) == 0 {
+				continue
+			}
+
+			if err := writeAgentAudio(c.recorder, m, audioData); err != nil {
+				return fmt.Errorf("write audio error: %w", err)
+			}
+
+			timeout = c.clock.After(silence)
+
+		case <-timeout:
+			return nil
+
+		case <-c.stopped:
+			return ErrConversationStopped
+
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Pause stops StreamAudio after the chunk in flight. The session stays open
+// and its pings continue; set Config.AppKeepalive for intermediaries that
+// need traffic while nothing is sent.
+func (c *Conversation) Pause() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	closeGate(&c.resumed)
+}
+
+// Resume continues streaming after Pause.
+func (c *Conversation) Resume() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	openGate(c.resumed)
+}
+
+// Paused reports whether streaming is paused.
+func (c *Conversation) Paused() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	select {
+	case <-c.resumed:
+		return false
+	default:
+		return true
+	}
+}
+
+// Stop ends the conversation gracefully, e.g. for an "end call" button:
+// streaming stops after the chunk in flight, a paused stream is released,
+// and the calls in progress return ErrConversationStopped so the caller can
+// close its recorder and session normally. Unlike cancelling the context,
+// nothing is interrupted mid-write. Stop may be called more than once.
+func (c *Conversation) Stop() {
+	c.stopOnce.Do(func() {
+		close(c.stopped)
+	})
+}
+
+// Stopped returns a channel that is closed once Stop is called.
+func (c *Conversation) Stopped() <-chan struct{} {
+	return c.stopped
+}
+
+func (c *Conversation) isStopped() bool {
+	select {
+	case <-c.stopped:
+		return true
+	default:
+		return false
+	}
+}
+
+// waitResumed blocks while the conversation is paused and not stopped. If
+// ctx ends first, the error reports sent of total bytes like streamChunks.
+func (c *Conversation) waitResumed(ctx context.Context, sent, total int) error {
+	c.mu.Lock()
+	resumed := c.resumed
+	c.mu.Unlock()
+
+	select {
+	case <-resumed:
+		return nil
+	case <-c.stopped:
+		return ErrConversationStopped
+	case <-ctx.Done():
+		return streamStopped(ctx, sent, total)
+	}
+}
+
+// closeGate makes receivers of gate block until openGate. A gate is open
+// while its channel is closed.
+func closeGate(gate *chan struct{}) {
+	select {
+	case <-*gate:
+		*gate = make(chan struct{})
+	default:
+	}
+}
+
+// openGate releases the receivers of a closed gate.
+func openGate(gate chan struct{}) {
+	select {
+	case <-gate:
+	default:
+		close(gate)
+	}
+}
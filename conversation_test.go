@@ -0,0 +1,346 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testRecorder counts the audio written to each channel, failing writes to
+// the left channel with err when it is set.
+type testRecorder struct {
+	mu          sync.Mutex
+	left, right int
+	err         error
+}
+
+func (r *testRecorder) WriteLeft(data []byte) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.err != nil {
+		return r.err
+	}
+	r.left += len(data)
+	return nil
+}
+
+func (r *testRecorder) WriteRight(data []byte) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.right += len(data)
+	return nil
+}
+
+func (r *testRecorder) Close() error { return nil }
+
+func (r *testRecorder) leftBytes() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.left
+}
+
+// waitForFrames waits until srv has received n media_input frames, which can
+// trail the sends that wrote them.
+func waitForFrames(t *testing.T, srv *TestServer, n int) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for mediaFrames(srv) < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("server received %d media_input frames, want %d", mediaFrames(srv), n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestStreamAudioResendsAfterReconnect(t *testing.T) {
+	tests := []struct {
+		name   string
+		resend TurnResend
+		want   int // frames of the 10 in the turn sent on the new connection
+	}{
+		{"remaining", ResendRemaining, 5},
+		{"turn", ResendTurn, 10},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The first connection drops after the 5th frame of the turn
+			var mu sync.Mutex
+			var first string
+			var frames int
+			srv := NewTestServer(&TestServerOptions{
+				Drop: func(msg Message) bool {
+					m, ok := msg.(*MediaInputMessage)
+					if !ok {
+						return false
+					}
+					mu.Lock()
+					defer mu.Unlock()
+					if first == "" {
+						first = m.StreamID
+					}
+					if m.StreamID != first {
+						return false
+					}
+					frames++
+					return frames == 5
+				},
+			})
+			defer srv.Close()
+
+			client := newTestClient(t, srv, Config{
+				Reconnect: &ReconnectConfig{InitialBackoff: 10 * time.Millisecond, ResendTurn: tt.resend},
+			})
+			session, err := client.NewReconnectingSession(context.Background(), "agent", nil)
+			if err != nil {
+				t.Fatalf("NewReconnectingSession: %v", err)
+			}
+			defer session.Close()
+
+			recorder := &testRecorder{}
+			conversation := NewConversation(session, recorder)
+			if err := conversation.StreamAudio(context.Background(), loudPCM(time.Second, 16000)); err != nil {
+				t.Fatalf("StreamAudio: %v", err)
+			}
+
+			waitForFrames(t, srv, 5+tt.want)
+			byStream := make(map[string]int)
+			var streams []string
+			for _, msg := range srv.Received() {
+				m, ok := msg.(*MediaInputMessage)
+				if !ok {
+					continue
+				}
+				if byStream[m.StreamID] == 0 {
+					streams = append(streams, m.StreamID)
+				}
+				byStream[m.StreamID]++
+			}
+			if len(streams) != 2 {
+				t.Fatalf("audio went out on %d connections, want 2", len(streams))
+			}
+			if n := byStream[streams[0]]; n != 5 {
+				t.Errorf("first connection received %d frames, want 5", n)
+			}
+			if n := byStream[streams[1]]; n != tt.want {
+				t.Errorf("second connection received %d frames, want %d", n, tt.want)
+			}
+			if n := recorder.leftBytes(); n != 32000 {
+				t.Errorf("recorded %d bytes of the turn, want each of its 32000 once", n)
+			}
+		})
+	}
+}
+
+func (r *testRecorder) rightBytes() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.right
+}
+
+// advanceUntilDone advances clock by step until done delivers a result.
+func advanceUntilDone(t *testing.T, clock *FakeClock, done <-chan error, step time.Duration) error {
+	t.Helper()
+
+	for i := 0; i < 100; i++ {
+		select {
+		case err := <-done:
+			return err
+		case <-time.After(10 * time.Millisecond):
+		}
+		clock.Advance(step)
+	}
+	t.Fatal("still waiting after advancing the clock")
+	return nil
+}
+
+func TestDrainUntilSilenceUsesClock(t *testing.T) {
+	srv := NewTestServer(&TestServerOptions{
+		OnStart: func(start *StartMessage) []Message {
+			return []Message{NewMediaOutputFromPCM(start.StreamID, loudPCM(300*time.Millisecond, 16000))}
+		},
+	})
+	defer srv.Close()
+
+	clock := NewFakeClock(time.Unix(0, 0))
+	session := newTestSession(t, srv, Config{Clock: clock})
+	recorder := &testRecorder{}
+	conversation := NewConversation(session, recorder)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- conversation.DrainUntilSilence(context.Background(), 50*time.Millisecond)
+	}()
+
+	// Without the clock moving, no amount of real time is silence
+	select {
+	case err := <-done:
+		t.Fatalf("DrainUntilSilence returned before the clock moved: %v", err)
+	case <-time.After(300 * time.Millisecond):
+	}
+
+	if err := advanceUntilDone(t, clock, done, 50*time.Millisecond); err != nil {
+		t.Fatalf("DrainUntilSilence: %v", err)
+	}
+	if n := recorder.rightBytes(); n != 9600 {
+		t.Errorf("recorded %d bytes of the greeting, want 9600", n)
+	}
+}
+
+func TestStreamAudioUsesClock(t *testing.T) {
+	srv := NewTestServer(nil)
+	defer srv.Close()
+
+	clock := NewFakeClock(time.Unix(0, 0))
+	session := newTestSession(t, srv, Config{Clock: clock})
+	conversation := NewConversation(session, nil)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- conversation.StreamAudio(context.Background(), loudPCM(300*time.Millisecond, 16000))
+	}()
+
+	// The first frame goes out, the next waits for the clock
+	waitForFrames(t, srv, 1)
+	time.Sleep(200 * time.Millisecond)
+	if n := mediaFrames(srv); n != 1 {
+		t.Fatalf("server received %d frames before the clock moved, want 1", n)
+	}
+
+	if err := advanceUntilDone(t, clock, done, chunkDuration); err != nil {
+		t.Fatalf("StreamAudio: %v", err)
+	}
+	waitForFrames(t, srv, 3)
+}
+
+func TestStreamAudioPauseResume(t *testing.T) {
+	srv := NewTestServer(nil)
+	defer srv.Close()
+
+	clock := NewFakeClock(time.Unix(0, 0))
+	session := newTestSession(t, srv, Config{Clock: clock})
+	recorder := &testRecorder{}
+	conversation := NewConversation(session, recorder)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- conversation.StreamAudio(context.Background(), loudPCM(500*time.Millisecond, 16000))
+	}()
+	waitForFrames(t, srv, 1)
+	conversation.Pause()
+
+	// Nothing goes out while paused, however much time passes
+	for i := 0; i < 20; i++ {
+		clock.Advance(chunkInterval)
+		time.Sleep(10 * time.Millisecond)
+	}
+	select {
+	case err := <-done:
+		t.Fatalf("StreamAudio returned while paused: %v", err)
+	default:
+	}
+	if n := mediaFrames(srv); n != 1 {
+		t.Fatalf("server received %d frames while paused, want 1", n)
+	}
+
+	// On resume the waiting frame goes out, then the rest at the usual pace
+	// rather than in a burst making up for the pause
+	conversation.Resume()
+	waitForFrames(t, srv, 2)
+	time.Sleep(100 * time.Millisecond)
+	if n := mediaFrames(srv); n != 2 {
+		t.Fatalf("server received %d frames before the clock moved, want 2", n)
+	}
+	if err := advanceUntilDone(t, clock, done, chunkInterval); err != nil {
+		t.Fatalf("StreamAudio: %v", err)
+	}
+	waitForFrames(t, srv, 5)
+	if n := recorder.leftBytes(); n != 16000 {
+		t.Errorf("recorded %d bytes, want all 16000 once", n)
+	}
+}
+
+func TestStreamAudioDeadlineWhilePaused(t *testing.T) {
+	tests := []struct {
+		name  string
+		pause func(c *Conversation, streamID string)
+	}{
+		{"pause", func(c *Conversation, streamID string) { c.Pause() }},
+		{"flow control", func(c *Conversation, streamID string) {
+			c.SetFlowControl(CustomFlowControl)
+			c.HandleMessage(flowEvent(streamID, "pause"))
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := NewTestServer(nil)
+			defer srv.Close()
+
+			session := newTestSession(t, srv, Config{})
+			conversation := NewConversation(session, nil)
+			tt.pause(conversation, session.StreamID())
+
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+			err := conversation.StreamAudio(ctx, loudPCM(300*time.Millisecond, 16000))
+			if !errors.Is(err, ErrSendDeadline) || !errors.Is(err, context.DeadlineExceeded) {
+				t.Fatalf("StreamAudio = %v, want ErrSendDeadline", err)
+			}
+			if !strings.Contains(err.Error(), "0 of 9600 bytes sent") {
+				t.Errorf("StreamAudio = %v, want 0 of 9600 bytes reported sent", err)
+			}
+		})
+	}
+}
+
+func TestEndTurn(t *testing.T) {
+	t.Run("recorder error", func(t *testing.T) {
+		srv := NewTestServer(nil)
+		defer srv.Close()
+
+		diskFull := errors.New("disk full")
+		conversation := NewConversation(newTestSession(t, srv, Config{}), &testRecorder{err: diskFull})
+		if err := conversation.EndTurn(context.Background()); !errors.Is(err, diskFull) {
+			t.Fatalf("EndTurn = %v, want the recorder's error", err)
+		}
+	})
+
+	t.Run("stopped", func(t *testing.T) {
+		srv := NewTestServer(nil)
+		defer srv.Close()
+
+		recorder := &testRecorder{}
+		conversation := NewConversation(newTestSession(t, srv, Config{}), recorder)
+		conversation.Stop()
+		if err := conversation.EndTurn(context.Background()); !errors.Is(err, ErrConversationStopped) {
+			t.Fatalf("EndTurn after Stop = %v, want ErrConversationStopped", err)
+		}
+		if n := recorder.leftBytes(); n != 0 {
+			t.Errorf("EndTurn after Stop recorded %d bytes, want 0", n)
+		}
+	})
+
+	t.Run("silence", func(t *testing.T) {
+		srv := NewTestServer(nil)
+		defer srv.Close()
+
+		recorder := &testRecorder{}
+		conversation := NewConversation(newTestSession(t, srv, Config{}), recorder)
+		if err := conversation.EndTurn(context.Background()); err != nil {
+			t.Fatalf("EndTurn: %v", err)
+		}
+		if n := recorder.leftBytes(); n != 32000 {
+			t.Errorf("EndTurn recorded %d bytes, want a second of silence", n)
+		}
+		waitForFrames(t, srv, 1)
+	})
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// EventLog appends timestamped control events (dtmf, custom and clear) to a
+// JSONL file, e.g. next to a recording whose timing sidecar maps audio
+// frames to the same clock. Other message types are ignored.
+type EventLog struct {
+	mu   sync.Mutex
+	file *os.File
+	w    *bufio.Writer
+}
+
+// eventEntry is one line of an EventLog.
+type eventEntry struct {
+	Time      time.Time   `json:"time"`
+	Direction string      `json:"direction"` // "in" from the agent, "out" to it
+	Event     MessageType `json:"event"`
+	Message   Message     `json:"message"`
+}
+
+// NewEventLog creates an event log, truncating any existing file.
+func NewEventLog(filename string) (*EventLog, error) {
+	file, err := os.Create(filename)
+	if err != nil {
+		return nil, err
+	}
+	return &EventLog{file: file, w: bufio.NewWriter(file)}, nil
+}
+
+// Received logs a control event received from the agent.
+func (l *EventLog) Received(m Message) error {
+	return l.write("in", m)
+}
+
+// Sent logs a control event sent to the agent.
+func (l *EventLog) Sent(m Message) error {
+	return l.write("out", m)
+}
+
+func (l *EventLog) write(direction string, m Message) error {
+	switch m.Type() {
+	case MessageTypeDTMF, MessageTypeCustom, MessageTypeClear:
+	default:
+		return nil
+	}
+
+	line, err := json.Marshal(eventEntry{Time: time.Now(), Direction: direction, Event: m.Type(), Message: m})
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	_, err = fmt.Fprintf(l.w, "%s\n", line)
+	return err
+}
+
+// Close flushes and closes the log.
+func (l *EventLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := l.w.Flush(); err != nil {
+		l.file.Close()
+		return err
+	}
+	return l.file.Close()
+}
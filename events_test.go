@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEventLog(t *testing.T) {
+	srv := newConversationServer(func(streamID string, frame int) []Message {
+		switch frame {
+		case 0:
+			return []Message{&CustomMessage{Event: MessageTypeCustom, StreamID: streamID, Metadata: Metadata{"type": "greeting_done"}}}
+		case 5:
+			return []Message{&ClearMessage{Event: MessageTypeClear, StreamID: streamID}}
+		case 6:
+			return []Message{&DTMFMessage{Event: MessageTypeDTMF, StreamID: streamID, DTMF: "7"}}
+		}
+		return nil
+	})
+	defer srv.Close()
+
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+	if _, err := runTestConversation(t, srv, Config{}, &ConversationOptions{Events: path}); err != nil {
+		t.Fatalf("RunConversationWithOptions: %v", err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	type entry struct {
+		eventEntry
+		Message json.RawMessage `json:"message"`
+	}
+	var entries []entry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			t.Fatalf("invalid line %q: %v", scanner.Text(), err)
+		}
+		entries = append(entries, e)
+	}
+
+	// Audio is left out; control events are logged in the order received
+	want := []MessageType{MessageTypeCustom, MessageTypeClear, MessageTypeDTMF}
+	if len(entries) != len(want) {
+		t.Fatalf("event log has %d entries, want %d", len(entries), len(want))
+	}
+	var last Message
+	for i, e := range entries {
+		if e.Event != want[i] || e.Direction != "in" {
+			t.Errorf("entry %d is %s %q, want %s in", i, e.Event, e.Direction, want[i])
+		}
+		if e.Time.IsZero() || i > 0 && e.Time.Before(entries[i-1].Time) {
+			t.Errorf("entry %d logged at %s, out of order", i, e.Time)
+		}
+		msg, err := UnmarshalMessage(e.Message)
+		if err != nil || msg.Type() != want[i] {
+			t.Errorf("entry %d holds %s: %v", i, e.Message, err)
+		}
+		last = msg
+	}
+	if dtmf, ok := last.(*DTMFMessage); !ok || dtmf.DTMF != "7" {
+		t.Errorf("dtmf entry = %s, want digit 7", entries[2].Message)
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// FFmpegOptions
+type FFmpegOptions struct {
+	// Path is the ffmpeg binary, defaulting to "ffmpeg" on the PATH.
+	Path string
+
+	// Args are output options placed before the output file, e.g.
+	// []string{"-c:a", "libopus", "-b:a", "32k"}. By default ffmpeg picks
+	// the codec from the output file's extension.
+	Args []string
+}
+
+// FFmpegSink transcodes agent audio with an ffmpeg subprocess, feeding it
+// the raw audio as received on stdin. User audio is not written.
+type FFmpegSink struct {
+	output string
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stderr bytes.Buffer
+}
+
+// NewFFmpegSink starts ffmpeg reading audio in cfg's format and writing to
+// output, which is overwritten. A nil opts uses the defaults.
+func NewFFmpegSink(output string, cfg StreamConfig, opts *FFmpegOptions) (*FFmpegSink, error) {
+	if opts == nil {
+		opts = &FFmpegOptions{}
+	}
+	path := opts.Path
+	if path == "" {
+		path = "ffmpeg"
+	}
+
+	format := "s16le"
+	switch cfg.InputFormat {
+	case InputFormatMulaw8000:
+		format = "mulaw"
+	case InputFormatAlaw8000:
+		format = "alaw"
+	}
+
+	args := []string{
+		"-hide_banner", "-loglevel", "error", "-y",
+		"-f", format, "-ar", strconv.Itoa(cfg.Rate()), "-ac", "1", "-i", "pipe:0",
+	}
+	args = append(args, opts.Args...)
+	args = append(args, output)
+
+	s := &FFmpegSink{output: output, cmd: exec.Command(path, args...)}
+	s.cmd.Stderr = &s.stderr
+
+	stdin, err := s.cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	s.stdin = stdin
+
+	if err := s.cmd.Start(); err != nil {
+		return nil, fmt.Errorf("start ffmpeg error: %w", err)
+	}
+	return s, nil
+}
+
+// Output returns the file ffmpeg writes to.
+func (s *FFmpegSink) Output() string {
+	return s.output
+}
+
+// WriteLeft drops user audio.
+func (s *FFmpegSink) WriteLeft(data []byte) error {
+	return nil
+}
+
+// WriteRight pipes agent audio to ffmpeg.
+func (s *FFmpegSink) WriteRight(data []byte) error {
+	if _, err := s.stdin.Write(data); err != nil {
+		return fmt.Errorf("write to ffmpeg error: %w", err)
+	}
+	return nil
+}
+
+// Close ends ffmpeg's input and waits for it to finish the output file. If
+// ffmpeg fails, the error includes what it printed.
+func (s *FFmpegSink) Close() error {
+	closeErr := s.stdin.Close()
+
+	if err := s.cmd.Wait(); err != nil {
+		if msg := strings.TrimSpace(s.stderr.String()); msg != "" {
+			return fmt.Errorf("ffmpeg error: %w: %s", err, msg)
+		}
+		return fmt.Errorf("ffmpeg error: %w", err)
+	}
+	return closeErr
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// recordWithFFmpeg drains the greeting of srv into an FFmpegSink writing output.
+func recordWithFFmpeg(t *testing.T, srv *TestServer, cfg Config, output string, opts *FFmpegOptions) error {
+	t.Helper()
+
+	session := newTestSession(t, srv, cfg)
+	sink, err := NewFFmpegSink(output, session.Config(), opts)
+	if err != nil {
+		t.Fatalf("NewFFmpegSink: %v", err)
+	}
+	if err := NewConversation(session, sink).DrainUntilSilence(context.Background(), 300*time.Millisecond); err != nil {
+		t.Fatalf("DrainUntilSilence: %v", err)
+	}
+	return sink.Close()
+}
+
+// greetingServer returns a server whose agent greets with greeting.
+func greetingServer(greeting []byte) *TestServer {
+	return NewTestServer(&TestServerOptions{
+		OnStart: func(start *StartMessage) []Message {
+			return []Message{NewMediaOutputFromPCM(start.StreamID, greeting)}
+		},
+	})
+}
+
+func TestFFmpegSink(t *testing.T) {
+	if _, err := exec.LookPath("ffmpeg"); err != nil {
+		t.Skip("ffmpeg is not installed")
+	}
+
+	greeting := loudPCM(300*time.Millisecond, 16000)
+	srv := greetingServer(greeting)
+	defer srv.Close()
+
+	output := filepath.Join(t.TempDir(), "agent.wav")
+	if err := recordWithFFmpeg(t, srv, Config{}, output, nil); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	in, err := readWAV(output)
+	if err != nil {
+		t.Fatalf("readWAV: %v", err)
+	}
+	if in.sampleRate != 16000 || in.channels != 1 || len(in.data) != len(greeting) {
+		t.Errorf("ffmpeg wrote %s with %d bytes, want the %d bytes of the greeting", in, len(in.data), len(greeting))
+	}
+}
+
+func TestFFmpegSinkCommand(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs a shell script standing in for ffmpeg")
+	}
+
+	// A stand-in that records its arguments and copies stdin to the output
+	dir := t.TempDir()
+	fake := filepath.Join(dir, "ffmpeg")
+	script := "#!/bin/sh\necho \"$@\" > \"$0.args\"\nfor last; do :; done\ncat > \"$last\"\n"
+	if err := os.WriteFile(fake, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	greeting := make([]byte, 800)
+	for i := range greeting {
+		greeting[i] = byte(i)
+	}
+	srv := greetingServer(greeting)
+	defer srv.Close()
+
+	output := filepath.Join(dir, "agent.opus")
+	opts := &FFmpegOptions{Path: fake, Args: []string{"-c:a", "libopus"}}
+	if err := recordWithFFmpeg(t, srv, Config{InputFormat: InputFormatMulaw8000}, output, opts); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	args, err := os.ReadFile(fake + ".args")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "-f mulaw -ar 8000 -ac 1 -i pipe:0 -c:a libopus " + output; !strings.Contains(string(args), want) {
+		t.Errorf("ffmpeg ran with %q, want %q", args, want)
+	}
+	if data, err := os.ReadFile(output); err != nil || string(data) != string(greeting) {
+		t.Errorf("ffmpeg got %d bytes (%v), want the %d of the greeting as received", len(data), err, len(greeting))
+	}
+
+	// A failing ffmpeg is reported with what it printed
+	failing := filepath.Join(dir, "failing")
+	if err := os.WriteFile(failing, []byte("#!/bin/sh\ncat > /dev/null\necho 'Unknown encoder' >&2\nexit 1\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	err = recordWithFFmpeg(t, srv, Config{}, output, &FFmpegOptions{Path: failing})
+	if err == nil || !strings.Contains(err.Error(), "Unknown encoder") {
+		t.Errorf("Close = %v, want ffmpeg's message", err)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+)
+
+// FIFOSink streams agent audio as it arrives, exactly as received, to a
+// writer that need not be seekable, such as a named pipe read by a media
+// player. User audio is not written.
+type FIFOSink struct {
+	w      io.Writer
+	closer io.Closer // nil when the caller owns the writer
+}
+
+// NewFIFOSink writes a path + ".json" sidecar describing the audio format,
+// then opens path for writing. Opening a FIFO blocks until a reader opens the
+// other end; create it beforehand, e.g. with mkfifo.
+func NewFIFOSink(path string, cfg StreamConfig) (*FIFOSink, error) {
+	sidecar, err := json.MarshalIndent(newPassthroughFormat(cfg), "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(path+".json", sidecar, 0o644); err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &FIFOSink{w: file, closer: file}, nil
+}
+
+// NewFIFOSinkWriter streams agent audio to w without a sidecar. Close does
+// not close w.
+func NewFIFOSinkWriter(w io.Writer) *FIFOSink {
+	return &FIFOSink{w: w}
+}
+
+// WriteLeft drops user audio.
+func (s *FIFOSink) WriteLeft(data []byte) error {
+	return nil
+}
+
+// WriteRight writes agent audio unchanged.
+func (s *FIFOSink) WriteRight(data []byte) error {
+	_, err := s.w.Write(data)
+	return err
+}
+
+// Close closes the FIFO opened by NewFIFOSink.
+func (s *FIFOSink) Close() error {
+	if s.closer == nil {
+		return nil
+	}
+	return s.closer.Close()
+}
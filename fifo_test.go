@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFIFOSinkWriter(t *testing.T) {
+	greeting := loudPCM(300*time.Millisecond, 16000)
+	srv := NewTestServer(&TestServerOptions{
+		OnStart: func(start *StartMessage) []Message {
+			return []Message{
+				NewMediaOutputFromPCM(start.StreamID, greeting[:4800]),
+				NewMediaOutputFromPCM(start.StreamID, greeting[4800:]),
+			}
+		},
+	})
+	defer srv.Close()
+
+	// A pipe is not seekable and blocks until its reader takes the audio
+	r, w := io.Pipe()
+	read := make(chan []byte, 1)
+	go func() {
+		data, _ := io.ReadAll(r)
+		read <- data
+	}()
+
+	session := newTestSession(t, srv, Config{})
+	sink := NewFIFOSinkWriter(w)
+	conversation := NewConversation(session, sink)
+	if err := conversation.DrainUntilSilence(context.Background(), 300*time.Millisecond); err != nil {
+		t.Fatalf("DrainUntilSilence: %v", err)
+	}
+	// User audio is not streamed
+	if err := conversation.EndTurn(context.Background()); err != nil {
+		t.Fatalf("EndTurn: %v", err)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+
+	if data := <-read; !bytes.Equal(data, greeting) {
+		t.Errorf("reader got %d bytes, want the %d of the greeting as received", len(data), len(greeting))
+	}
+}
+
+func TestFIFOSinkSidecar(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "agent.pcm")
+	if err := os.WriteFile(path, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	sink, err := NewFIFOSink(path, StreamConfig{InputFormat: InputFormatMulaw8000})
+	if err != nil {
+		t.Fatalf("NewFIFOSink: %v", err)
+	}
+	if err := sink.WriteRight([]byte{1, 2, 3}); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	sidecar, err := os.ReadFile(path + ".json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var format passthroughFormat
+	if err := json.Unmarshal(sidecar, &format); err != nil {
+		t.Fatalf("invalid sidecar: %v", err)
+	}
+	if format.InputFormat != InputFormatMulaw8000 || format.SampleRate != 8000 || format.Encoding != "mulaw" || format.Channels != 1 {
+		t.Errorf("sidecar = %+v, want mono µ-law at 8000 Hz", format)
+	}
+	if data, err := os.ReadFile(path); err != nil || !bytes.Equal(data, []byte{1, 2, 3}) {
+		t.Errorf("stream = %x, %v; want the audio written", data, err)
+	}
+}
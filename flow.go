@@ -0,0 +1,125 @@
+package main
+
+import "context"
+
+// FlowHint is a flow-control instruction from the server.
+type FlowHint int
+
+const (
+	FlowResume   FlowHint = iota // send at the normal pace again
+	FlowSlowDown                 // send no faster than real time
+	FlowPause                    // stop sending until FlowResume
+)
+
+func (h FlowHint) String() string {
+	switch h {
+	case FlowResume:
+		return "resume"
+	case FlowSlowDown:
+		return "slow_down"
+	case FlowPause:
+		return "pause"
+	}
+	return "unknown"
+}
+
+// FlowControl extracts a flow-control hint from a server message, reporting
+// false for messages that carry none. The protocol defines no flow-control
+// events, so it depends on what the agent sends, e.g. custom events.
+type FlowControl func(msg Message) (FlowHint, bool)
+
+// CustomFlowControl reads hints from custom events whose metadata "type" is
+// "slow_down", "pause" or "resume".
+func CustomFlowControl(msg Message) (FlowHint, bool) {
+	m, ok := msg.(*CustomMessage)
+	if !ok {
+		return 0, false
+	}
+
+	switch m.Metadata["type"] {
+	case "slow_down":
+		return FlowSlowDown, true
+	case "pause":
+		return FlowPause, true
+	case "resume":
+		return FlowResume, true
+	}
+	return 0, false
+}
+
+// SetFlowControl makes HandleMessage apply the hints found by fc to
+// StreamAudio. Without flow control, or until a hint arrives, StreamAudio
+// paces frames at a fixed interval regardless of the server's buffer.
+func (c *Conversation) SetFlowControl(fc FlowControl) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.flow = fc
+}
+
+// HandleMessage applies msg to the stream if it is a flow-control hint and
+// reports whether it was one. DrainUntilSilence calls it for every message;
+// other readers of the session should pass messages on while streaming.
+// The FlowControl runs without the conversation's lock held, so it may call
+// Pause, Resume or Paused.
+func (c *Conversation) HandleMessage(msg Message) bool {
+	c.mu.Lock()
+	flow := c.flow
+	c.mu.Unlock()
+
+	if flow == nil {
+		return false
+	}
+	hint, ok := flow(msg)
+	if !ok {
+		return false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.logger.Info("🚦 Flow control", "hint", hint)
+	switch hint {
+	case FlowResume:
+		c.slowed = false
+		openGate(c.flowResumed)
+	case FlowSlowDown:
+		c.slowed = true
+		openGate(c.flowResumed)
+	case FlowPause:
+		closeGate(&c.flowResumed)
+	}
+	return true
+}
+
+// throttle blocks while the server paused the stream and, after a slow-down
+// hint, stretches the gap between frames so audio goes out in real time.
+// If ctx ends first, the error reports sent of total bytes like streamChunks.
+func (c *Conversation) throttle(ctx context.Context, sent, total int) error {
+	c.mu.Lock()
+	flowResumed := c.flowResumed
+	c.mu.Unlock()
+
+	select {
+	case <-flowResumed:
+	case <-c.stopped:
+		return ErrConversationStopped
+	case <-ctx.Done():
+		return streamStopped(ctx, sent, total)
+	}
+
+	c.mu.Lock()
+	slowed := c.slowed
+	c.mu.Unlock()
+	if !slowed {
+		return nil
+	}
+
+	// streamChunks already waits chunkInterval after each frame
+	select {
+	case <-c.clock.After(chunkDuration - chunkInterval):
+		return nil
+	case <-ctx.Done():
+		return streamStopped(ctx, sent, total)
+	}
+}
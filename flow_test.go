@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// flowEvent returns a custom event carrying a CustomFlowControl hint.
+func flowEvent(streamID, hint string) *CustomMessage {
+	return &CustomMessage{Event: MessageTypeCustom, StreamID: streamID, Metadata: Metadata{"type": hint}}
+}
+
+// mediaFrames counts the media_input frames srv received.
+func mediaFrames(srv *TestServer) int {
+	n := 0
+	for _, msg := range srv.Received() {
+		if _, ok := msg.(*MediaInputMessage); ok {
+			n++
+		}
+	}
+	return n
+}
+
+func TestFlowControlPause(t *testing.T) {
+	srv := NewTestServer(nil)
+	defer srv.Close()
+
+	session := newTestSession(t, srv, Config{})
+	conversation := NewConversation(session, nil)
+	conversation.SetFlowControl(CustomFlowControl)
+
+	if !conversation.HandleMessage(flowEvent(session.StreamID(), "pause")) {
+		t.Fatal("HandleMessage did not treat pause as a hint")
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- conversation.StreamAudio(context.Background(), loudPCM(300*time.Millisecond, 16000))
+	}()
+
+	select {
+	case err := <-done:
+		t.Fatalf("StreamAudio returned while paused: %v", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+	if n := mediaFrames(srv); n != 0 {
+		t.Fatalf("server received %d frames while paused, want 0", n)
+	}
+
+	conversation.HandleMessage(flowEvent(session.StreamID(), "resume"))
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("StreamAudio: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("StreamAudio did not resume")
+	}
+}
+
+func TestFlowControlCallbackMayPause(t *testing.T) {
+	srv := NewTestServer(nil)
+	defer srv.Close()
+
+	session := newTestSession(t, srv, Config{})
+	conversation := NewConversation(session, nil)
+	conversation.SetFlowControl(func(msg Message) (FlowHint, bool) {
+		if !conversation.Paused() {
+			conversation.Pause()
+		}
+		return CustomFlowControl(msg)
+	})
+
+	done := make(chan bool, 1)
+	go func() {
+		done <- conversation.HandleMessage(flowEvent(session.StreamID(), "slow_down"))
+	}()
+
+	select {
+	case ok := <-done:
+		if !ok {
+			t.Error("HandleMessage did not treat slow_down as a hint")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("HandleMessage deadlocked on a FlowControl calling Pause")
+	}
+	if !conversation.Paused() {
+		t.Error("Pause from the FlowControl was not applied")
+	}
+}
+
+func TestRunConversationAppliesFlowControl(t *testing.T) {
+	// The agent asks for a slow-down while the question streams
+	srv := newConversationServer(func(streamID string, frame int) []Message {
+		if frame == 2 {
+			return []Message{flowEvent(streamID, "slow_down")}
+		}
+		return nil
+	})
+	defer srv.Close()
+
+	hints := make(chan FlowHint, 10)
+	_, err := runTestConversation(t, srv, Config{}, &ConversationOptions{
+		Started: func(c *Conversation) {
+			c.SetFlowControl(func(msg Message) (FlowHint, bool) {
+				hint, ok := CustomFlowControl(msg)
+				if ok {
+					hints <- hint
+				}
+				return hint, ok
+			})
+		},
+	})
+	if err != nil {
+		t.Fatalf("RunConversationWithOptions: %v", err)
+	}
+
+	select {
+	case hint := <-hints:
+		if hint != FlowSlowDown {
+			t.Errorf("flow control saw %s, want slow_down", hint)
+		}
+	default:
+		t.Error("the slow-down hint never reached the conversation")
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"slices"
+	"time"
+)
+
+// SampleRate returns the sample rate of the format's codec, or 0 for
+// InputFormatPCM whose rate is configured separately.
+func (f InputFormat) SampleRate() int {
+	if codec, ok := LookupCodec(f); ok {
+		return codec.SampleRate()
+	}
+	return 0
+}
+
+// builtinFormats lists the formats defined by the library in the order
+// SupportedInputFormats returns them.
+var builtinFormats = []InputFormat{
+	InputFormatPCM44100,
+	InputFormatPCM24000,
+	InputFormatPCM16000,
+	InputFormatPCM,
+	InputFormatMulaw8000,
+	InputFormatAlaw8000,
+}
+
+// SupportedInputFormats returns every format with a codec: the library's own,
+// then those added with RegisterCodec sorted by name. Use Description and
+// SampleRate to label them, e.g. in a format picker.
+func SupportedInputFormats() []InputFormat {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+
+	var formats, registered []InputFormat
+	for _, f := range builtinFormats {
+		if _, ok := codecs[f]; ok {
+			formats = append(formats, f)
+		}
+	}
+	for f := range codecs {
+		if !slices.Contains(builtinFormats, f) {
+			registered = append(registered, f)
+		}
+	}
+	slices.Sort(registered)
+
+	return append(formats, registered...)
+}
+
+// Description returns a human-readable name for the format, or the format
+// itself for codecs registered by the caller.
+func (f InputFormat) Description() string {
+	switch f {
+	case InputFormatPCM44100:
+		return "16-bit PCM, 44.1 kHz"
+	case InputFormatPCM24000:
+		return "16-bit PCM, 24 kHz"
+	case InputFormatPCM16000:
+		return "16-bit PCM, 16 kHz"
+	case InputFormatPCM:
+		return "16-bit PCM, custom rate"
+	case InputFormatMulaw8000:
+		return "G.711 µ-law, 8 kHz"
+	case InputFormatAlaw8000:
+		return "G.711 A-law, 8 kHz"
+	}
+	return string(f)
+}
+
+// BytesPerSample returns the encoded size of a single mono sample.
+func (f InputFormat) BytesPerSample() int {
+	if codec, ok := LookupCodec(f); ok {
+		return len(codec.Encode(make([]int16, 1)))
+	}
+	return 2
+}
+
+// Rate returns the effective sample rate, preferring an explicit SampleRate
+// over the one implied by the format name.
+func (c StreamConfig) Rate() int {
+	if c.SampleRate > 0 {
+		return c.SampleRate
+	}
+	return c.InputFormat.SampleRate()
+}
+
+// SameFormat reports whether c and o describe the same encoding and rate,
+// whether the rate is explicit or implied by the format name.
+func (c StreamConfig) SameFormat(o StreamConfig) bool {
+	return c.InputFormat == o.InputFormat && c.Rate() == o.Rate()
+}
+
+// BytesPerSecond returns the size of one second of audio.
+func (c StreamConfig) BytesPerSecond() int {
+	return c.Rate() * c.InputFormat.BytesPerSample()
+}
+
+// BytesForDuration returns the size of d of audio, rounded down to a whole sample.
+func (c StreamConfig) BytesForDuration(d time.Duration) int {
+	samples := int(int64(c.Rate()) * int64(d) / int64(time.Second))
+	return samples * c.InputFormat.BytesPerSample()
+}
+
+// Duration returns the playback length of n bytes of audio.
+func (c StreamConfig) Duration(n int) time.Duration {
+	bps := c.BytesPerSecond()
+	if bps == 0 {
+		return 0
+	}
+	return time.Duration(int64(n) * int64(time.Second) / int64(bps))
+}
+
+// Silence returns d of silent audio in the configured encoding.
+func (c StreamConfig) Silence(d time.Duration) []byte {
+	// Zero amplitude is not zero bytes in every encoding, e.g. 0xFF in µ-law
+	if codec, ok := LookupCodec(c.InputFormat); ok {
+		samples := int(int64(c.Rate()) * int64(d) / int64(time.Second))
+		return codec.Encode(make([]int16, samples))
+	}
+	return make([]byte, c.BytesForDuration(d))
+}
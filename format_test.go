@@ -0,0 +1,53 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestSupportedInputFormats(t *testing.T) {
+	want := []InputFormat{
+		InputFormatPCM44100,
+		InputFormatPCM24000,
+		InputFormatPCM16000,
+		InputFormatPCM,
+		InputFormatMulaw8000,
+		InputFormatAlaw8000,
+	}
+	rates := map[InputFormat]int{
+		InputFormatPCM44100:  44100,
+		InputFormatPCM24000:  24000,
+		InputFormatPCM16000:  16000,
+		InputFormatMulaw8000: 8000,
+		InputFormatAlaw8000:  8000,
+	}
+
+	// The library's formats come first, before any registered by tests
+	formats := SupportedInputFormats()
+	if len(formats) < len(want) || !slices.Equal(formats[:len(want)], want) {
+		t.Fatalf("SupportedInputFormats() = %v, want it to start with %v", formats, want)
+	}
+
+	srv := NewTestServer(nil)
+	defer srv.Close()
+
+	for _, f := range want {
+		if d := f.Description(); d == "" || d == string(f) {
+			t.Errorf("%s has description %q, want a human-readable one", f, d)
+		}
+		if got := f.SampleRate(); got != rates[f] {
+			t.Errorf("%s.SampleRate() = %d, want %d", f, got, rates[f])
+		}
+
+		// Each format can be streamed at its rate
+		cfg := Config{InputFormat: f}
+		if f == InputFormatPCM {
+			cfg.SampleRate = 48000
+		}
+		session := newTestSession(t, srv, cfg)
+		if got, want := session.Config().Rate(), max(rates[f], cfg.SampleRate); got != want {
+			t.Errorf("%s session runs at %d Hz, want %d", f, got, want)
+		}
+		session.Close()
+	}
+}
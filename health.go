@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var (
+	ErrUnauthorized = errors.New("unauthorized")
+)
+
+// HealthCheck checks that the server is reachable and accepts the client's
+// credentials without starting a session. The streaming API has no status
+// endpoint, so it sends an authenticated GET to the base URL over HTTP(S):
+// a 401 or 403 fails with ErrUnauthorized, a 429 with a *RateLimitError and
+// a 5xx or network error as is. Any other response, including 404, means
+// the server is up; whether a bad key is caught depends on the server
+// checking credentials on that path, so NewSession may still be refused.
+func (c *Client) HealthCheck(ctx context.Context) error {
+	addr, err := healthCheckURL(c.baseURL)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, addr, nil)
+	if err != nil {
+		return err
+	}
+	req.Header = c.headers.Clone()
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("health check error: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return fmt.Errorf("health check error: %w: %s%s", ErrUnauthorized, resp.Status, responseReason(resp))
+	case resp.StatusCode == http.StatusTooManyRequests:
+		return &RateLimitError{
+			RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()),
+			Err:        fmt.Errorf("health check error: %s", resp.Status),
+		}
+	case resp.StatusCode >= 500:
+		return fmt.Errorf("health check error: %s%s", resp.Status, responseReason(resp))
+	}
+	return nil
+}
+
+// healthCheckURL maps a ws:// or wss:// base URL to the HTTP(S) URL of its root.
+func healthCheckURL(baseURL string) (string, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid base URL %q: %w", baseURL, err)
+	}
+
+	switch u.Scheme {
+	case "ws":
+		u.Scheme = "http"
+	case "wss":
+		u.Scheme = "https"
+	}
+	if u.Path == "" {
+		u.Path = "/"
+	}
+	return u.String(), nil
+}
+
+// responseReason returns the start of an error response body, prefixed
+// for appending to its status, or "" if it is empty.
+func responseReason(resp *http.Response) string {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	if reason := strings.TrimSpace(string(body)); reason != "" {
+		return ": " + reason
+	}
+	return ""
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHealthCheck(t *testing.T) {
+	// The server checks the key on every request, and fails when asked to
+	srv := NewTestServer(nil)
+	defer srv.Close()
+	serve := srv.server.Config.Handler
+	srv.server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Header.Get("Authorization") != "Bearer good-key":
+			http.Error(w, "invalid API key", http.StatusUnauthorized)
+		case r.Header.Get("Cartesia-Version") == "":
+			http.Error(w, "missing version", http.StatusBadRequest)
+		case r.URL.Query().Get("fail") == "busy":
+			w.Header().Set("Retry-After", "2")
+			http.Error(w, "slow down", http.StatusTooManyRequests)
+		case r.URL.Query().Get("fail") == "down":
+			http.Error(w, "maintenance", http.StatusServiceUnavailable)
+		default:
+			serve.ServeHTTP(w, r)
+		}
+	})
+
+	check := func(key, query string) error {
+		t.Helper()
+		cfg := testConfig(srv, Config{APIKey: key})
+		cfg.BaseURL += query
+		client, err := NewClient(cfg)
+		if err != nil {
+			t.Fatalf("NewClient: %v", err)
+		}
+		return client.HealthCheck(context.Background())
+	}
+
+	// A plain GET of the stream endpoint is refused as not a WebSocket
+	// upgrade, which still shows the server is up
+	if err := check("good-key", ""); err != nil {
+		t.Errorf("HealthCheck with a good key = %v, want nil", err)
+	}
+	if err := check("bad-key", ""); !errors.Is(err, ErrUnauthorized) || !strings.Contains(err.Error(), "invalid API key") {
+		t.Errorf("HealthCheck with a bad key = %v, want ErrUnauthorized with the reason", err)
+	}
+	var limited *RateLimitError
+	if err := check("good-key", "/?fail=busy"); !errors.As(err, &limited) || limited.RetryAfter != 2*time.Second {
+		t.Errorf("HealthCheck of a rate limited server = %v, want a RateLimitError retrying after 2s", err)
+	}
+	if err := check("good-key", "/?fail=down"); err == nil || !strings.Contains(err.Error(), "maintenance") {
+		t.Errorf("HealthCheck of a server in maintenance = %v, want its 503", err)
+	}
+
+	// The good key streams too
+	newTestSession(t, srv, Config{APIKey: "good-key"}).Close()
+
+	srv.Close()
+	if err := check("good-key", ""); err == nil {
+		t.Error("HealthCheck of a stopped server succeeded")
+	}
+}
@@ -0,0 +1,365 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-audio/wav"
+)
+
+const (
+	wavFormatPCM        = 1
+	wavFormatFloat      = 3
+	wavFormatAlaw       = 6
+	wavFormatMulaw      = 7
+	wavFormatExtensible = 0xFFFE
+)
+
+var (
+	ErrInputFormat    = errors.New("input audio cannot be converted")
+	ErrEmptyAudio     = errors.New("input audio is empty")
+	ErrNoInputDecoder = errors.New("no decoder for input file")
+	ErrInputTooLong   = errors.New("input audio is too long")
+)
+
+// DecodedInput is audio decoded by an InputDecoder.
+type DecodedInput struct {
+	PCM        []byte // interleaved 16-bit little-endian samples
+	Channels   int
+	SampleRate int
+}
+
+// InputDecoder decodes a compressed audio file, e.g. Opus or MP3, to PCM.
+// target is the format the audio will be sent in; a decoder may already
+// downmix and resample to it, otherwise the audio is converted afterwards.
+type InputDecoder func(filename string, target StreamConfig) (DecodedInput, error)
+
+var (
+	inputDecodersMu sync.RWMutex
+	inputDecoders   = map[string]InputDecoder{}
+)
+
+// RegisterInputDecoder makes PrepareInput decode files with the extension
+// ext, e.g. ".opus", with dec, replacing any decoder already registered for
+// it. WAV files are read without a decoder. Builds with -tags ffmpeg
+// register decoders for common compressed formats.
+func RegisterInputDecoder(ext string, dec InputDecoder) {
+	inputDecodersMu.Lock()
+	defer inputDecodersMu.Unlock()
+
+	inputDecoders[strings.ToLower(ext)] = dec
+}
+
+// lookupInputDecoder returns the decoder registered for filename's extension.
+func lookupInputDecoder(filename string) (InputDecoder, bool) {
+	inputDecodersMu.RLock()
+	defer inputDecodersMu.RUnlock()
+
+	dec, ok := inputDecoders[strings.ToLower(filepath.Ext(filename))]
+	return dec, ok
+}
+
+// wavInput is the audio data of a WAV file and the format it is stored in.
+type wavInput struct {
+	data       []byte
+	format     int // WAVE format tag, e.g. wavFormatPCM
+	channels   int
+	sampleRate int
+	bitDepth   int
+}
+
+func (in *wavInput) String() string {
+	switch in.format {
+	case wavFormatFloat:
+		return fmt.Sprintf("%d-bit float %d Hz, %d channel(s)", in.bitDepth, in.sampleRate, in.channels)
+	case wavFormatMulaw:
+		return fmt.Sprintf("µ-law %d Hz, %d channel(s)", in.sampleRate, in.channels)
+	case wavFormatAlaw:
+		return fmt.Sprintf("A-law %d Hz, %d channel(s)", in.sampleRate, in.channels)
+	}
+	return fmt.Sprintf("%d-bit %d Hz, %d channel(s)", in.bitDepth, in.sampleRate, in.channels)
+}
+
+// PrepareInput reads a WAV file, or a file with a registered InputDecoder,
+// and applies the input processing enabled in cfg, returning audio ready to
+// send in cfg.InputFormat and, for PCM, cfg.PCMByteOrder. Decoded files are
+// always converted to the format. Call it before NewSession so that a bad
+// file fails without dialing: files that are not WAV fail with
+// ErrUnsupportedWAV, or ErrNoInputDecoder too if they have another
+// extension, formats that cannot be converted with ErrInputFormat, and a
+// file without a whole sample of audio, such as a header-only WAV, with
+// ErrEmptyAudio rather than being sent as an empty turn. Audio longer than
+// cfg.MaxInputDuration after trimming fails with ErrInputTooLong.
+func PrepareInput(filename string, cfg Config) ([]byte, error) {
+	target := StreamConfig{InputFormat: cfg.InputFormat, SampleRate: cfg.SampleRate}
+	logger := configLogger(cfg, "input")
+
+	in, decoded, err := readInput(filename, target, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	audioData, err := matchInput(in, target, cfg.AutoConvertInput || decoded, logger)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+
+	if len(audioData) < cfg.InputFormat.BytesPerSample() {
+		return nil, fmt.Errorf("%s: %w", filename, ErrEmptyAudio)
+	}
+
+	// Trimming measures 16-bit samples, so encoded input is sent as is
+	if cfg.TrimSilenceDBFS < 0 && isPCM16(cfg.InputFormat) {
+		audioData = TrimSilence(audioData, target.Rate(), cfg.TrimSilenceDBFS)
+		if len(audioData) == 0 {
+			return nil, fmt.Errorf("%s: %w: all below %g dBFS", filename, ErrEmptyAudio, cfg.TrimSilenceDBFS)
+		}
+	}
+
+	if err := checkInputDuration(target, audioData, cfg.MaxInputDuration); err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+
+	if isBigEndian(cfg.PCMByteOrder) && isPCM16(cfg.InputFormat) {
+		audioData = swapPCM16(audioData)
+	}
+
+	return audioData, nil
+}
+
+// checkInputDuration fails with ErrInputTooLong if audio in cfg's format
+// lasts longer than limit. A limit of 0 or less means no limit.
+func checkInputDuration(cfg StreamConfig, audio []byte, limit time.Duration) error {
+	if limit <= 0 {
+		return nil
+	}
+	if d := cfg.Duration(len(audio)); d > limit {
+		return fmt.Errorf("%w: %s exceeds the limit of %s", ErrInputTooLong, d.Round(time.Millisecond), limit)
+	}
+	return nil
+}
+
+// matchInput returns the input audio in the target format. A mismatched file
+// is converted when convert is set and otherwise sent as is with a warning.
+func matchInput(in *wavInput, target StreamConfig, convert bool, logger *slog.Logger) ([]byte, error) {
+	pcmTarget := isPCM16(target.InputFormat)
+	rate := target.Rate()
+
+	matches := in.channels == 1 && in.sampleRate == rate
+	switch {
+	case pcmTarget:
+		matches = matches && in.format == wavFormatPCM && in.bitDepth == 16
+	case target.InputFormat == InputFormatMulaw8000:
+		matches = matches && in.format == wavFormatMulaw
+	case target.InputFormat == InputFormatAlaw8000:
+		matches = matches && in.format == wavFormatAlaw
+	default:
+		matches = false // WAV has no tag for custom codecs
+	}
+	if matches {
+		return in.data, nil
+	}
+
+	if !convert {
+		logger.Warn("⚠️  Input does not match the session format",
+			"input", in, "input_format", target.InputFormat, "sample_rate", rate)
+		return in.data, nil
+	}
+
+	var pcm []byte
+	switch {
+	case in.format == wavFormatPCM && in.bitDepth == 16:
+		pcm = in.data
+	case in.format == wavFormatMulaw:
+		pcm = pcm16Codec{}.Encode(mulawCodec{}.Decode(in.data))
+	case in.format == wavFormatAlaw:
+		pcm = pcm16Codec{}.Encode(alawCodec{}.Decode(in.data))
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrInputFormat, in)
+	}
+
+	codec, ok := LookupCodec(target.InputFormat)
+	if !ok {
+		return nil, fmt.Errorf("%w: no codec for %s", ErrInputFormat, target.InputFormat)
+	}
+
+	logger.Info("🔄 Converting input", "from", in, "input_format", target.InputFormat, "sample_rate", rate)
+	pcm = ConvertPCM(pcm, in.channels, in.sampleRate, rate)
+	if pcmTarget {
+		return pcm, nil
+	}
+	return codec.Encode(bytesToInt16(pcm)), nil
+}
+
+// readInput reads filename with the decoder registered for its extension,
+// reporting whether one was used, and as a WAV file otherwise, converted to
+// 16 bits if stored with another bit depth.
+func readInput(filename string, target StreamConfig, logger *slog.Logger) (*wavInput, bool, error) {
+	dec, ok := lookupInputDecoder(filename)
+	if !ok {
+		in, err := readWAV(filename)
+		if ext := filepath.Ext(filename); errors.Is(err, ErrUnsupportedWAV) && ext != "" && !strings.EqualFold(ext, ".wav") {
+			err = fmt.Errorf("%w: %w %s", err, ErrNoInputDecoder, ext)
+		}
+		if err != nil {
+			return nil, false, err
+		}
+		if err := in.toPCM16(logger); err != nil {
+			return nil, false, fmt.Errorf("%s: %w", filename, err)
+		}
+		return in, false, nil
+	}
+
+	audio, err := dec(filename, target)
+	if err != nil {
+		return nil, true, fmt.Errorf("%s: decode error: %w", filename, err)
+	}
+	if audio.Channels < 1 || audio.SampleRate <= 0 {
+		return nil, true, fmt.Errorf("%s: %w: decoded %d channel(s) at %d Hz", filename, ErrInputFormat, audio.Channels, audio.SampleRate)
+	}
+	if len(audio.PCM) == 0 {
+		return nil, true, fmt.Errorf("%s: %w", filename, ErrEmptyAudio)
+	}
+
+	return &wavInput{
+		data:       audio.PCM,
+		format:     wavFormatPCM,
+		channels:   audio.Channels,
+		sampleRate: audio.SampleRate,
+		bitDepth:   16,
+	}, true, nil
+}
+
+// readWAV reads the format and audio data of a WAV file.
+func readWAV(filename string) (*wavInput, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	if info, err := file.Stat(); err == nil && info.Size() == 0 {
+		return nil, fmt.Errorf("%s: %w", filename, ErrEmptyAudio)
+	}
+
+	decoder := wav.NewDecoder(file)
+	if !decoder.IsValidFile() {
+		return nil, fmt.Errorf("%s: %w", filename, ErrUnsupportedWAV)
+	}
+	if err := decoder.FwdToPCM(); err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+
+	// The decoder counts the pad byte after an odd-sized data chunk as
+	// audio, so the size is taken from the chunk header instead
+	size, err := dataChunkSize(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+
+	data, err := io.ReadAll(decoder.PCMChunk)
+	if int64(len(data)) > size && size > 0 {
+		data = data[:size]
+	}
+	if err == nil && len(data) == 0 {
+		// Streamed files may leave the data size unset, the audio runs to
+		// EOF. What follows a data chunk that is really empty is chunks,
+		// e.g. LIST/INFO, which must not be sent as audio.
+		data, err = io.ReadAll(file)
+		if err == nil && riffChunks(data) {
+			data = nil
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+
+	in := &wavInput{
+		data:       data,
+		format:     int(decoder.WavAudioFormat),
+		channels:   int(decoder.NumChans),
+		sampleRate: int(decoder.SampleRate),
+		bitDepth:   int(decoder.BitDepth),
+	}
+	return in, nil
+}
+
+// dataChunkSize returns the size in the header of the data chunk that file
+// is positioned at the start of.
+func dataChunkSize(file *os.File) (int64, error) {
+	offset, err := file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+
+	var size [4]byte
+	if _, err := file.ReadAt(size[:], offset-4); err != nil {
+		return 0, err
+	}
+	return int64(binary.LittleEndian.Uint32(size[:])), nil
+}
+
+// riffChunks reports whether data consists of whole RIFF chunks, each a
+// printable four-character ID and a size, that end together with data. The
+// pad byte after an odd-sized last chunk may be missing.
+func riffChunks(data []byte) bool {
+	if len(data) == 0 {
+		return false
+	}
+
+	for len(data) > 0 {
+		if len(data) < 8 {
+			return false
+		}
+		for _, c := range data[:4] {
+			if c < 0x20 || c > 0x7e {
+				return false
+			}
+		}
+
+		size := uint64(binary.LittleEndian.Uint32(data[4:8]))
+		end := 8 + size + size%2
+		if end > uint64(len(data)) {
+			return 8+size == uint64(len(data))
+		}
+		data = data[end:]
+	}
+	return true
+}
+
+// toPCM16 converts integer PCM of another bit depth and float audio to
+// 16-bit PCM, which is what the protocol carries. WAVE_FORMAT_EXTENSIBLE
+// files are taken to hold integer PCM, the common case for 24-bit audio.
+func (in *wavInput) toPCM16(logger *slog.Logger) error {
+	var float bool
+	switch in.format {
+	case wavFormatPCM, wavFormatExtensible:
+		if in.format == wavFormatPCM && in.bitDepth == 16 {
+			return nil
+		}
+	case wavFormatFloat:
+		float = true
+	default:
+		return nil // G.711 and others are matched or rejected as they are
+	}
+
+	from := in.String()
+	pcm, err := convertBitDepth(in.data, in.bitDepth, float)
+	if err != nil {
+		return err
+	}
+
+	in.data, in.format, in.bitDepth = pcm, wavFormatPCM, 16
+	if from != in.String() {
+		logger.Info("🔄 Converting input to 16-bit", "from", from)
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+//go:build ffmpeg
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// Builds with -tags ffmpeg decode compressed input files with an ffmpeg
+// subprocess, which must be on the PATH at run time.
+func init() {
+	for _, ext := range []string{".opus", ".ogg", ".mp3", ".m4a", ".flac"} {
+		RegisterInputDecoder(ext, FFmpegInputDecoder(""))
+	}
+}
+
+// FFmpegInputDecoder returns an InputDecoder that has the ffmpeg binary at
+// path, or "ffmpeg" on the PATH if empty, decode any file it can read to
+// mono 16-bit PCM at the target rate.
+func FFmpegInputDecoder(path string) InputDecoder {
+	if path == "" {
+		path = "ffmpeg"
+	}
+
+	return func(filename string, target StreamConfig) (DecodedInput, error) {
+		rate := target.Rate()
+		cmd := exec.Command(path,
+			"-hide_banner", "-loglevel", "error", "-nostdin",
+			"-i", filename,
+			"-f", "s16le", "-acodec", "pcm_s16le", "-ac", "1", "-ar", strconv.Itoa(rate),
+			"pipe:1",
+		)
+
+		var stdout, stderr bytes.Buffer
+		cmd.Stdout = &stdout
+		cmd.Stderr = &stderr
+
+		if err := cmd.Run(); err != nil {
+			if msg := strings.TrimSpace(stderr.String()); msg != "" {
+				return DecodedInput{}, fmt.Errorf("ffmpeg error: %w: %s", err, msg)
+			}
+			return DecodedInput{}, fmt.Errorf("ffmpeg error: %w", err)
+		}
+
+		return DecodedInput{PCM: stdout.Bytes(), Channels: 1, SampleRate: rate}, nil
+	}
+}
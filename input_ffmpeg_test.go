@@ -0,0 +1,84 @@
+//go:build ffmpeg
+
+package main
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFFmpegInputDecoderCommand(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs a shell script standing in for ffmpeg")
+	}
+
+	// A stand-in that records its arguments and prints fixed samples
+	dir := t.TempDir()
+	fake := filepath.Join(dir, "ffmpeg")
+	script := "#!/bin/sh\necho \"$@\" > \"$0.args\"\nprintf 'abcd'\n"
+	if err := os.WriteFile(fake, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := FFmpegInputDecoder(fake)("question.opus", StreamConfig{InputFormat: InputFormatMulaw8000})
+	if err != nil || string(out.PCM) != "abcd" || out.Channels != 1 || out.SampleRate != 8000 {
+		t.Errorf("decoder = %q, %d channel(s) at %d Hz, %v; want the samples ffmpeg printed, mono at 8000 Hz",
+			out.PCM, out.Channels, out.SampleRate, err)
+	}
+	args, err := os.ReadFile(fake + ".args")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "-i question.opus -f s16le -acodec pcm_s16le -ac 1 -ar 8000 pipe:1"; !strings.Contains(string(args), want) {
+		t.Errorf("ffmpeg ran with %q, want %q", args, want)
+	}
+
+	// A failing ffmpeg is reported with what it printed
+	failing := filepath.Join(dir, "failing")
+	if err := os.WriteFile(failing, []byte("#!/bin/sh\necho 'Invalid data found' >&2\nexit 1\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := FFmpegInputDecoder(failing)("question.opus", StreamConfig{}); err == nil || !strings.Contains(err.Error(), "Invalid data found") {
+		t.Errorf("decoder = %v, want ffmpeg's message", err)
+	}
+
+	for _, ext := range []string{".opus", ".MP3"} {
+		if _, ok := lookupInputDecoder("question" + ext); !ok {
+			t.Errorf("no decoder registered for %s", ext)
+		}
+	}
+}
+
+func TestFFmpegInputDecoderOpus(t *testing.T) {
+	if _, err := exec.LookPath("ffmpeg"); err != nil {
+		t.Skip("ffmpeg is not installed")
+	}
+
+	// Encode a short Opus fixture from a WAV, then stream it
+	question := writeTestWAV(t, "question.wav", loudPCM(500*time.Millisecond, 16000), 16000)
+	fixture := filepath.Join(t.TempDir(), "question.opus")
+	if out, err := exec.Command("ffmpeg", "-hide_banner", "-loglevel", "error", "-i", question, "-c:a", "libopus", fixture).CombinedOutput(); err != nil {
+		t.Skipf("ffmpeg cannot encode Opus: %v: %s", err, out)
+	}
+
+	srv := newConversationServer(nil)
+	defer srv.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	err := RunConversationWithOptions(ctx, testConfig(srv, Config{}), "agent", fixture, filepath.Join(t.TempDir(), "conversation.wav"), &ConversationOptions{
+		Completion: SilenceCompletion{Threshold: 200 * time.Millisecond},
+	})
+	if err != nil {
+		t.Fatalf("RunConversationWithOptions: %v", err)
+	}
+	// Opus pads the start, so allow a frame more than the WAV
+	if n := mediaFrames(srv); n < questionFrames || n > questionFrames+1 {
+		t.Errorf("server received %d media_input frames, want about %d", n, questionFrames)
+	}
+}
@@ -0,0 +1,342 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeStreamedWAV writes a 16 kHz mono WAV whose data chunk size is left
+// at 0, as streaming writers do, followed by rest.
+func writeStreamedWAV(t *testing.T, name string, rest []byte) string {
+	t.Helper()
+
+	h := &wavHeader{tag: wavFormatPCM, sampleRate: 16000, sampleBytes: 2}
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, append(h.bytes(0), rest...), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestPrepareInputZeroDataSize(t *testing.T) {
+	cfg := Config{InputFormat: InputFormatPCM16000}
+
+	// Audio after an unset data size runs to the end of the file
+	audio := make([]byte, 3200)
+	for i := range audio {
+		audio[i] = byte(i * 7)
+	}
+	data, err := PrepareInput(writeStreamedWAV(t, "stream.wav", audio), cfg)
+	if err != nil || !bytes.Equal(data, audio) {
+		t.Errorf("PrepareInput of a streamed WAV = %d bytes, %v; want its %d bytes of audio", len(data), err, len(audio))
+	}
+
+	// Chunks after a data chunk that is really empty are not audio
+	list := append([]byte("LIST\x06\x00\x00\x00"), "INFOab"...)
+	if _, err := PrepareInput(writeStreamedWAV(t, "list.wav", list), cfg); !errors.Is(err, ErrEmptyAudio) {
+		t.Errorf("PrepareInput of an empty WAV with a LIST chunk = %v, want ErrEmptyAudio", err)
+	}
+}
+
+func TestRunConversationRejectsEmptyInput(t *testing.T) {
+	srv := NewTestServer(nil)
+	defer srv.Close()
+
+	list := append([]byte("LIST\x06\x00\x00\x00"), "INFOab"...)
+	input := writeStreamedWAV(t, "list.wav", list)
+	output := filepath.Join(t.TempDir(), "conversation.wav")
+
+	err := RunConversation(context.Background(), testConfig(srv, Config{}), "agent", input, output)
+	if !errors.Is(err, ErrEmptyAudio) {
+		t.Fatalf("RunConversation = %v, want ErrEmptyAudio", err)
+	}
+	if n := len(srv.Received()); n != 0 {
+		t.Errorf("server received %d messages, want none before the input is read", n)
+	}
+}
+
+func TestRunConversationEmptyFiles(t *testing.T) {
+	srv := NewTestServer(nil)
+	defer srv.Close()
+
+	h := &wavHeader{tag: wavFormatPCM, sampleRate: 16000, sampleBytes: 2}
+	files := map[string][]byte{
+		"zero-byte":     nil,
+		"header-only":   h.bytes(0),
+		"half a sample": append(h.bytes(1), 0x7f, 0),
+	}
+	for name, file := range files {
+		input := filepath.Join(t.TempDir(), "question.wav")
+		if err := os.WriteFile(input, file, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		output := filepath.Join(t.TempDir(), "conversation.wav")
+
+		err := RunConversation(context.Background(), testConfig(srv, Config{}), "agent", input, output)
+		if !errors.Is(err, ErrEmptyAudio) {
+			t.Errorf("RunConversation of a %s file = %v, want ErrEmptyAudio", name, err)
+		}
+	}
+	if n := len(srv.Received()); n != 0 {
+		t.Errorf("server received %d messages, want none for empty input", n)
+	}
+}
+
+func TestReadWAVOddDataSize(t *testing.T) {
+	// 8-bit audio may fill an odd-sized data chunk, followed by a pad byte
+	// and further chunks
+	audio := bytes.Repeat([]byte{0x7f}, 801)
+	h := &wavHeader{tag: wavFormatMulaw, sampleRate: 8000, sampleBytes: 1}
+	file := append(h.bytes(int64(len(audio))), audio...)
+	file = append(file, 0)
+	file = append(file, "LIST\x06\x00\x00\x00INFOab"...)
+
+	path := filepath.Join(t.TempDir(), "odd.wav")
+	if err := os.WriteFile(path, file, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := PrepareInput(path, Config{InputFormat: InputFormatMulaw8000})
+	if err != nil || !bytes.Equal(data, audio) {
+		t.Errorf("PrepareInput = %d bytes, %v; want the %d bytes of audio", len(data), err, len(audio))
+	}
+}
+
+func TestPrepareInputTrimSilence(t *testing.T) {
+	// 305ms of low noise around -76 dBFS, 500ms of speech, 200ms of noise
+	speech := loudPCM(500*time.Millisecond, 16000)
+	pcm := append(pcmOf(4880, 5), speech...)
+	pcm = append(pcm, pcmOf(3200, -5)...)
+	path := writeTestWAV(t, "padded.wav", pcm, 16000)
+
+	data, err := PrepareInput(path, Config{InputFormat: InputFormatPCM16000, TrimSilenceDBFS: -50})
+	if err != nil {
+		t.Fatalf("PrepareInput: %v", err)
+	}
+	// Trimming works in 10ms windows, leaving up to 10ms of noise at each end
+	if len(data) < len(speech) || len(data) > len(speech)+2*320 {
+		t.Errorf("trimmed input is %d bytes, want the %d of speech within 10ms", len(data), len(speech))
+	}
+	if !bytes.Contains(data, speech) {
+		t.Error("trimmed input lost some of the speech")
+	}
+
+	untrimmed, err := PrepareInput(path, Config{InputFormat: InputFormatPCM16000})
+	if err != nil || len(untrimmed) != len(pcm) {
+		t.Errorf("PrepareInput without trimming = %d bytes, %v; want all %d", len(untrimmed), err, len(pcm))
+	}
+
+	silent := writeTestWAV(t, "silent.wav", pcmOf(16000, 5), 16000)
+	if _, err := PrepareInput(silent, Config{InputFormat: InputFormatPCM16000, TrimSilenceDBFS: -50}); !errors.Is(err, ErrEmptyAudio) {
+		t.Errorf("PrepareInput of a silent file = %v, want ErrEmptyAudio", err)
+	}
+}
+
+func TestPrepareInput(t *testing.T) {
+	speech := loudPCM(500*time.Millisecond, 16000)
+	valid := writeTestWAV(t, "question.wav", speech, 16000)
+	gsm := &wavHeader{tag: 0x31, sampleRate: 8000, sampleBytes: 1}
+	corrupt := filepath.Join(t.TempDir(), "corrupt.wav")
+	if err := os.WriteFile(corrupt, []byte("this is not a WAV file at all, just text"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	truncated := filepath.Join(t.TempDir(), "truncated.wav")
+	if err := os.WriteFile(truncated, (&wavHeader{tag: wavFormatPCM, sampleRate: 16000, sampleBytes: 2}).bytes(3200)[:20], 0o644); err != nil {
+		t.Fatal(err)
+	}
+	wrongFormat := filepath.Join(t.TempDir(), "gsm.wav")
+	if err := os.WriteFile(wrongFormat, append(gsm.bytes(65), make([]byte, 66)...), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		input string
+		cfg   Config
+		size  int   // of the prepared audio
+		err   error // nil for any error when size is 0
+	}{
+		{"valid", valid, Config{InputFormat: InputFormatPCM16000}, len(speech), nil},
+		{"converted", valid, Config{InputFormat: InputFormatMulaw8000, AutoConvertInput: true}, 4000, nil},
+		{"wrong format", wrongFormat, Config{InputFormat: InputFormatPCM16000, AutoConvertInput: true}, 0, ErrInputFormat},
+		{"corrupt", corrupt, Config{InputFormat: InputFormatPCM16000}, 0, ErrUnsupportedWAV},
+		{"truncated", truncated, Config{InputFormat: InputFormatPCM16000}, 0, nil},
+		{"missing", filepath.Join(t.TempDir(), "missing.wav"), Config{InputFormat: InputFormatPCM16000}, 0, os.ErrNotExist},
+	}
+	for _, tt := range tests {
+		data, err := PrepareInput(tt.input, tt.cfg)
+		if tt.size > 0 {
+			if err != nil || len(data) != tt.size {
+				t.Errorf("%s: PrepareInput = %d bytes, %v; want %d bytes", tt.name, len(data), err, tt.size)
+			}
+			continue
+		}
+		if err == nil || tt.err != nil && !errors.Is(err, tt.err) {
+			t.Errorf("%s: PrepareInput = %v, want %v", tt.name, err, tt.err)
+		}
+	}
+
+	// Bad input fails RunConversation before it dials
+	srv := NewTestServer(nil)
+	defer srv.Close()
+	output := filepath.Join(t.TempDir(), "conversation.wav")
+	if err := RunConversation(context.Background(), testConfig(srv, Config{}), "agent", corrupt, output); !errors.Is(err, ErrUnsupportedWAV) {
+		t.Errorf("RunConversation of a corrupt file = %v, want ErrUnsupportedWAV", err)
+	}
+	if n := len(srv.Received()); n != 0 {
+		t.Errorf("server received %d messages, want none", n)
+	}
+}
+
+func TestPrepareInputBitDepths(t *testing.T) {
+	le := binary.LittleEndian
+	float32s := func(values ...float32) []byte {
+		var b []byte
+		for _, v := range values {
+			b = le.AppendUint32(b, math.Float32bits(v))
+		}
+		return b
+	}
+	// Half scale, a quarter below zero, full scale both ways and a value
+	// too small for 16 bits
+	want := []int16{16384, -8192, 32767, -32768, 0}
+	tests := []struct {
+		name  string
+		h     wavHeader
+		data  []byte
+		want  []int16
+		exact bool // no dither from 8 bits
+	}{
+		{"8-bit", wavHeader{tag: wavFormatPCM, sampleRate: 16000, sampleBytes: 1},
+			[]byte{0xc0, 0x60, 0xff, 0x00, 0x80}, []int16{16384, -8192, 32512, -32768, 0}, true},
+		{"24-bit", wavHeader{tag: wavFormatPCM, sampleRate: 16000, sampleBytes: 3},
+			[]byte{0, 0, 0x40, 0, 0, 0xe0, 0xff, 0xff, 0x7f, 0, 0, 0x80, 0x40, 0, 0}, want, false},
+		{"32-bit", wavHeader{tag: wavFormatPCM, sampleRate: 16000, sampleBytes: 4},
+			[]byte{0, 0, 0, 0x40, 0, 0, 0, 0xe0, 0xff, 0xff, 0xff, 0x7f, 0, 0, 0, 0x80, 0, 0x40, 0, 0}, want, false},
+		{"float", wavHeader{tag: wavFormatFloat, sampleRate: 16000, sampleBytes: 4},
+			float32s(0.5, -0.25, 1.5, -1, 1.0/131072), want, false},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(t.TempDir(), "question.wav")
+		file := append(tt.h.bytes(int64(len(tt.data))), tt.data...)
+		if len(tt.data)%2 == 1 {
+			file = append(file, 0)
+		}
+		if err := os.WriteFile(path, file, 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		data, err := PrepareInput(path, Config{InputFormat: InputFormatPCM16000})
+		if err != nil || len(data) != 2*len(tt.want) {
+			t.Fatalf("%s: PrepareInput = %d bytes, %v; want %d samples", tt.name, len(data), err, len(tt.want))
+		}
+		for i, w := range tt.want {
+			got := int16(le.Uint16(data[2*i:]))
+			// Dither moves samples by up to one step
+			if d := int(got) - int(w); tt.exact && d != 0 || d < -1 || d > 1 {
+				t.Errorf("%s: sample %d = %d, want %d", tt.name, i, got, w)
+			}
+		}
+	}
+}
+
+func TestInputDecoder(t *testing.T) {
+	dir := t.TempDir()
+	question := filepath.Join(dir, "question.testopus")
+	if err := os.WriteFile(question, []byte("OggS"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Without a decoder the file is reported as neither WAV nor decodable
+	_, err := PrepareInput(question, Config{InputFormat: InputFormatPCM16000})
+	if !errors.Is(err, ErrNoInputDecoder) || !errors.Is(err, ErrUnsupportedWAV) {
+		t.Errorf("PrepareInput without a decoder = %v, want ErrNoInputDecoder and ErrUnsupportedWAV", err)
+	}
+
+	// A decoder giving 500ms of stereo at 48 kHz, as a second of mono
+	// samples, converted for the session
+	var decoded []string
+	RegisterInputDecoder(".TESTOPUS", func(filename string, target StreamConfig) (DecodedInput, error) {
+		decoded = append(decoded, filepath.Base(filename))
+		if filepath.Base(filename) == "broken.testopus" {
+			return DecodedInput{}, errors.New("corrupt page")
+		}
+		return DecodedInput{PCM: loudPCM(time.Second, 48000), Channels: 2, SampleRate: 48000}, nil
+	})
+	t.Cleanup(func() {
+		inputDecodersMu.Lock()
+		defer inputDecodersMu.Unlock()
+		delete(inputDecoders, ".testopus")
+	})
+
+	srv := newConversationServer(nil)
+	defer srv.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	err = RunConversationWithOptions(ctx, testConfig(srv, Config{}), "agent", question, filepath.Join(dir, "conversation.wav"), &ConversationOptions{
+		Completion: SilenceCompletion{Threshold: 200 * time.Millisecond},
+	})
+	if err != nil {
+		t.Fatalf("RunConversationWithOptions: %v", err)
+	}
+	if n := mediaFrames(srv); n != questionFrames {
+		t.Errorf("server received %d media_input frames, want %d", n, questionFrames)
+	}
+	for _, msg := range srv.Received() {
+		if m, ok := msg.(*MediaInputMessage); ok {
+			if data, _ := base64.StdEncoding.DecodeString(m.Media.Payload); len(data) != 3200 || data[1] != 0x40 {
+				t.Fatalf("first frame = %d bytes starting %v, want 100ms of the decoded level", len(data), data[:2])
+			}
+			break
+		}
+	}
+
+	// Decode errors name the file
+	_, err = PrepareInput(filepath.Join(dir, "broken.testopus"), Config{InputFormat: InputFormatPCM16000})
+	if err == nil || !strings.Contains(err.Error(), "broken.testopus") || !strings.Contains(err.Error(), "corrupt page") {
+		t.Errorf("PrepareInput with a failing decoder = %v, want the file and the decoder's error", err)
+	}
+	if want := []string{"question.testopus", "broken.testopus"}; !slices.Equal(decoded, want) {
+		t.Errorf("decoder ran for %v, want %v", decoded, want)
+	}
+}
+
+func TestMaxInputDuration(t *testing.T) {
+	srv := newConversationServer(nil)
+	defer srv.Close()
+
+	// A file over the limit fails before the session is dialled
+	if _, err := runTestConversation(t, srv, Config{MaxInputDuration: 400 * time.Millisecond}, nil); !errors.Is(err, ErrInputTooLong) {
+		t.Fatalf("RunConversationWithOptions = %v, want ErrInputTooLong", err)
+	}
+	if n := len(srv.Received()); n != 0 {
+		t.Fatalf("server received %d messages, want none", n)
+	}
+
+	// StreamAudio checks audio against the conversation's limit
+	session := newTestSession(t, srv, Config{})
+	defer session.Close()
+	conversation := NewConversation(session, nil)
+	conversation.SetMaxInputDuration(2 * time.Second)
+	audio := make([]byte, session.Config().BytesForDuration(3*time.Second))
+	err := conversation.StreamAudio(context.Background(), audio)
+	if !errors.Is(err, ErrInputTooLong) || !strings.Contains(err.Error(), "3s") {
+		t.Errorf("StreamAudio of 3s = %v, want ErrInputTooLong with the duration", err)
+	}
+	if n := mediaFrames(srv); n != 0 {
+		t.Errorf("server received %d media_input frames, want none", n)
+	}
+	if err := conversation.StreamAudio(context.Background(), audio[:len(audio)*2/3]); err != nil {
+		t.Errorf("StreamAudio of 2s = %v, want it sent", err)
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// PlainHandler is a slog.Handler writing one line per record in the form
+// "LEVEL component message key=value ...", without timestamps or emoji, for
+// log aggregation. The component is taken from a "component" attribute, as
+// set by the client on its own records, and is "-" when there is none.
+// Installed with slog.SetDefault it also receives the log package's output,
+// whose leading emoji it drops.
+type PlainHandler struct {
+	mu    *sync.Mutex
+	w     io.Writer
+	level slog.Leveler
+
+	component string
+	attrs     string // preformatted attributes from WithAttrs
+	group     string // key prefix from WithGroup
+}
+
+// NewPlainHandler creates a PlainHandler writing records at or above level
+// to w.
+func NewPlainHandler(w io.Writer, level slog.Leveler) *PlainHandler {
+	if level == nil {
+		level = slog.LevelInfo
+	}
+	return &PlainHandler{mu: &sync.Mutex{}, w: w, level: level, component: "-"}
+}
+
+func (h *PlainHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h *PlainHandler) Handle(_ context.Context, r slog.Record) error {
+	component := h.component
+	var attrs strings.Builder
+	attrs.WriteString(h.attrs)
+	r.Attrs(func(a slog.Attr) bool {
+		if h.group == "" && a.Key == "component" {
+			component = a.Value.String()
+			return true
+		}
+		appendAttr(&attrs, h.group, a)
+		return true
+	})
+
+	var line strings.Builder
+	line.WriteString(r.Level.String())
+	line.WriteByte(' ')
+	line.WriteString(component)
+	line.WriteByte(' ')
+	line.WriteString(stripEmoji(r.Message))
+	line.WriteString(attrs.String())
+	line.WriteByte('\n')
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	_, err := io.WriteString(h.w, line.String())
+	return err
+}
+
+func (h *PlainHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	c := *h
+	var b strings.Builder
+	b.WriteString(h.attrs)
+	for _, a := range attrs {
+		if h.group == "" && a.Key == "component" {
+			c.component = a.Value.String()
+			continue
+		}
+		appendAttr(&b, h.group, a)
+	}
+	c.attrs = b.String()
+	return &c
+}
+
+func (h *PlainHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	c := *h
+	c.group = h.group + name + "."
+	return &c
+}
+
+// appendAttr writes " key=value" for a, flattening groups into dotted keys.
+func appendAttr(b *strings.Builder, prefix string, a slog.Attr) {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return
+	}
+
+	if a.Value.Kind() == slog.KindGroup {
+		if a.Key != "" {
+			prefix += a.Key + "."
+		}
+		for _, ga := range a.Value.Group() {
+			appendAttr(b, prefix, ga)
+		}
+		return
+	}
+
+	b.WriteByte(' ')
+	b.WriteString(prefix)
+	b.WriteString(a.Key)
+	b.WriteByte('=')
+	b.WriteString(plainValue(a.Value.String()))
+}
+
+// stripEmoji removes the emoji, and the space after them, that prefix
+// interactive log messages such as "⚠️  Decode error".
+func stripEmoji(msg string) string {
+	return strings.TrimLeftFunc(msg, func(r rune) bool {
+		return unicode.Is(unicode.So, r) || r == '\uFE0F' || unicode.IsSpace(r)
+	})
+}
+
+// plainValue quotes values that would otherwise be ambiguous to split.
+func plainValue(s string) string {
+	if s == "" || strings.ContainsAny(s, " =\"\t\n") {
+		return strconv.Quote(s)
+	}
+	return s
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"log"
+	"log/slog"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+	"unicode"
+)
+
+func TestPlainHandlerHandshake(t *testing.T) {
+	srv := NewTestServer(nil)
+	defer srv.Close()
+
+	var out logBuffer
+	session := newTestSession(t, srv, Config{Logger: slog.New(NewPlainHandler(&out, nil))})
+	session.Close()
+
+	var handshake string
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		if strings.ContainsFunc(line, func(r rune) bool { return unicode.Is(unicode.So, r) }) {
+			t.Errorf("line %q has emoji", line)
+		}
+		if !regexp.MustCompile(`^(DEBUG|INFO|WARN|ERROR) (client|session|-) \S`).MatchString(line) {
+			t.Errorf("line %q does not start with a level and component", line)
+		}
+		if strings.Contains(line, "Handshake successful") {
+			handshake = line
+		}
+	}
+	want := regexp.MustCompile(`^INFO client Handshake successful stream_id=\S+ input_format=pcm_16000 sample_rate=16000 duration=\S+$`)
+	if !want.MatchString(handshake) {
+		t.Errorf("handshake line = %q, want it to match %s", handshake, want)
+	}
+}
+
+func TestPlainHandler(t *testing.T) {
+	var out strings.Builder
+	logger := slog.New(NewPlainHandler(&out, slog.LevelWarn))
+
+	logger.Info("not shown")
+	logger.With("component", "recorder").WithGroup("file").Warn("⚠️  Write failed",
+		"path", "/tmp/my call.wav", slog.Group("stats", "frames", 10), "component", "ignored")
+	logger.Error("Gave up", "err", `bad "frame"`)
+
+	want := "WARN recorder Write failed file.path=\"/tmp/my call.wav\" file.stats.frames=10 file.component=ignored\n" +
+		"ERROR - Gave up err=\"bad \\\"frame\\\"\"\n"
+	if out.String() != want {
+		t.Errorf("output = %q, want %q", out.String(), want)
+	}
+}
+
+func TestPlainHandlerConversation(t *testing.T) {
+	srv := newConversationServer(nil)
+	defer srv.Close()
+
+	// Nothing goes to the log package's output instead
+	var global logBuffer
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(&global)
+
+	// The 16 kHz question is converted for a 24 kHz session
+	var out logBuffer
+	cfg := Config{
+		InputFormat:      InputFormatPCM24000,
+		AutoConvertInput: true,
+		Logger:           slog.New(NewPlainHandler(&out, nil)),
+	}
+	if _, err := runTestConversation(t, srv, cfg, nil); err != nil {
+		t.Fatalf("RunConversationWithOptions: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	for _, want := range []string{
+		"INFO input Converting input from=",
+		"INFO conversation Greeting complete",
+		"INFO conversation Sending question...",
+		"INFO conversation Response complete",
+		"INFO conversation Recorded duration=",
+	} {
+		if !slices.ContainsFunc(lines, func(line string) bool { return strings.HasPrefix(line, want) }) {
+			t.Errorf("no line starting %q in:\n%s", want, out.String())
+		}
+	}
+	for _, line := range lines {
+		if strings.ContainsFunc(line, func(r rune) bool { return unicode.Is(unicode.So, r) }) {
+			t.Errorf("line %q has emoji", line)
+		}
+	}
+	if s := global.String(); strings.Contains(s, "component=conversation") || strings.Contains(s, "component=input") {
+		t.Errorf("log package received conversation records:\n%s", s)
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	chunkDuration = 100 * time.Millisecond // audio per media_input frame
+	chunkInterval = 10 * time.Millisecond  // pause between frames
+)
+
+// streamChunks splits data into chunkSize pieces and hands them to send,
+// pausing chunkInterval on clock between chunks to simulate real-time
+// streaming.
+// If ctx expires part way, ErrSendDeadline reports how much was sent.
+func streamChunks(ctx context.Context, clock Clock, data []byte, chunkSize int, send func(chunk []byte) error) error {
+	for offset := 0; offset < len(data); offset += chunkSize {
+		if ctx.Err() != nil {
+			return streamStopped(ctx, offset, len(data))
+		}
+
+		end := min(offset+chunkSize, len(data))
+
+		if err := send(data[offset:end]); err != nil {
+			return err
+		}
+
+		select {
+		case <-clock.After(chunkInterval):
+		case <-ctx.Done():
+			return streamStopped(ctx, end, len(data))
+		}
+	}
+
+	return nil
+}
+
+// streamStopped returns the error for a stream interrupted by ctx after sent
+// of total bytes.
+func streamStopped(ctx context.Context, sent, total int) error {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("%w: %d of %d bytes sent: %w", ErrSendDeadline, sent, total, ctx.Err())
+	}
+	return ctx.Err()
+}
+
+// NewMediaInputFromPCM wraps raw audio in the session's input format in a
+// media_input message, base64 encoding the payload.
+func NewMediaInputFromPCM(streamID string, pcm []byte) *MediaInputMessage {
+	return &MediaInputMessage{
+		Event:    MessageTypeMediaInput,
+		StreamID: streamID,
+		Media:    Media{Payload: base64.StdEncoding.EncodeToString(pcm)},
+	}
+}
+
+// NewMediaOutputFromPCM wraps agent audio in a media_output message, e.g.
+// for a TestServer script.
+func NewMediaOutputFromPCM(streamID string, pcm []byte) *MediaOutputMessage {
+	return &MediaOutputMessage{
+		Event:    MessageTypeMediaOutput,
+		StreamID: streamID,
+		Media:    Media{Payload: base64.StdEncoding.EncodeToString(pcm)},
+	}
+}
+
+// NewMediaInputFromSamples encodes 16-bit samples as little-endian PCM and
+// wraps them in a media_input message.
+func NewMediaInputFromSamples(streamID string, samples []int16) *MediaInputMessage {
+	return NewMediaInputFromPCM(streamID, pcm16Codec{}.Encode(samples))
+}
+
+var (
+	ErrMediaTooLarge = errors.New("media payload too large")
+	ErrInvalidMedia  = errors.New("invalid media payload")
+	ErrSendDeadline  = errors.New("deadline exceeded while streaming audio")
+)
+
+// validateEncodedMedia checks that payload is padded standard base64 that
+// decodes to whole samples in cfg, without decoding it.
+func validateEncodedMedia(payload string, cfg StreamConfig) error {
+	if payload == "" {
+		return fmt.Errorf("%w: empty", ErrInvalidMedia)
+	}
+	if len(payload)%4 != 0 {
+		return fmt.Errorf("%w: length %d is not a multiple of 4", ErrInvalidMedia, len(payload))
+	}
+
+	data := strings.TrimSuffix(strings.TrimSuffix(payload, "="), "=")
+	for i := 0; i < len(data); i++ {
+		c := data[i]
+		if !('A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '+' || c == '/') {
+			return fmt.Errorf("%w: illegal base64 byte %q at offset %d", ErrInvalidMedia, c, i)
+		}
+	}
+
+	if n, sample := decodedSize(payload), cfg.InputFormat.BytesPerSample(); n%sample != 0 {
+		return fmt.Errorf("%w: %d bytes is not a whole number of %d-byte samples", ErrInvalidMedia, n, sample)
+	}
+	return nil
+}
+
+// base64Encodings are the alphabets tried when a media payload fails to decode.
+var base64Encodings = []struct {
+	name string
+	enc  *base64.Encoding
+}{
+	{"standard", base64.StdEncoding},
+	{"url-safe", base64.URLEncoding},
+	{"raw standard", base64.RawStdEncoding},
+	{"raw url-safe", base64.RawURLEncoding},
+}
+
+// mediaDecoder decodes base64 media payloads. When the current alphabet fails
+// it tries the others and keeps the first that works for later frames.
+// Payloads that would decode to more than maxBytes are rejected unread.
+type mediaDecoder struct {
+	mu       sync.Mutex
+	enc      *base64.Encoding
+	maxBytes int // 0 for no limit
+	logger   *slog.Logger
+}
+
+func newMediaDecoder(enc *base64.Encoding, maxBytes int, logger *slog.Logger) *mediaDecoder {
+	if enc == nil {
+		enc = base64.StdEncoding
+	}
+	return &mediaDecoder{enc: enc, maxBytes: maxBytes, logger: logger}
+}
+
+func (d *mediaDecoder) Decode(payload string) ([]byte, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	// The size without padding is the same in every alphabet, so it holds
+	// for the fallbacks too
+	if size := decodedSize(payload); d.maxBytes > 0 && size > d.maxBytes {
+		return nil, fmt.Errorf("%w: %d bytes, limit %d", ErrMediaTooLarge, size, d.maxBytes)
+	}
+
+	data, err := d.enc.DecodeString(payload)
+	if err == nil {
+		return data, nil
+	}
+
+	for _, alt := range base64Encodings {
+		if alt.enc == d.enc {
+			continue
+		}
+		if altData, altErr := alt.enc.DecodeString(payload); altErr == nil {
+			d.logger.Warn("Media payload decode failed, switching alphabet", "base64", alt.name)
+			d.enc = alt.enc
+			return altData, nil
+		}
+	}
+
+	return nil, err
+}
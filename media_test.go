@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDecodeMediaMaxBytes(t *testing.T) {
+	srv := NewTestServer(nil)
+	defer srv.Close()
+
+	session := newTestSession(t, srv, Config{MaxMediaBytes: 4})
+
+	// Padding does not count towards the limit, whatever its length
+	for n := 1; n <= 6; n++ {
+		payload := base64.StdEncoding.EncodeToString(make([]byte, n))
+		data, err := session.DecodeMedia(payload)
+		if n <= 4 {
+			if err != nil || len(data) != n {
+				t.Errorf("DecodeMedia of %d bytes (%q) = %d bytes, %v; want it accepted", n, payload, len(data), err)
+			}
+			continue
+		}
+		if !errors.Is(err, ErrMediaTooLarge) {
+			t.Errorf("DecodeMedia of %d bytes (%q) = %v, want ErrMediaTooLarge", n, payload, err)
+		}
+	}
+}
+
+func TestDecodeMediaFallsBackToOtherAlphabets(t *testing.T) {
+	// Bytes that encode to '-' and '_' in url-safe base64, without padding
+	audio := bytes.Repeat([]byte{0xfb, 0xff}, 1601)
+	payload := base64.RawURLEncoding.EncodeToString(audio)
+
+	srv := NewTestServer(&TestServerOptions{
+		OnStart: func(start *StartMessage) []Message {
+			media := func(payload string) Message {
+				return &MediaOutputMessage{Event: MessageTypeMediaOutput, StreamID: start.StreamID, Media: Media{Payload: payload}}
+			}
+			return []Message{media("not base64!"), media(payload)}
+		},
+	})
+	defer srv.Close()
+
+	session := newTestSession(t, srv, Config{})
+	recorder := &testRecorder{}
+	conversation := NewConversation(session, recorder)
+	if err := conversation.DrainUntilSilence(context.Background(), 300*time.Millisecond); err != nil {
+		t.Fatalf("DrainUntilSilence: %v", err)
+	}
+	if n := recorder.rightBytes(); n != len(audio) {
+		t.Errorf("recorded %d bytes, want the %d of the url-safe frame", n, len(audio))
+	}
+
+	// Later frames are decoded with the alphabet that worked
+	data, err := session.DecodeMedia(base64.RawURLEncoding.EncodeToString(audio[:4]))
+	if err != nil || !bytes.Equal(data, audio[:4]) {
+		t.Errorf("DecodeMedia = %x, %v; want %x", data, err, audio[:4])
+	}
+}
+
+func TestNewMediaInputFromSamples(t *testing.T) {
+	received := make(chan *MediaInputMessage, 2)
+	srv := NewTestServer(&TestServerOptions{
+		Respond: func(msg Message) []Message {
+			if m, ok := msg.(*MediaInputMessage); ok {
+				received <- m
+			}
+			return nil
+		},
+	})
+	defer srv.Close()
+
+	session := newTestSession(t, srv, Config{})
+	samples := []int16{0, 1, -1, 256, -32768, 32767}
+	pcm := []byte{0, 0, 1, 0, 0xff, 0xff, 0, 1, 0, 0x80, 0xff, 0x7f}
+	for _, msg := range []*MediaInputMessage{
+		NewMediaInputFromSamples(session.StreamID(), samples),
+		NewMediaInputFromPCM(session.StreamID(), pcm),
+	} {
+		if err := session.Send(context.Background(), msg); err != nil {
+			t.Fatalf("Send: %v", err)
+		}
+	}
+
+	for i := 0; i < 2; i++ {
+		select {
+		case m := <-received:
+			data, err := base64.StdEncoding.DecodeString(m.Media.Payload)
+			if err != nil || m.StreamID != session.StreamID() {
+				t.Fatalf("media_input on stream %q with payload %q: %v", m.StreamID, m.Media.Payload, err)
+			}
+			if !bytes.Equal(data, pcm) {
+				t.Errorf("frame %d decodes to %x, want the little-endian samples %x", i, data, pcm)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("server received no media_input")
+		}
+	}
+}
+
+// logBuffer collects log output written concurrently by session workers.
+type logBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf.Write(p)
+}
+
+func (b *logBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf.String()
+}
+
+func TestDecodeMediaDebugLog(t *testing.T) {
+	for _, level := range []slog.Level{slog.LevelDebug, slog.LevelInfo} {
+		srv := NewTestServer(&TestServerOptions{
+			OnStart: func(start *StartMessage) []Message {
+				return []Message{NewMediaOutputFromPCM(start.StreamID, loudPCM(100*time.Millisecond, 16000))}
+			},
+		})
+		defer srv.Close()
+
+		var out logBuffer
+		logger := slog.New(slog.NewTextHandler(&out, &slog.HandlerOptions{Level: level}))
+		session := newTestSession(t, srv, Config{Logger: logger})
+		if err := NewConversation(session, nil).DrainUntilSilence(context.Background(), 300*time.Millisecond); err != nil {
+			t.Fatalf("DrainUntilSilence: %v", err)
+		}
+
+		// 100ms of 16 kHz PCM
+		logged := strings.Contains(out.String(), "bytes=3200 duration=100ms")
+		if want := level == slog.LevelDebug; logged != want {
+			t.Errorf("at level %s the decoded media line was logged: %t, want %t\n%s", level, logged, want, out.String())
+		}
+	}
+}
+
+func TestSendEncodedMedia(t *testing.T) {
+	payloads := make(chan string, 10)
+	srv := NewTestServer(&TestServerOptions{
+		Respond: func(msg Message) []Message {
+			if m, ok := msg.(*MediaInputMessage); ok {
+				payloads <- m.Media.Payload
+			}
+			return nil
+		},
+	})
+	defer srv.Close()
+
+	tests := []struct {
+		format  InputFormat
+		payload string
+		ok      bool
+	}{
+		// Unused trailing bits would be cleared by a decode and re-encode
+		{InputFormatPCM16000, "AAECAx==", true},
+		{InputFormatPCM16000, base64.StdEncoding.EncodeToString(loudPCM(20*time.Millisecond, 16000)), true},
+		{InputFormatMulaw8000, "AAEC", true}, // one byte a sample
+		{InputFormatPCM16000, "AAEC", false}, // half a sample left over
+		{InputFormatPCM16000, "", false},
+		{InputFormatPCM16000, "AAECAw", false},
+		{InputFormatPCM16000, "AA-_AA==", false},
+	}
+	for _, tt := range tests {
+		session := newTestSession(t, srv, Config{InputFormat: tt.format})
+		err := session.SendEncodedMedia(context.Background(), tt.payload)
+		if !tt.ok {
+			if !errors.Is(err, ErrInvalidMedia) {
+				t.Errorf("SendEncodedMedia(%q) in %s = %v, want ErrInvalidMedia", tt.payload, tt.format, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("SendEncodedMedia(%q) in %s: %v", tt.payload, tt.format, err)
+		}
+		select {
+		case got := <-payloads:
+			if got != tt.payload {
+				t.Errorf("server received %q, want %q unchanged", got, tt.payload)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("server received no media_input for %q", tt.payload)
+		}
+	}
+
+	// Rejected payloads were never sent
+	if n := mediaFrames(srv); n != 3 {
+		t.Errorf("server received %d media_input messages, want 3", n)
+	}
+}
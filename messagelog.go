@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// LoggedMessage is a message sent or received by a session, see
+// Config.LogMessages.
+type LoggedMessage struct {
+	Time      time.Time
+	Direction string      // "in" from the agent, "out" to it
+	Type      MessageType // the event, also set for raw messages
+	Message   Message     // nil for messages sent with SendJSON
+	Raw       json.RawMessage
+}
+
+// messageLog keeps every message of a session in memory. A nil log records
+// nothing.
+type messageLog struct {
+	mu      sync.Mutex
+	entries []LoggedMessage
+}
+
+// add records m, or raw for messages without a typed form.
+func (l *messageLog) add(direction string, typ MessageType, m Message, raw json.RawMessage) {
+	if l == nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.entries = append(l.entries, LoggedMessage{
+		Time:      time.Now(),
+		Direction: direction,
+		Type:      typ,
+		Message:   m,
+		Raw:       raw,
+	})
+}
+
+// snapshot returns a copy of the entries in order.
+func (l *messageLog) snapshot() []LoggedMessage {
+	if l == nil {
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return append([]LoggedMessage(nil), l.entries...)
+}
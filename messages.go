@@ -3,6 +3,8 @@ package main
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
+	"strings"
 )
 
 var (
@@ -22,6 +24,25 @@ const (
 	MessageTypeClear       MessageType = "clear"
 )
 
+// IsValid reports whether t is one of the defined message types.
+func (t MessageType) IsValid() bool {
+	switch t {
+	case MessageTypeStart, MessageTypeAck, MessageTypeMediaInput, MessageTypeDTMF, MessageTypeCustom,
+		MessageTypeMediaOutput, MessageTypeClear:
+		return true
+	}
+	return false
+}
+
+// ParseMessageType converts s to a MessageType, returning ErrUnknownMessageType
+// if it is not a defined type.
+func ParseMessageType(s string) (MessageType, error) {
+	if t := MessageType(s); t.IsValid() {
+		return t, nil
+	}
+	return "", fmt.Errorf("%w: %q", ErrUnknownMessageType, s)
+}
+
 // Message
 type Message interface {
 	Type() MessageType
@@ -44,12 +65,32 @@ type AckMessage struct {
 	Event    MessageType  `json:"event"`
 	StreamID string       `json:"stream_id"`
 	Config   StreamConfig `json:"config"`
+
+	// Status and Error are set when the server rejects the start event with
+	// an ack instead of closing the connection.
+	Status string `json:"status,omitempty"`
+	Error  string `json:"error,omitempty"`
 }
 
 func (m *AckMessage) Type() MessageType {
 	return MessageTypeAck
 }
 
+// Err returns ErrHandshakeRejected with the server's reason if the ack
+// reports an error, and nil for a successful handshake.
+func (m *AckMessage) Err() error {
+	status := strings.ToLower(m.Status)
+	if m.Error == "" && (status == "" || status == "ok" || status == "success") {
+		return nil
+	}
+
+	reason := m.Error
+	if reason == "" {
+		reason = "status " + m.Status
+	}
+	return fmt.Errorf("%w: %s", ErrHandshakeRejected, reason)
+}
+
 // MediaInputMessage
 type MediaInputMessage struct {
 	Event    MessageType `json:"event"`
@@ -83,17 +124,52 @@ func (m *CustomMessage) Type() MessageType {
 	return MessageTypeCustom
 }
 
+// NewTranscriptMessage creates a custom event carrying a line of the
+// conversation's transcript, as sent by agents that transcribe.
+func NewTranscriptMessage(streamID, role, text string) *CustomMessage {
+	return &CustomMessage{
+		Event:    MessageTypeCustom,
+		StreamID: streamID,
+		Metadata: Metadata{"type": transcriptType, "role": role, "text": text},
+	}
+}
+
+// Transcript returns the role and text of a transcript custom event, see
+// NewTranscriptMessage. ok is false for any other custom event.
+func (m *CustomMessage) Transcript() (role, text string, ok bool) {
+	if m.Metadata["type"] != transcriptType {
+		return "", "", false
+	}
+	role, _ = m.Metadata["role"].(string)
+	text, ok = m.Metadata["text"].(string)
+	return role, text, ok
+}
+
 // MediaOutputMessage
 type MediaOutputMessage struct {
 	Event    MessageType `json:"event"`
 	StreamID string      `json:"stream_id"`
 	Media    Media       `json:"media"`
+
+	// Speaker and Track optionally label the audio when the agent mixes
+	// several sources, e.g. multiple agents or background music.
+	Speaker string `json:"speaker,omitempty"`
+	Track   string `json:"track,omitempty"`
 }
 
 func (m *MediaOutputMessage) Type() MessageType {
 	return MessageTypeMediaOutput
 }
 
+// Label returns the track the audio belongs to, falling back to the speaker.
+// It is empty for unlabeled audio.
+func (m *MediaOutputMessage) Label() string {
+	if m.Track != "" {
+		return m.Track
+	}
+	return m.Speaker
+}
+
 // ClearMessage
 type ClearMessage struct {
 	Event    MessageType `json:"event"`
@@ -107,6 +183,73 @@ func (m *ClearMessage) Type() MessageType {
 // Metadata
 type Metadata map[string]interface{}
 
+const (
+	MetadataKeyLocale   = "locale"
+	MetadataKeyCallerID = "caller_id"
+)
+
+// metadataUpdateType marks the custom event sent by Session.SetMetadata. The
+// protocol has no event to change metadata after start, so agents have to
+// look for it.
+const metadataUpdateType = "metadata_update"
+
+// textInputType marks the custom event sent by Session.SendText, for the
+// same reason: the protocol has no text input event.
+const textInputType = "text_input"
+
+// transcriptType marks the custom events of NewTranscriptMessage: the
+// protocol has no transcript event either.
+const transcriptType = "transcript"
+
+// SetLocale sets the caller's locale, e.g. "en-US".
+func (m Metadata) SetLocale(locale string) {
+	m[MetadataKeyLocale] = locale
+}
+
+// SetCallerID sets the caller identifier, e.g. a phone number.
+func (m Metadata) SetCallerID(callerID string) {
+	m[MetadataKeyCallerID] = callerID
+}
+
+// Validate checks that every value can be serialized to JSON.
+func (m Metadata) Validate() error {
+	if _, err := json.Marshal(m); err != nil {
+		return fmt.Errorf("invalid metadata: %w", err)
+	}
+	return nil
+}
+
+// Decode stores the metadata in the value pointed to by v, typically a
+// struct with json tags, by round-tripping it through JSON, e.g. to read the
+// metadata of a CustomMessage without type assertions. Nested objects decode
+// into nested structs and numbers into any numeric field that holds them.
+func (m Metadata) Decode(v interface{}) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return fmt.Errorf("invalid metadata: %w", err)
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("decode metadata error: %w", err)
+	}
+	return nil
+}
+
+// merge returns a copy of m with the entries of other added on top.
+func (m Metadata) merge(other Metadata) Metadata {
+	if len(m) == 0 && len(other) == 0 {
+		return nil
+	}
+
+	merged := make(Metadata, len(m)+len(other))
+	for k, v := range m {
+		merged[k] = v
+	}
+	for k, v := range other {
+		merged[k] = v
+	}
+	return merged
+}
+
 // Media
 type Media struct {
 	Payload string `json:"payload"`
@@ -115,6 +258,7 @@ type Media struct {
 // StreamConfig
 type StreamConfig struct {
 	InputFormat InputFormat `json:"input_format"`
+	SampleRate  int         `json:"sample_rate,omitempty"`
 }
 
 // UnmarshalMessage
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSendTextIsCustomEvent(t *testing.T) {
+	received := make(chan *CustomMessage, 1)
+	srv := NewTestServer(&TestServerOptions{
+		Respond: func(msg Message) []Message {
+			if custom, ok := msg.(*CustomMessage); ok {
+				received <- custom
+			}
+			return nil
+		},
+	})
+	defer srv.Close()
+
+	session := newTestSession(t, srv, Config{})
+	if err := session.SendText(context.Background(), "What are your opening hours?"); err != nil {
+		t.Fatalf("SendText: %v", err)
+	}
+
+	select {
+	case m := <-received:
+		if m.Event != MessageTypeCustom || m.StreamID != session.StreamID() {
+			t.Errorf("event %q on stream %q, want %q on %q", m.Event, m.StreamID, MessageTypeCustom, session.StreamID())
+		}
+		if m.Metadata["type"] != textInputType || m.Metadata["text"] != "What are your opening hours?" {
+			t.Errorf("metadata = %v, want the text as a %s custom event", m.Metadata, textInputType)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("server received no custom event")
+	}
+}
+
+func TestParseMessageType(t *testing.T) {
+	tests := []struct {
+		s     string
+		valid bool
+	}{
+		{"start", true},
+		{"ack", true},
+		{"media_input", true},
+		{"dtmf", true},
+		{"custom", true},
+		{"media_output", true},
+		{"clear", true},
+		{"transcript", false},
+		{"", false},
+		{"Start", false},
+		{"media", false},
+		{" clear", false},
+	}
+
+	for _, tt := range tests {
+		if got := MessageType(tt.s).IsValid(); got != tt.valid {
+			t.Errorf("MessageType(%q).IsValid() = %t, want %t", tt.s, got, tt.valid)
+		}
+
+		typ, err := ParseMessageType(tt.s)
+		if tt.valid {
+			if err != nil || typ != MessageType(tt.s) {
+				t.Errorf("ParseMessageType(%q) = %q, %v; want %q", tt.s, typ, err, tt.s)
+			}
+		} else if !errors.Is(err, ErrUnknownMessageType) {
+			t.Errorf("ParseMessageType(%q) = %q, %v; want ErrUnknownMessageType", tt.s, typ, err)
+		}
+	}
+}
+
+func TestMetadataDecode(t *testing.T) {
+	type caller struct {
+		Name  string `json:"name"`
+		Phone struct {
+			Country int    `json:"country"`
+			Number  string `json:"number"`
+		} `json:"phone"`
+		Tags []string `json:"tags"`
+	}
+
+	// The agent reads the caller from the start event and answers with it
+	started := make(chan caller, 1)
+	srv := NewTestServer(&TestServerOptions{
+		OnStart: func(start *StartMessage) []Message {
+			var c caller
+			if err := start.Metadata.Decode(&c); err != nil {
+				t.Errorf("Decode of the start metadata: %v", err)
+			}
+			started <- c
+			return []Message{&CustomMessage{Event: MessageTypeCustom, StreamID: start.StreamID, Metadata: Metadata{
+				"type":   "caller",
+				"caller": start.Metadata,
+				"turns":  3,
+			}}}
+		},
+	})
+	defer srv.Close()
+
+	session, err := newTestClient(t, srv, Config{}).NewSession(context.Background(), "agent", map[string]interface{}{
+		"name":  "Ada",
+		"phone": map[string]interface{}{"country": 44, "number": "20 7946 0000"},
+		"tags":  []string{"vip"},
+	})
+	if err != nil {
+		t.Fatalf("NewSession: %v", err)
+	}
+	defer session.Close()
+
+	want := caller{Name: "Ada", Tags: []string{"vip"}}
+	want.Phone.Country = 44
+	want.Phone.Number = "20 7946 0000"
+	if c := <-started; !reflect.DeepEqual(c, want) {
+		t.Errorf("start metadata decoded to %+v, want %+v", c, want)
+	}
+
+	select {
+	case m := <-session.Messages():
+		custom, ok := m.(*CustomMessage)
+		if !ok {
+			t.Fatalf("received %s, want a custom event", m.Type())
+		}
+		// Nested objects arrive as plain maps and decode all the same
+		var reply struct {
+			Type   string `json:"type"`
+			Caller caller `json:"caller"`
+			Turns  int    `json:"turns"`
+		}
+		if err := custom.Metadata.Decode(&reply); err != nil {
+			t.Fatalf("Decode: %v", err)
+		}
+		if reply.Type != "caller" || reply.Turns != 3 || !reflect.DeepEqual(reply.Caller, want) {
+			t.Errorf("custom metadata decoded to %+v, want the caller after 3 turns", reply)
+		}
+
+		var wrong struct {
+			Turns string `json:"turns"`
+		}
+		if err := custom.Metadata.Decode(&wrong); err == nil || !strings.Contains(err.Error(), "decode metadata") {
+			t.Errorf("Decode of a number into a string = %v, want a decode error", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no custom event")
+	}
+
+	if err := (Metadata{"bad": make(chan int)}).Decode(&struct{}{}); err == nil || !strings.Contains(err.Error(), "invalid metadata") {
+		t.Errorf("Decode of unencodable metadata = %v, want an invalid metadata error", err)
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"strings"
+	"time"
+)
+
+// Metrics receives counters and timings from sessions. Implementations must
+// be safe for concurrent use. Build with -tags prometheus for
+// NewPrometheusMetrics, which exports them to a prometheus.Registerer.
+type Metrics interface {
+	// MessageSent and MessageReceived report a frame of size bytes.
+	MessageSent(typ MessageType, size int)
+	MessageReceived(typ MessageType, size int)
+
+	// AudioSent and AudioReceived report decoded media payload bytes.
+	AudioSent(n int)
+	AudioReceived(n int)
+
+	// Handshake reports the time from dialing to the ack.
+	Handshake(d time.Duration)
+
+	// PingRTT reports the round trip of a websocket ping.
+	PingRTT(d time.Duration)
+
+	// Reconnect reports a ReconnectingSession replacing a lost connection.
+	Reconnect()
+}
+
+// noopMetrics is used when Config.Metrics is nil.
+type noopMetrics struct{}
+
+func (noopMetrics) MessageSent(MessageType, int)     {}
+func (noopMetrics) MessageReceived(MessageType, int) {}
+func (noopMetrics) AudioSent(int)                    {}
+func (noopMetrics) AudioReceived(int)                {}
+func (noopMetrics) Handshake(time.Duration)          {}
+func (noopMetrics) PingRTT(time.Duration)            {}
+func (noopMetrics) Reconnect()                       {}
+
+// decodedSize returns the number of bytes a padded or unpadded base64
+// payload decodes to, without decoding it.
+func decodedSize(payload string) int {
+	padding := len(payload) - len(strings.TrimRight(payload, "="))
+	return len(payload)*3/4 - padding
+}
@@ -0,0 +1,106 @@
+//go:build prometheus
+
+package main
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// PrometheusMetrics exports session metrics to Prometheus. It is only built
+// with -tags prometheus so that the client does not depend on it otherwise.
+type PrometheusMetrics struct {
+	messagesSent         *prometheus.CounterVec
+	messagesReceived     *prometheus.CounterVec
+	messageBytesSent     *prometheus.CounterVec
+	messageBytesReceived *prometheus.CounterVec
+	audioBytes           *prometheus.CounterVec
+	handshake            prometheus.Histogram
+	pingRTT              prometheus.Histogram
+	reconnects           prometheus.Counter
+}
+
+// NewPrometheusMetrics creates the client's collectors and registers them
+// with reg, e.g. prometheus.DefaultRegisterer. Pass the result as
+// Config.Metrics.
+func NewPrometheusMetrics(reg prometheus.Registerer) (*PrometheusMetrics, error) {
+	m := &PrometheusMetrics{
+		messagesSent: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "cartesia_agent_messages_sent_total",
+			Help: "Messages sent to the agent, by event type.",
+		}, []string{"type"}),
+		messagesReceived: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "cartesia_agent_messages_received_total",
+			Help: "Messages received from the agent, by event type.",
+		}, []string{"type"}),
+		messageBytesSent: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "cartesia_agent_message_bytes_sent_total",
+			Help: "Frame bytes sent to the agent, by event type.",
+		}, []string{"type"}),
+		messageBytesReceived: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "cartesia_agent_message_bytes_received_total",
+			Help: "Frame bytes received from the agent, by event type.",
+		}, []string{"type"}),
+		audioBytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "cartesia_agent_audio_bytes_total",
+			Help: "Decoded audio bytes, by direction (sent or received).",
+		}, []string{"direction"}),
+		handshake: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "cartesia_agent_handshake_seconds",
+			Help:    "Time from dialing the agent to receiving the ack.",
+			Buckets: prometheus.DefBuckets,
+		}),
+		pingRTT: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "cartesia_agent_ping_rtt_seconds",
+			Help:    "Round trip time of websocket pings.",
+			Buckets: prometheus.ExponentialBuckets(0.005, 2, 10),
+		}),
+		reconnects: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "cartesia_agent_reconnects_total",
+			Help: "Connections replaced by a ReconnectingSession.",
+		}),
+	}
+
+	collectors := []prometheus.Collector{
+		m.messagesSent, m.messagesReceived, m.messageBytesSent, m.messageBytesReceived,
+		m.audioBytes, m.handshake, m.pingRTT, m.reconnects,
+	}
+	for _, c := range collectors {
+		if err := reg.Register(c); err != nil {
+			return nil, err
+		}
+	}
+
+	return m, nil
+}
+
+func (m *PrometheusMetrics) MessageSent(typ MessageType, size int) {
+	m.messagesSent.WithLabelValues(string(typ)).Inc()
+	m.messageBytesSent.WithLabelValues(string(typ)).Add(float64(size))
+}
+
+func (m *PrometheusMetrics) MessageReceived(typ MessageType, size int) {
+	m.messagesReceived.WithLabelValues(string(typ)).Inc()
+	m.messageBytesReceived.WithLabelValues(string(typ)).Add(float64(size))
+}
+
+func (m *PrometheusMetrics) AudioSent(n int) {
+	m.audioBytes.WithLabelValues("sent").Add(float64(n))
+}
+
+func (m *PrometheusMetrics) AudioReceived(n int) {
+	m.audioBytes.WithLabelValues("received").Add(float64(n))
+}
+
+func (m *PrometheusMetrics) Handshake(d time.Duration) {
+	m.handshake.Observe(d.Seconds())
+}
+
+func (m *PrometheusMetrics) PingRTT(d time.Duration) {
+	m.pingRTT.Observe(d.Seconds())
+}
+
+func (m *PrometheusMetrics) Reconnect() {
+	m.reconnects.Inc()
+}
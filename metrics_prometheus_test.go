@@ -0,0 +1,86 @@
+//go:build prometheus
+
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// gatheredValue returns the value of the counter, or the sample count of the
+// histogram, called name whose label, if given, has value.
+func gatheredValue(t *testing.T, reg *prometheus.Registry, name, label, value string) float64 {
+	t.Helper()
+
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range families {
+		if f.GetName() != name {
+			continue
+		}
+		for _, m := range f.GetMetric() {
+			matches := label == ""
+			for _, l := range m.GetLabel() {
+				matches = matches || l.GetName() == label && l.GetValue() == value
+			}
+			if !matches {
+				continue
+			}
+			if h := m.GetHistogram(); h != nil {
+				return float64(h.GetSampleCount())
+			}
+			return m.GetCounter().GetValue()
+		}
+	}
+	return 0
+}
+
+func TestPrometheusMetrics(t *testing.T) {
+	srv := NewTestServer(&TestServerOptions{EchoMedia: true})
+	defer srv.Close()
+
+	reg := prometheus.NewRegistry()
+	metrics, err := NewPrometheusMetrics(reg)
+	if err != nil {
+		t.Fatalf("NewPrometheusMetrics: %v", err)
+	}
+
+	session := newTestSession(t, srv, Config{Metrics: metrics})
+	if err := session.SendSilence(context.Background(), 300*time.Millisecond); err != nil {
+		t.Fatalf("SendSilence: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		select {
+		case <-session.Messages():
+		case <-time.After(5 * time.Second):
+			t.Fatal("no echo")
+		}
+	}
+
+	tests := []struct {
+		name, label, value string
+		want               float64
+	}{
+		{"cartesia_agent_messages_sent_total", "type", "start", 1},
+		{"cartesia_agent_messages_sent_total", "type", "media_input", 3},
+		{"cartesia_agent_messages_received_total", "type", "ack", 1},
+		{"cartesia_agent_messages_received_total", "type", "media_output", 3},
+		{"cartesia_agent_audio_bytes_total", "direction", "sent", 9600},
+		{"cartesia_agent_audio_bytes_total", "direction", "received", 9600},
+		{"cartesia_agent_handshake_seconds", "", "", 1},
+		{"cartesia_agent_reconnects_total", "", "", 0},
+	}
+	for _, tt := range tests {
+		if got := gatheredValue(t, reg, tt.name, tt.label, tt.value); got != tt.want {
+			t.Errorf("%s{%s=%q} = %g, want %g", tt.name, tt.label, tt.value, got, tt.want)
+		}
+	}
+	if got := gatheredValue(t, reg, "cartesia_agent_message_bytes_sent_total", "type", "media_input"); got == 0 {
+		t.Error("no media_input frame bytes counted")
+	}
+}
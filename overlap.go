@@ -0,0 +1,80 @@
+package main
+
+import "time"
+
+// overlapWindow is the span over which each channel's activity is judged.
+const overlapWindow = 10 // milliseconds
+
+// OverlapSpan is an interval of a recording during which the user and the
+// agent spoke at the same time, as offsets from the start of the recording.
+type OverlapSpan struct {
+	Start time.Duration
+	End   time.Duration
+}
+
+// Duration returns the length of the span.
+func (s OverlapSpan) Duration() time.Duration {
+	return s.End - s.Start
+}
+
+// overlapDetector finds overlapping speech in time-aligned stereo audio.
+// Each channel is judged active in 10ms windows whose level reaches
+// speechDBFS, and consecutive windows where both are active form a span.
+type overlapDetector struct {
+	sampleRate int
+	frames     int64      // frames of the complete windows judged so far
+	partial    [2][]int16 // frames of the current window
+	open       bool       // the last window was an overlap
+	start      int64      // first frame of the open span
+	spans      []OverlapSpan
+}
+
+// add feeds aligned frames of both channels.
+func (d *overlapDetector) add(left, right []int16) {
+	window := max(d.sampleRate*overlapWindow/1000, 1)
+	for i := range left {
+		d.partial[leftChannel] = append(d.partial[leftChannel], left[i])
+		d.partial[rightChannel] = append(d.partial[rightChannel], right[i])
+		if len(d.partial[leftChannel]) == window {
+			d.judge()
+		}
+	}
+}
+
+// finish judges the last, possibly short window and closes an open span.
+func (d *overlapDetector) finish() {
+	if len(d.partial[leftChannel]) > 0 {
+		d.judge()
+	}
+	d.closeSpan()
+}
+
+// judge classifies the current window and starts a new one.
+func (d *overlapDetector) judge() {
+	both := isSpeech(d.partial[leftChannel]) && isSpeech(d.partial[rightChannel])
+	switch {
+	case both && !d.open:
+		d.open = true
+		d.start = d.frames
+	case !both:
+		d.closeSpan()
+	}
+
+	d.frames += int64(len(d.partial[leftChannel]))
+	d.partial[leftChannel] = d.partial[leftChannel][:0]
+	d.partial[rightChannel] = d.partial[rightChannel][:0]
+}
+
+// closeSpan ends the open span, if any, at the current frame.
+func (d *overlapDetector) closeSpan() {
+	if !d.open {
+		return
+	}
+	d.open = false
+	d.spans = append(d.spans, OverlapSpan{Start: d.offset(d.start), End: d.offset(d.frames)})
+}
+
+// offset converts a frame position to time.
+func (d *overlapDetector) offset(frame int64) time.Duration {
+	return time.Duration(frame * int64(time.Second) / int64(d.sampleRate))
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var (
+	ErrWriterClosed = errors.New("paced writer closed")
+)
+
+// PacedWriterOptions
+type PacedWriterOptions struct {
+	// FrameDuration is the length of each media_input frame, 100ms if zero.
+	// Frames are sent one per FrameDuration, i.e. in real time.
+	FrameDuration time.Duration
+
+	// Clock paces the frames, the real clock if nil.
+	Clock Clock
+}
+
+// PacedWriter sends audio written in chunks of any size as fixed-size
+// media_input frames at a steady real-time pace, so a producer that
+// delivers audio in bursts still reaches the agent evenly. Audio is
+// buffered until a whole frame is available; when the producer falls
+// behind, frames pause rather than being sent short.
+type PacedWriter struct {
+	session   Session
+	frameSize int
+
+	mu     sync.Mutex
+	buf    []byte
+	closed bool  // Close was called, flush what is left
+	err    error // why the send loop stopped
+
+	done chan struct{}
+}
+
+// NewPacedWriter starts sending the audio written to it over session, in
+// the session's format, until ctx is done or Close is called. opts may be nil.
+func NewPacedWriter(ctx context.Context, session Session, opts *PacedWriterOptions) *PacedWriter {
+	if opts == nil {
+		opts = &PacedWriterOptions{}
+	}
+	frameDuration := opts.FrameDuration
+	if frameDuration <= 0 {
+		frameDuration = chunkDuration
+	}
+	clock := opts.Clock
+	if clock == nil {
+		clock = realClock{}
+	}
+
+	w := &PacedWriter{
+		session:   session,
+		frameSize: max(session.Config().BytesForDuration(frameDuration), 1),
+		done:      make(chan struct{}),
+	}
+	go w.run(ctx, clock.NewTicker(frameDuration))
+
+	return w
+}
+
+// Write buffers p for sending. It fails once the writer is closed or a
+// send failed.
+func (w *PacedWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.err != nil {
+		return 0, w.err
+	}
+	if w.closed {
+		return 0, ErrWriterClosed
+	}
+
+	w.buf = append(w.buf, p...)
+	return len(p), nil
+}
+
+// Buffered returns the duration of audio waiting to be sent.
+func (w *PacedWriter) Buffered() time.Duration {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.session.Config().Duration(len(w.buf))
+}
+
+// Close sends the remaining audio, the last frame possibly short, and
+// returns once it is sent or the send loop stopped. Because frames keep
+// their pace, this takes as long as the buffered audio lasts.
+func (w *PacedWriter) Close() error {
+	w.mu.Lock()
+	w.closed = true
+	w.mu.Unlock()
+
+	<-w.done
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if errors.Is(w.err, ErrWriterClosed) {
+		return nil
+	}
+	return w.err
+}
+
+// run sends one frame per tick while audio is buffered.
+func (w *PacedWriter) run(ctx context.Context, ticker Ticker) {
+	defer close(w.done)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C():
+		case <-ctx.Done():
+			w.stop(ctx.Err())
+			return
+		}
+
+		frame, last := w.next()
+		if frame != nil {
+			msg := NewMediaInputFromPCM(w.session.StreamID(), frame)
+			if err := w.session.Send(ctx, msg); err != nil {
+				w.stop(fmt.Errorf("send audio error: %w", err))
+				return
+			}
+		}
+		if last {
+			w.stop(ErrWriterClosed)
+			return
+		}
+	}
+}
+
+// next takes the next frame from the buffer, or nil if a whole frame is not
+// available yet. After Close it also takes a final short frame and reports
+// when nothing is left.
+func (w *PacedWriter) next() (frame []byte, last bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	n := w.frameSize
+	if len(w.buf) < n {
+		if !w.closed {
+			return nil, false
+		}
+		n = len(w.buf)
+	}
+	if n > 0 {
+		frame = w.buf[:n:n]
+		w.buf = w.buf[n:]
+	}
+	return frame, w.closed && len(w.buf) == 0
+}
+
+// stop records why the send loop ended and drops the unsent audio.
+func (w *PacedWriter) stop(err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.err = err
+	w.buf = nil
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"testing"
+	"time"
+)
+
+func TestPacedWriter(t *testing.T) {
+	frames := make(chan []byte, 10)
+	srv := NewTestServer(&TestServerOptions{
+		Respond: func(msg Message) []Message {
+			if m, ok := msg.(*MediaInputMessage); ok {
+				data, _ := base64.StdEncoding.DecodeString(m.Media.Payload)
+				frames <- data
+			}
+			return nil
+		},
+	})
+	defer srv.Close()
+
+	session := newTestSession(t, srv, Config{})
+	clock := NewFakeClock(time.Unix(0, 0))
+	w := NewPacedWriter(context.Background(), session, &PacedWriterOptions{Clock: clock})
+
+	audio := make([]byte, 12900)
+	for i := range audio {
+		audio[i] = byte(i % 251)
+	}
+	var sent []byte
+	written := 0
+	write := func(n int) {
+		t.Helper()
+		if _, err := w.Write(audio[written : written+n]); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+		written += n
+	}
+	// tick advances the clock by a frame and returns the frame sent, if any
+	tick := func() []byte {
+		t.Helper()
+		clock.Advance(100 * time.Millisecond)
+		select {
+		case frame := <-frames:
+			sent = append(sent, frame...)
+			return frame
+		case <-time.After(100 * time.Millisecond):
+			return nil
+		}
+	}
+	expect := func(want int) {
+		t.Helper()
+		if frame := tick(); len(frame) != want {
+			t.Fatalf("tick sent %d bytes, want %d", len(frame), want)
+		}
+	}
+
+	// Less than a frame waits for more audio
+	write(1000)
+	expect(0)
+	// A burst goes out a frame per tick
+	write(7000)
+	expect(3200)
+	expect(3200)
+	expect(0)
+	// Trickled audio is gathered into whole frames
+	write(3)
+	write(4797)
+	expect(3200)
+	expect(3200)
+	expect(0)
+
+	// Close flushes the rest as a short frame
+	write(100)
+	closed := make(chan error, 1)
+	go func() { closed <- w.Close() }()
+	closing := true
+	for i := 0; closing; i++ {
+		if i == 50 {
+			t.Fatal("Close did not return")
+		}
+		tick()
+		select {
+		case err := <-closed:
+			closing = false
+			if err != nil {
+				t.Errorf("Close: %v", err)
+			}
+		default:
+		}
+	}
+	for len(sent) < len(audio) {
+		select {
+		case frame := <-frames:
+			sent = append(sent, frame...)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("server received %d bytes, want all %d", len(sent), len(audio))
+		}
+	}
+	if !bytes.Equal(sent, audio) {
+		t.Error("frames do not add up to the audio written")
+	}
+	if _, err := w.Write(audio[:1]); err == nil {
+		t.Error("Write after Close succeeded")
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// PassthroughRecorder writes agent audio exactly as received, without
+// decoding or resampling, next to a JSON sidecar describing its format.
+// User audio is not recorded.
+type PassthroughRecorder struct {
+	file    *os.File
+	sidecar string
+	format  passthroughFormat
+	wav     *wavHeader // set when writing a WAV container
+}
+
+// passthroughFormat is the sidecar describing the raw audio file.
+type passthroughFormat struct {
+	InputFormat InputFormat `json:"input_format"`
+	SampleRate  int         `json:"sample_rate"`
+	Encoding    string      `json:"encoding"`
+	Channels    int         `json:"channels"`
+	Bytes       int64       `json:"bytes,omitempty"` // unknown while streaming
+}
+
+// NewPassthroughRecorder creates filename for the raw audio and
+// filename + ".json" for its format description. A filename ending in .wav
+// gets a WAV container around the audio, e.g. a µ-law WAV for
+// InputFormatMulaw8000, which players can open without the sidecar.
+func NewPassthroughRecorder(filename string, cfg StreamConfig) (*PassthroughRecorder, error) {
+	var header *wavHeader
+	if strings.EqualFold(filepath.Ext(filename), ".wav") {
+		var err error
+		if header, err = newWAVHeader(cfg); err != nil {
+			return nil, err
+		}
+	}
+
+	file, err := os.Create(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	if header != nil {
+		if _, err := file.Write(header.bytes(0)); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+
+	return &PassthroughRecorder{
+		file:    file,
+		sidecar: filename + ".json",
+		format:  newPassthroughFormat(cfg),
+		wav:     header,
+	}, nil
+}
+
+// newPassthroughFormat describes mono audio in cfg as received.
+func newPassthroughFormat(cfg StreamConfig) passthroughFormat {
+	encoding := "pcm_s16le"
+	switch cfg.InputFormat {
+	case InputFormatMulaw8000:
+		encoding = "mulaw"
+	case InputFormatAlaw8000:
+		encoding = "alaw"
+	}
+
+	return passthroughFormat{
+		InputFormat: cfg.InputFormat,
+		SampleRate:  cfg.Rate(),
+		Encoding:    encoding,
+		Channels:    1,
+	}
+}
+
+// WriteLeft drops user audio.
+func (r *PassthroughRecorder) WriteLeft(data []byte) error {
+	return nil
+}
+
+// WriteRight appends agent audio unchanged.
+func (r *PassthroughRecorder) WriteRight(data []byte) error {
+	n, err := r.file.Write(data)
+	r.format.Bytes += int64(n)
+	return err
+}
+
+// Close completes the WAV header if there is one, closes the audio file and
+// writes the sidecar.
+func (r *PassthroughRecorder) Close() error {
+	if r.wav != nil {
+		if err := r.finishWAV(); err != nil {
+			r.file.Close()
+			return err
+		}
+	}
+	if err := r.file.Close(); err != nil {
+		return err
+	}
+
+	sidecar, err := json.MarshalIndent(r.format, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(r.sidecar, sidecar, 0o644)
+}
+
+// finishWAV pads the data chunk to an even size and rewrites the header
+// with the final sizes and sample count.
+func (r *PassthroughRecorder) finishWAV() error {
+	size := r.format.Bytes
+	if size%2 == 1 {
+		if _, err := r.file.Write([]byte{0}); err != nil {
+			return err
+		}
+	}
+	_, err := r.file.WriteAt(r.wav.bytes(size), 0)
+	return err
+}
+
+// wavHeader is the header of a mono WAV in a session format's native
+// encoding. Non-PCM formats carry the extended fmt chunk and the fact chunk
+// with the sample count that the WAV specification requires for them.
+type wavHeader struct {
+	tag         uint16 // WAVE format tag, e.g. wavFormatMulaw
+	sampleRate  int
+	sampleBytes int
+}
+
+// newWAVHeader returns the header for cfg, which must be 16-bit PCM or G.711.
+func newWAVHeader(cfg StreamConfig) (*wavHeader, error) {
+	h := &wavHeader{sampleRate: cfg.Rate(), sampleBytes: cfg.InputFormat.BytesPerSample()}
+	switch {
+	case isPCM16(cfg.InputFormat):
+		h.tag = wavFormatPCM
+	case cfg.InputFormat == InputFormatMulaw8000:
+		h.tag = wavFormatMulaw
+	case cfg.InputFormat == InputFormatAlaw8000:
+		h.tag = wavFormatAlaw
+	default:
+		return nil, fmt.Errorf("%w: no WAV format tag for %s", ErrUnsupportedWAV, cfg.InputFormat)
+	}
+	return h, nil
+}
+
+// bytes encodes the header for dataSize bytes of audio.
+func (h *wavHeader) bytes(dataSize int64) []byte {
+	le := binary.LittleEndian
+	pcm := h.tag == wavFormatPCM
+
+	fmtChunk := le.AppendUint16(nil, h.tag)
+	fmtChunk = le.AppendUint16(fmtChunk, 1) // mono
+	fmtChunk = le.AppendUint32(fmtChunk, uint32(h.sampleRate))
+	fmtChunk = le.AppendUint32(fmtChunk, uint32(h.sampleRate*h.sampleBytes))
+	fmtChunk = le.AppendUint16(fmtChunk, uint16(h.sampleBytes))
+	fmtChunk = le.AppendUint16(fmtChunk, uint16(h.sampleBytes*8))
+	if !pcm {
+		fmtChunk = le.AppendUint16(fmtChunk, 0) // no extra format bytes
+	}
+
+	var b []byte
+	appendChunk := func(id string, size int, payload []byte) {
+		b = append(b, id...)
+		b = le.AppendUint32(b, uint32(size))
+		b = append(b, payload...)
+	}
+
+	b = append(b, "RIFF"...)
+	b = le.AppendUint32(b, 0) // patched below
+	b = append(b, "WAVE"...)
+	appendChunk("fmt ", len(fmtChunk), fmtChunk)
+	if !pcm {
+		appendChunk("fact", 4, le.AppendUint32(nil, uint32(dataSize/int64(h.sampleBytes))))
+	}
+	appendChunk("data", int(dataSize), nil)
+
+	le.PutUint32(b[4:8], uint32(int64(len(b))-8+dataSize+dataSize%2))
+	return b
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// recordGreeting records the greeting of an agent in format with a
+// PassthroughRecorder writing filename and returns the audio sent.
+func recordGreeting(t *testing.T, format InputFormat, filename string) []byte {
+	t.Helper()
+
+	greeting := make([]byte, 801) // odd, to check the WAV padding
+	for i := range greeting {
+		greeting[i] = byte(i)
+	}
+	srv := NewTestServer(&TestServerOptions{
+		OnStart: func(start *StartMessage) []Message {
+			return []Message{NewMediaOutputFromPCM(start.StreamID, greeting)}
+		},
+	})
+	defer srv.Close()
+
+	session := newTestSession(t, srv, Config{InputFormat: format})
+	rec, err := NewPassthroughRecorder(filename, session.Config())
+	if err != nil {
+		t.Fatalf("NewPassthroughRecorder: %v", err)
+	}
+	conversation := NewConversation(session, rec)
+	if err := conversation.DrainUntilSilence(context.Background(), 300*time.Millisecond); err != nil {
+		t.Fatalf("DrainUntilSilence: %v", err)
+	}
+	if err := rec.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	return greeting
+}
+
+func TestPassthroughRecorderRaw(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "agent.ulaw")
+	greeting := recordGreeting(t, InputFormatMulaw8000, path)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, greeting) {
+		t.Errorf("raw file has %d bytes, want the %d received unchanged", len(data), len(greeting))
+	}
+
+	sidecar, err := os.ReadFile(path + ".json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var format passthroughFormat
+	if err := json.Unmarshal(sidecar, &format); err != nil {
+		t.Fatalf("invalid sidecar: %v", err)
+	}
+	want := passthroughFormat{InputFormat: InputFormatMulaw8000, SampleRate: 8000, Encoding: "mulaw", Channels: 1, Bytes: int64(len(greeting))}
+	if format != want {
+		t.Errorf("sidecar = %+v, want %+v", format, want)
+	}
+}
+
+func TestPassthroughRecorderWAV(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "agent.wav")
+	greeting := recordGreeting(t, InputFormatMulaw8000, path)
+
+	in, err := readWAV(path)
+	if err != nil {
+		t.Fatalf("readWAV: %v", err)
+	}
+	if in.format != wavFormatMulaw || in.sampleRate != 8000 || in.channels != 1 {
+		t.Errorf("WAV is %s, want mono µ-law at 8000 Hz", in)
+	}
+	if !bytes.Equal(in.data, greeting) {
+		t.Errorf("WAV holds %d bytes, want the %d received unchanged", len(in.data), len(greeting))
+	}
+}
+
+// riffChunk is a chunk of a RIFF file.
+type riffChunk struct {
+	id   string
+	data []byte
+}
+
+// parseRIFF splits a WAVE file into its chunks, failing on anything a
+// strict decoder would reject: wrong sizes, missing pad bytes or trailing
+// garbage.
+func parseRIFF(t *testing.T, file []byte) []riffChunk {
+	t.Helper()
+
+	le := binary.LittleEndian
+	if len(file) < 12 || string(file[:4]) != "RIFF" || string(file[8:12]) != "WAVE" {
+		t.Fatal("not a RIFF WAVE file")
+	}
+	if size := int(le.Uint32(file[4:8])); size != len(file)-8 {
+		t.Fatalf("RIFF size %d, want %d", size, len(file)-8)
+	}
+
+	var chunks []riffChunk
+	for rest := file[12:]; len(rest) > 0; {
+		if len(rest) < 8 {
+			t.Fatalf("%d bytes of garbage after the last chunk", len(rest))
+		}
+		id, size := string(rest[:4]), int(le.Uint32(rest[4:8]))
+		if 8+size+size%2 > len(rest) {
+			t.Fatalf("chunk %q of %d bytes, padded, overruns the file", id, size)
+		}
+		chunks = append(chunks, riffChunk{id, rest[8 : 8+size]})
+		rest = rest[8+size+size%2:]
+	}
+	return chunks
+}
+
+func TestPassthroughRecorderFactChunk(t *testing.T) {
+	for _, tt := range []struct {
+		format InputFormat
+		tag    uint16
+	}{
+		{InputFormatMulaw8000, wavFormatMulaw},
+		{InputFormatAlaw8000, wavFormatAlaw},
+	} {
+		path := filepath.Join(t.TempDir(), "agent.wav")
+		greeting := recordGreeting(t, tt.format, path)
+
+		file, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		chunks := parseRIFF(t, file)
+		var ids []string
+		for _, c := range chunks {
+			ids = append(ids, c.id)
+		}
+		if !slices.Equal(ids, []string{"fmt ", "fact", "data"}) {
+			t.Fatalf("%s: chunks %q, want fmt, fact and data", tt.format, ids)
+		}
+
+		// Non-PCM formats need the extended fmt chunk, with no extra bytes
+		le := binary.LittleEndian
+		fmtChunk := chunks[0].data
+		if len(fmtChunk) != 18 || le.Uint16(fmtChunk) != tt.tag || le.Uint16(fmtChunk[2:]) != 1 ||
+			le.Uint32(fmtChunk[4:]) != 8000 || le.Uint32(fmtChunk[8:]) != 8000 ||
+			le.Uint16(fmtChunk[12:]) != 1 || le.Uint16(fmtChunk[14:]) != 8 || le.Uint16(fmtChunk[16:]) != 0 {
+			t.Errorf("%s: fmt chunk %x, want mono 8-bit at 8000 Hz with tag %d and cbSize 0", tt.format, fmtChunk, tt.tag)
+		}
+		if fact := chunks[1].data; len(fact) != 4 || int(le.Uint32(fact)) != len(greeting) {
+			t.Errorf("%s: fact chunk %x, want the %d samples", tt.format, fact, len(greeting))
+		}
+		if !bytes.Equal(chunks[2].data, greeting) {
+			t.Errorf("%s: data chunk holds %d bytes, want the %d received", tt.format, len(chunks[2].data), len(greeting))
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrRateLimited = errors.New("rate limited")
+)
+
+// RateLimitError is returned by NewSession when the server answers the dial
+// with HTTP 429. It matches ErrRateLimited with errors.Is.
+type RateLimitError struct {
+	RetryAfter time.Duration // from the Retry-After header, 0 if absent
+	Err        error
+}
+
+func (e *RateLimitError) Error() string {
+	if e.RetryAfter > 0 {
+		return fmt.Sprintf("rate limited, retry after %s: %v", e.RetryAfter, e.Err)
+	}
+	return fmt.Sprintf("rate limited: %v", e.Err)
+}
+
+func (e *RateLimitError) Unwrap() []error {
+	return []error{ErrRateLimited, e.Err}
+}
+
+// DialStatusError is returned by NewSession when the server answers the dial
+// with an HTTP status other than 101 or 429. A 401 or 403, e.g. for a bad
+// API key, also matches ErrUnauthorized with errors.Is.
+type DialStatusError struct {
+	StatusCode int
+	Err        error
+}
+
+func (e *DialStatusError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *DialStatusError) Unwrap() []error {
+	if e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusForbidden {
+		return []error{ErrUnauthorized, e.Err}
+	}
+	return []error{e.Err}
+}
+
+// parseRetryAfter reads a Retry-After value given in seconds or as an HTTP
+// date relative to now. Invalid or past values yield 0.
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return max(time.Duration(seconds)*time.Second, 0)
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		return max(at.Sub(now), 0)
+	}
+	return 0
+}
+
+// dialError explains a failed dial, including the body of an HTTP error
+// response, which the websocket library trims to its first kilobyte.
+func dialError(version string, resp *http.Response, err error) error {
+	if resp == nil {
+		return err
+	}
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return &RateLimitError{RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()), Err: err}
+	}
+	return &DialStatusError{StatusCode: resp.StatusCode, Err: responseError(version, resp, err)}
+}
+
+// responseError adds the reason given in the body of resp to err.
+func responseError(version string, resp *http.Response, err error) error {
+	if resp.Body == nil {
+		return err
+	}
+
+	body, _ := io.ReadAll(resp.Body)
+	reason := strings.TrimSpace(string(body))
+	if reason == "" {
+		return err
+	}
+	return versionError(version, reason, fmt.Errorf("%w: %s", err, reason))
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value string
+		want  time.Duration
+	}{
+		{"", 0},
+		{"3", 3 * time.Second},
+		{" 120 ", 2 * time.Minute},
+		{"-1", 0},
+		{now.Add(90 * time.Second).Format(http.TimeFormat), 90 * time.Second},
+		{now.Add(-time.Minute).Format(http.TimeFormat), 0},
+		{"soon", 0},
+	}
+	for _, tt := range tests {
+		if got := parseRetryAfter(tt.value, now); got != tt.want {
+			t.Errorf("parseRetryAfter(%q) = %s, want %s", tt.value, got, tt.want)
+		}
+	}
+}
+
+// rateLimitedServer returns a test server that answers the dials for which
+// limit returns true with HTTP 429 and a Retry-After of a second, and sends
+// the time of every dial to dials.
+func rateLimitedServer(opts *TestServerOptions, limit func(dial int) bool, dials chan<- time.Time) *TestServer {
+	srv := NewTestServer(opts)
+	handler := srv.server.Config.Handler
+	var n atomic.Int32
+	srv.server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		dial := int(n.Add(1))
+		if dials != nil {
+			dials <- time.Now()
+		}
+		if limit(dial) {
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "too many streams", http.StatusTooManyRequests)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+	return srv
+}
+
+func TestDialRateLimited(t *testing.T) {
+	srv := rateLimitedServer(nil, func(int) bool { return true }, nil)
+	defer srv.Close()
+
+	_, err := newTestClient(t, srv, Config{}).NewSession(context.Background(), "agent", nil)
+	var limited *RateLimitError
+	if !errors.As(err, &limited) || !errors.Is(err, ErrRateLimited) || limited.RetryAfter != time.Second {
+		t.Fatalf("NewSession = %v, want a RateLimitError retrying after 1s", err)
+	}
+}
+
+func TestReconnectRateLimited(t *testing.T) {
+	// The connection drops after a "drop" text and the first redial is
+	// rate limited
+	dials := make(chan time.Time, 3)
+	srv := rateLimitedServer(&TestServerOptions{
+		Drop: func(msg Message) bool {
+			m, ok := msg.(*CustomMessage)
+			return ok && m.Metadata["text"] == "drop"
+		},
+	}, func(dial int) bool { return dial == 2 }, dials)
+	defer srv.Close()
+
+	client := newTestClient(t, srv, Config{Reconnect: &ReconnectConfig{InitialBackoff: 10 * time.Millisecond}})
+	session, err := client.NewReconnectingSession(context.Background(), "agent", nil)
+	if err != nil {
+		t.Fatalf("NewReconnectingSession: %v", err)
+	}
+	defer session.Close()
+	<-dials
+
+	first := session.session()
+	if err := session.SendText(context.Background(), "drop"); err != nil {
+		t.Fatal(err)
+	}
+
+	next := func() time.Time {
+		t.Helper()
+		select {
+		case at := <-dials:
+			return at
+		case <-time.After(5 * time.Second):
+			t.Fatal("no redial")
+			return time.Time{}
+		}
+	}
+	limited, redialed := next(), next()
+
+	// The backoff gives way to the server's Retry-After
+	if wait := redialed.Sub(limited); wait < time.Second-50*time.Millisecond {
+		t.Errorf("redialed %s after the 429, want the Retry-After of 1s", wait)
+	}
+
+	// and the session carries on over the new connection
+	deadline := time.Now().Add(5 * time.Second)
+	for session.session() == first && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if session.session() == first {
+		t.Fatal("session did not reconnect")
+	}
+	if err := session.SendText(context.Background(), "hello"); err != nil {
+		t.Errorf("SendText after reconnecting: %v", err)
+	}
+}
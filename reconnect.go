@@ -0,0 +1,545 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+const (
+	defaultInitialBackoff = 500 * time.Millisecond
+	defaultMaxBackoff     = 30 * time.Second
+	defaultStableAfter    = time.Minute
+)
+
+var (
+	ErrReconnectFailed = errors.New("reconnect attempts exhausted")
+)
+
+// Jitter selects how reconnect delays are randomized so that many clients
+// dropped at once do not reconnect in lockstep.
+type Jitter int
+
+const (
+	JitterNone         Jitter = iota // plain exponential backoff
+	JitterFull                       // uniform between 0 and the exponential delay
+	JitterDecorrelated               // uniform between the initial delay and three times the previous one
+)
+
+// TurnResend selects what Conversation.StreamAudio sends again when the
+// connection drops part way through a turn.
+type TurnResend int
+
+const (
+	ResendNone      TurnResend = iota // the turn fails with the send error
+	ResendRemaining                   // the audio from the chunk that failed onwards
+	ResendTurn                        // the whole turn from its start
+)
+
+// ReconnectConfig
+type ReconnectConfig struct {
+	MaxAttempts         int           // consecutive failed attempts before giving up, 0 for no limit
+	InitialBackoff      time.Duration // defaults to 500ms
+	MaxReconnectBackoff time.Duration // cap on any single delay, defaults to 30s
+	Multiplier          float64       // growth per attempt, defaults to 2
+	Jitter              Jitter
+
+	// StableAfter is how long a connection must last for the backoff to
+	// start over from InitialBackoff, defaulting to a minute.
+	StableAfter time.Duration
+
+	// ResendTurn makes Conversation.StreamAudio wait for the reconnect when
+	// a chunk is lost with the connection and then send the turn again,
+	// from the lost chunk or from the start. Chunks sent just before the
+	// drop may not have reached the agent either, which only ResendTurn
+	// makes up for, at the cost of the agent hearing the start twice.
+	ResendTurn TurnResend
+}
+
+// withDefaults fills in unset fields. c may be nil.
+func (c *ReconnectConfig) withDefaults() ReconnectConfig {
+	var cfg ReconnectConfig
+	if c != nil {
+		cfg = *c
+	}
+
+	if cfg.InitialBackoff <= 0 {
+		cfg.InitialBackoff = defaultInitialBackoff
+	}
+	if cfg.MaxReconnectBackoff <= 0 {
+		cfg.MaxReconnectBackoff = defaultMaxBackoff
+	}
+	if cfg.Multiplier < 1 {
+		cfg.Multiplier = 2
+	}
+	if cfg.StableAfter <= 0 {
+		cfg.StableAfter = defaultStableAfter
+	}
+	return cfg
+}
+
+// backoff computes successive reconnect delays.
+type backoff struct {
+	cfg     ReconnectConfig
+	attempt int
+	prev    time.Duration
+	rand    func() float64 // in [0, 1)
+}
+
+func newBackoff(cfg ReconnectConfig) *backoff {
+	return &backoff{cfg: cfg, rand: rand.Float64}
+}
+
+// next returns the delay before the next attempt.
+func (b *backoff) next() time.Duration {
+	initial := float64(b.cfg.InitialBackoff)
+	limit := float64(b.cfg.MaxReconnectBackoff)
+	exp := math.Min(initial*math.Pow(b.cfg.Multiplier, float64(b.attempt)), limit)
+
+	var d float64
+	switch b.cfg.Jitter {
+	case JitterFull:
+		d = b.rand() * exp
+	case JitterDecorrelated:
+		prev := math.Max(float64(b.prev), initial)
+		d = math.Min(initial+b.rand()*(prev*3-initial), limit)
+	default:
+		d = exp
+	}
+
+	b.attempt++
+	b.prev = time.Duration(d)
+	return b.prev
+}
+
+// reset starts over from the initial delay.
+func (b *backoff) reset() {
+	b.attempt = 0
+	b.prev = 0
+}
+
+// ReconnectingSession is a Session that dials a new connection with backoff
+// whenever the current one fails. Messages from all connections arrive on a
+// single channel, which is closed once the session ends for good. Sends made
+// while reconnecting fail with the error of the lost connection.
+//
+// Every connection gets a fresh stream ID and handshake, so StreamID changes
+// after a reconnect. Recorders can mark the outage with InsertGap.
+type ReconnectingSession struct {
+	client   *Client
+	agentID  string
+	metadata map[string]interface{} // guarded by mu, updated by SetMetadata
+	cfg      ReconnectConfig
+	audioOut *audioOutput
+
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+	msgs   chan Message
+	subs   subscriptions
+	done   chan struct{}
+
+	mu       sync.Mutex
+	current  Session
+	replaced chan struct{}   // closed when current is replaced
+	previous SessionStats    // totals of the connections already lost
+	lost     []LoggedMessage // messages of the connections already lost
+	err      error           // why the session ended
+}
+
+// NewReconnectingSession connects like NewSession and keeps the session
+// alive according to Config.Reconnect. The first connection is not retried.
+func (c *Client) NewReconnectingSession(ctx context.Context, agentID string, metadata map[string]interface{}) (*ReconnectingSession, error) {
+	s, err := c.NewSession(ctx, agentID, metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	rctx, cancel := context.WithCancelCause(context.Background())
+	r := &ReconnectingSession{
+		client:   c,
+		agentID:  agentID,
+		metadata: metadata,
+		cfg:      c.reconnect.withDefaults(),
+		audioOut: &audioOutput{rate: c.audioOutRate, bigEndian: c.bigEndian, logger: c.logger.With("component", "reconnect")},
+
+		ctx:      rctx,
+		cancel:   cancel,
+		msgs:     make(chan Message, 10),
+		done:     make(chan struct{}),
+		current:  s,
+		replaced: make(chan struct{}),
+	}
+
+	go r.run()
+
+	return r, nil
+}
+
+// log returns the logger for the reconnecting session's own records.
+func (r *ReconnectingSession) log() *slog.Logger {
+	return r.client.logger.With("component", "reconnect")
+}
+
+func (r *ReconnectingSession) session() Session {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.current
+}
+
+func (r *ReconnectingSession) StreamID() string     { return r.session().StreamID() }
+func (r *ReconnectingSession) Config() StreamConfig { return r.session().Config() }
+
+// Send sends m on the current connection. Control messages that fail because
+// the connection dropped are sent again once reconnected, up to
+// Config.SendRetries times. Audio is not resent here to avoid duplicates,
+// see ReconnectConfig.ResendTurn instead.
+func (r *ReconnectingSession) Send(ctx context.Context, m Message) error {
+	s, replaced := r.sessionAndReplaced()
+	err := s.Send(ctx, m)
+	if err == nil || !r.retryable(ctx, m.Type(), err) {
+		return err
+	}
+
+	// The retry goes through SendJSON to carry the new connection's stream_id
+	raw, marshalErr := json.Marshal(m)
+	if marshalErr != nil {
+		return err
+	}
+	return r.retry(ctx, m.Type(), raw, replaced, err)
+}
+
+// SendJSON sends raw on the current connection, retrying like Send.
+func (r *ReconnectingSession) SendJSON(ctx context.Context, raw json.RawMessage) error {
+	s, replaced := r.sessionAndReplaced()
+	err := s.SendJSON(ctx, raw)
+
+	var sendErr *SendError
+	if err == nil || !errors.As(err, &sendErr) || !r.retryable(ctx, sendErr.Type, err) {
+		return err
+	}
+	return r.retry(ctx, sendErr.Type, raw, replaced, err)
+}
+
+// retryable reports whether a failed send of typ may be repeated on the
+// next connection. Only writes lost with the connection qualify.
+func (r *ReconnectingSession) retryable(ctx context.Context, typ MessageType, err error) bool {
+	if r.client.sendRetries <= 0 || typ == MessageTypeMediaInput {
+		return false
+	}
+	return lostWithConnection(ctx, err)
+}
+
+// lostWithConnection reports whether a send failed because the connection
+// went away, rather than being cancelled or the session closed.
+func lostWithConnection(ctx context.Context, err error) bool {
+	var sendErr *SendError
+	if ctx.Err() != nil || errors.Is(err, ErrSendCancelled) || errors.Is(err, ErrSessionClosed) {
+		return false
+	}
+	return errors.As(err, &sendErr)
+}
+
+// retry waits for each reconnect and sends raw again until it succeeds, the
+// retries run out or the error is no longer retryable. err is the failure
+// on the connection that replaced signals the end of.
+func (r *ReconnectingSession) retry(ctx context.Context, typ MessageType, raw json.RawMessage, replaced chan struct{}, err error) error {
+	for attempt := 1; attempt <= r.client.sendRetries; attempt++ {
+		select {
+		case <-replaced:
+		case <-r.done:
+			return err
+		case <-ctx.Done():
+			return err
+		}
+
+		var s Session
+		s, replaced = r.sessionAndReplaced()
+
+		r.log().Info("Retrying send", "type", typ, "attempt", attempt)
+		err = s.SendJSON(ctx, raw)
+		if err == nil || !r.retryable(ctx, typ, err) {
+			return err
+		}
+	}
+	return err
+}
+
+func (r *ReconnectingSession) sessionAndReplaced() (Session, chan struct{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.current, r.replaced
+}
+
+func (r *ReconnectingSession) SendSilence(ctx context.Context, d time.Duration) error {
+	return r.session().SendSilence(ctx, d)
+}
+
+func (r *ReconnectingSession) SendText(ctx context.Context, text string) error {
+	return r.session().SendText(ctx, text)
+}
+
+func (r *ReconnectingSession) SendEncodedMedia(ctx context.Context, payload string) error {
+	return r.session().SendEncodedMedia(ctx, payload)
+}
+
+func (r *ReconnectingSession) Metadata() Metadata {
+	return r.session().Metadata()
+}
+
+// SetMetadata updates the metadata of the current connection and keeps the
+// update for the start events of later ones.
+func (r *ReconnectingSession) SetMetadata(ctx context.Context, md Metadata) error {
+	if err := r.session().SetMetadata(ctx, md); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.metadata = Metadata(r.metadata).merge(md)
+	return nil
+}
+
+func (r *ReconnectingSession) Messages() <-chan Message {
+	return r.msgs
+}
+
+// Subscribe works like on a single session, spanning all connections.
+func (r *ReconnectingSession) Subscribe(types ...MessageType) <-chan Message {
+	return r.subs.add(types)
+}
+
+// AudioOut works like on a single session, spanning all connections.
+func (r *ReconnectingSession) AudioOut() <-chan []byte {
+	return r.audioOut.get(r)
+}
+
+func (r *ReconnectingSession) DecodeMedia(payload string) ([]byte, error) {
+	return r.session().DecodeMedia(payload)
+}
+
+// Stats adds up the traffic of every connection. HandshakeDuration and
+// TerminatedBy are the current connection's, so TerminatedBy tells what ended
+// the last connection once reconnecting gave up.
+func (r *ReconnectingSession) Stats() SessionStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stats := r.current.Stats()
+	stats.BytesSent += r.previous.BytesSent
+	stats.BytesReceived += r.previous.BytesReceived
+	return stats
+}
+
+// Log returns the messages of every connection in order, see Session.Log.
+func (r *ReconnectingSession) Log() []LoggedMessage {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	current := r.current.Log()
+	if r.lost == nil {
+		return current
+	}
+	return append(append([]LoggedMessage(nil), r.lost...), current...)
+}
+
+func (r *ReconnectingSession) Close() error {
+	r.cancel(ErrSessionClosed)
+	<-r.done
+
+	return r.session().Close()
+}
+
+// Context returns a context that is cancelled once the session has ended
+// for good, but not while it reconnects. context.Cause reports the same
+// error as WaitClosed.
+func (r *ReconnectingSession) Context() context.Context {
+	return r.ctx
+}
+
+// WaitClosed blocks until the session has ended for good and returns why:
+// ErrSessionClosed after Close, ErrMaxDurationExceeded once a connection
+// reached Config.MaxSessionDuration, ErrSilenceTimeout once one stayed
+// silent for Config.MaxSilence, or ErrReconnectFailed wrapped with the last
+// connection error.
+func (r *ReconnectingSession) WaitClosed(ctx context.Context) error {
+	select {
+	case <-r.done:
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		return r.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// run forwards messages and replaces the connection whenever it fails.
+func (r *ReconnectingSession) run() {
+	defer close(r.done)
+	defer close(r.msgs)
+	defer r.subs.closeAll()
+
+	b := newBackoff(r.cfg)
+	current := r.session()
+	connectedAt := time.Now()
+
+	for {
+		err := r.forward(current)
+		if r.ctx.Err() != nil || errors.Is(err, ErrSessionClosed) {
+			r.finish(context.Cause(r.ctx))
+			return
+		}
+		if errors.Is(err, ErrMaxDurationExceeded) || errors.Is(err, ErrSilenceTimeout) {
+			r.finish(err)
+			return
+		}
+
+		if time.Since(connectedAt) >= r.cfg.StableAfter {
+			b.reset()
+		}
+
+		next, err := r.reconnect(b, err)
+		if err != nil {
+			r.finish(err)
+			return
+		}
+
+		r.mu.Lock()
+		stats := r.current.Stats()
+		r.previous.BytesSent += stats.BytesSent
+		r.previous.BytesReceived += stats.BytesReceived
+		r.lost = append(r.lost, r.current.Log()...)
+		r.current = next
+		close(r.replaced)
+		r.replaced = make(chan struct{})
+		r.mu.Unlock()
+
+		current = next
+		connectedAt = time.Now()
+	}
+}
+
+// forward relays messages from s until it terminates, returning the cause.
+func (r *ReconnectingSession) forward(s Session) error {
+	closed := make(chan error, 1)
+	go func() {
+		closed <- s.WaitClosed(r.ctx)
+	}()
+
+	for {
+		select {
+		case m := <-s.Messages():
+			if r.subs.route(r.ctx, m) {
+				continue
+			}
+			select {
+			case r.msgs <- m:
+			case <-r.ctx.Done():
+				return context.Cause(r.ctx)
+			}
+		case err := <-closed:
+			r.drain(s)
+			return err
+		}
+	}
+}
+
+// drain relays the messages s received before it terminated.
+func (r *ReconnectingSession) drain(s Session) {
+	for {
+		select {
+		case m := <-s.Messages():
+			if r.subs.route(r.ctx, m) {
+				continue
+			}
+			select {
+			case r.msgs <- m:
+			case <-r.ctx.Done():
+				return
+			}
+		default:
+			return
+		}
+	}
+}
+
+// reconnect dials until a new session is established or Config.ShouldReconnect
+// gives up.
+func (r *ReconnectingSession) reconnect(b *backoff, cause error) (Session, error) {
+	shouldRetry := r.client.shouldRetry
+	if shouldRetry == nil {
+		shouldRetry = r.defaultShouldRetry(b)
+	}
+
+	for attempt := 1; ; attempt++ {
+		retry, delay := shouldRetry(cause, attempt)
+		if !retry {
+			return nil, errors.Join(ErrReconnectFailed, cause)
+		}
+		r.log().Warn("Connection lost, reconnecting", "err", cause, "delay", delay, "attempt", attempt)
+
+		select {
+		case <-r.client.clock.After(delay):
+		case <-r.ctx.Done():
+			return nil, context.Cause(r.ctx)
+		}
+
+		r.mu.Lock()
+		metadata := r.metadata
+		r.mu.Unlock()
+
+		s, err := r.client.NewSession(r.ctx, r.agentID, metadata)
+		if err == nil {
+			r.log().Info("Reconnected", "stream_id", s.StreamID())
+			r.client.metrics.Reconnect()
+			return s, nil
+		}
+		cause = err
+	}
+}
+
+// defaultShouldRetry is the reconnect policy of ReconnectConfig: up to
+// MaxAttempts dials per outage, spaced by b, or by the server's Retry-After
+// when a dial was rate limited and it is longer.
+func (r *ReconnectingSession) defaultShouldRetry(b *backoff) func(err error, attempt int) (bool, time.Duration) {
+	return func(err error, attempt int) (bool, time.Duration) {
+		if errors.Is(err, ErrHandshakeRejected) || errors.Is(err, ErrUnsupportedVersion) || refusedForGood(err) {
+			// Retrying will not change the server's mind
+			return false, 0
+		}
+		if r.cfg.MaxAttempts > 0 && attempt > r.cfg.MaxAttempts {
+			return false, 0
+		}
+
+		delay := b.next()
+		var limited *RateLimitError
+		if errors.As(err, &limited) {
+			delay = max(delay, limited.RetryAfter)
+		}
+		return true, delay
+	}
+}
+
+// refusedForGood reports whether err is a dial refused with a client error
+// status other than 429, such as 401 for a bad API key or 404 for an
+// unknown agent, which the same request would get again.
+func refusedForGood(err error) bool {
+	var status *DialStatusError
+	return errors.As(err, &status) && status.StatusCode >= 400 && status.StatusCode < 500
+}
+
+// finish records why the session ended for good and cancels its context.
+func (r *ReconnectingSession) finish(err error) {
+	r.mu.Lock()
+	r.err = err
+	r.mu.Unlock()
+
+	r.cancel(err)
+}
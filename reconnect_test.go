@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// refuseAfter makes srv answer every connection after the first n with
+// status, counting the attempts in dials.
+func refuseAfter(srv *TestServer, n int32, status int, dials *atomic.Int32) {
+	serve := srv.server.Config.Handler
+	srv.server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if dials.Add(1) > n {
+			http.Error(w, http.StatusText(status), status)
+			return
+		}
+		serve.ServeHTTP(w, r)
+	})
+}
+
+func TestDialUnauthorized(t *testing.T) {
+	srv := NewTestServer(nil)
+	defer srv.Close()
+
+	var dials atomic.Int32
+	refuseAfter(srv, 0, http.StatusUnauthorized, &dials)
+
+	_, err := newTestClient(t, srv, Config{}).NewSession(context.Background(), "agent", nil)
+	var status *DialStatusError
+	if !errors.As(err, &status) || status.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("NewSession = %v, want a DialStatusError with status 401", err)
+	}
+	if !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("NewSession = %v, want it to match ErrUnauthorized", err)
+	}
+}
+
+func TestReconnectStopsOnClientError(t *testing.T) {
+	// The agent is gone by the time the dropped connection is redialled
+	srv := NewTestServer(&TestServerOptions{
+		Drop: func(msg Message) bool {
+			_, ok := msg.(*CustomMessage)
+			return ok
+		},
+	})
+	defer srv.Close()
+
+	var dials atomic.Int32
+	refuseAfter(srv, 1, http.StatusNotFound, &dials)
+
+	client := newTestClient(t, srv, Config{
+		Reconnect: &ReconnectConfig{InitialBackoff: 10 * time.Millisecond},
+	})
+	session, err := client.NewReconnectingSession(context.Background(), "agent", nil)
+	if err != nil {
+		t.Fatalf("NewReconnectingSession: %v", err)
+	}
+	defer session.Close()
+
+	if err := session.SendText(context.Background(), "bye"); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err = session.WaitClosed(ctx)
+	var status *DialStatusError
+	if !errors.As(err, &status) || status.StatusCode != http.StatusNotFound {
+		t.Fatalf("WaitClosed = %v, want the 404 of the redial", err)
+	}
+	if n := dials.Load(); n != 2 {
+		t.Errorf("server was dialled %d times, want 2: the session and one redial", n)
+	}
+}
+
+func TestReconnectingSessionContext(t *testing.T) {
+	srv := NewTestServer(&TestServerOptions{
+		Drop: func(msg Message) bool {
+			_, ok := msg.(*CustomMessage)
+			return ok
+		},
+	})
+	defer srv.Close()
+
+	var dials atomic.Int32
+	refuseAfter(srv, 100, http.StatusNotFound, &dials)
+
+	client := newTestClient(t, srv, Config{
+		Reconnect: &ReconnectConfig{InitialBackoff: 10 * time.Millisecond},
+	})
+	session, err := client.NewReconnectingSession(context.Background(), "agent", nil)
+	if err != nil {
+		t.Fatalf("NewReconnectingSession: %v", err)
+	}
+	defer session.Close()
+
+	// The context outlives a dropped connection
+	if err := session.SendText(context.Background(), "bye"); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; dials.Load() < 2; i++ {
+		if i == 500 {
+			t.Fatal("session did not reconnect")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := session.Context().Err(); err != nil {
+		t.Fatalf("context after a reconnect = %v, want it live", err)
+	}
+
+	// and ends with the session
+	session.Close()
+	select {
+	case <-session.Context().Done():
+		if cause := context.Cause(session.Context()); !errors.Is(cause, ErrSessionClosed) {
+			t.Errorf("context.Cause = %v, want ErrSessionClosed", cause)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("context not cancelled after Close")
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	const (
+		initial = 500 * time.Millisecond
+		limit   = 4 * time.Second
+	)
+	exponential := func(attempt int) time.Duration {
+		return min(initial<<attempt, limit)
+	}
+
+	tests := []struct {
+		jitter Jitter
+		bounds func(attempt int, prev time.Duration) (lo, hi time.Duration)
+	}{
+		{JitterNone, func(attempt int, _ time.Duration) (time.Duration, time.Duration) {
+			return exponential(attempt), exponential(attempt)
+		}},
+		{JitterFull, func(attempt int, _ time.Duration) (time.Duration, time.Duration) {
+			return 0, exponential(attempt)
+		}},
+		{JitterDecorrelated, func(_ int, prev time.Duration) (time.Duration, time.Duration) {
+			return initial, min(3*max(prev, initial), limit)
+		}},
+	}
+
+	for _, tt := range tests {
+		// The extremes of the random source, then random values
+		for _, random := range []func() float64{
+			func() float64 { return 0 },
+			func() float64 { return 0.999999 },
+			nil,
+		} {
+			b := newBackoff((&ReconnectConfig{InitialBackoff: initial, MaxReconnectBackoff: limit, Jitter: tt.jitter}).withDefaults())
+			if random != nil {
+				b.rand = random
+			}
+			for round := 0; round < 2; round++ {
+				var prev time.Duration
+				for attempt := 0; attempt < 10; attempt++ {
+					d := b.next()
+					lo, hi := tt.bounds(attempt, prev)
+					if d < lo || d > hi {
+						t.Errorf("jitter %d: delay %d = %s, want within [%s, %s]", tt.jitter, attempt, d, lo, hi)
+					}
+					prev = d
+				}
+				// A stable connection starts the delays over
+				b.reset()
+			}
+		}
+	}
+}
+
+func TestSendRetries(t *testing.T) {
+	for _, retries := range []int{0, 1} {
+		// The connection drops after a "drop" text
+		hellos := make(chan *CustomMessage, 2)
+		srv := NewTestServer(&TestServerOptions{
+			Respond: func(msg Message) []Message {
+				if m, ok := msg.(*CustomMessage); ok && m.Metadata["type"] == "hello" {
+					hellos <- m
+				}
+				return nil
+			},
+			Drop: func(msg Message) bool {
+				m, ok := msg.(*CustomMessage)
+				return ok && m.Metadata["text"] == "drop"
+			},
+		})
+		defer srv.Close()
+
+		client := newTestClient(t, srv, Config{
+			SendRetries: retries,
+			Reconnect:   &ReconnectConfig{InitialBackoff: 200 * time.Millisecond},
+		})
+		session, err := client.NewReconnectingSession(context.Background(), "agent", nil)
+		if err != nil {
+			t.Fatalf("NewReconnectingSession: %v", err)
+		}
+		defer session.Close()
+
+		first := session.session()
+		if err := session.SendText(context.Background(), "drop"); err != nil {
+			t.Fatal(err)
+		}
+		<-first.Context().Done()
+
+		// Sent while reconnecting, the message fails on the lost connection
+		// and with a retry is sent again on the next one
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		msg := &CustomMessage{Event: MessageTypeCustom, StreamID: first.StreamID(), Metadata: Metadata{"type": "hello"}}
+		err = session.Send(ctx, msg)
+		if retries == 0 {
+			var sendErr *SendError
+			if !errors.As(err, &sendErr) {
+				t.Errorf("Send without retries = %v, want the SendError of the lost connection", err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Send with a retry: %v", err)
+		}
+
+		select {
+		case m := <-hellos:
+			if m.StreamID != session.StreamID() || m.StreamID == first.StreamID() {
+				t.Errorf("server received the message on stream %q, want the new stream %q", m.StreamID, session.StreamID())
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("server did not receive the retried message")
+		}
+		select {
+		case <-hellos:
+			t.Error("server received the message twice")
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
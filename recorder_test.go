@@ -0,0 +1,611 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-audio/wav"
+)
+
+// pcmOf returns 16-bit little-endian PCM holding n samples of value.
+func pcmOf(n int, value int16) []byte {
+	pcm := make([]byte, n*2)
+	for i := 0; i < n; i++ {
+		pcm[i*2] = byte(value)
+		pcm[i*2+1] = byte(uint16(value) >> 8)
+	}
+	return pcm
+}
+
+// decodeRecording decodes a stereo recording into interleaved samples.
+func decodeRecording(t *testing.T, r io.ReadSeeker) []int {
+	t.Helper()
+
+	dec := wav.NewDecoder(r)
+	buf, err := dec.FullPCMBuffer()
+	if err != nil {
+		t.Fatalf("decode recording: %v", err)
+	}
+	if dec.NumChans != 2 {
+		t.Fatalf("recording has %d channels, want 2", dec.NumChans)
+	}
+	return buf.Data
+}
+
+func TestOpenDualChannelRecorderAppends(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "call.wav")
+
+	rec, err := NewDualChannelRecorder(path, 16000, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rec.WriteLeft(pcmOf(100, 1000)); err != nil {
+		t.Fatal(err)
+	}
+	if err := rec.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	rec, err = OpenDualChannelRecorder(path)
+	if err != nil {
+		t.Fatalf("OpenDualChannelRecorder: %v", err)
+	}
+	if got := rec.Stats().Frames; got != 100 {
+		t.Errorf("reopened recorder has %d frames, want 100", got)
+	}
+	if err := rec.WriteRight(pcmOf(50, -2000)); err != nil {
+		t.Fatal(err)
+	}
+	if err := rec.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	data := decodeRecording(t, f)
+	if len(data) != 150*2 {
+		t.Fatalf("recording has %d frames, want 150", len(data)/2)
+	}
+	for i := 0; i < 150; i++ {
+		left, right := 1000, 0
+		if i >= 100 {
+			left, right = 0, -2000
+		}
+		if data[i*2] != left || data[i*2+1] != right {
+			t.Fatalf("frame %d = (%d, %d), want (%d, %d)", i, data[i*2], data[i*2+1], left, right)
+		}
+	}
+}
+
+func TestOpenDualChannelRecorderRejectsMissingFile(t *testing.T) {
+	if _, err := OpenDualChannelRecorder(filepath.Join(t.TempDir(), "missing.wav")); err == nil {
+		t.Fatal("OpenDualChannelRecorder of a missing file succeeded")
+	}
+}
+
+func TestRecorderCompress(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "call.wav")
+
+	rec, err := NewDualChannelRecorder(path, 16000, &RecorderOptions{Compress: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.Path() != path+".gz" {
+		t.Errorf("Path() = %q, want %q", rec.Path(), path+".gz")
+	}
+	if err := rec.WriteLeft(pcmOf(160, 500)); err != nil {
+		t.Fatal(err)
+	}
+	if err := rec.WriteRight(pcmOf(80, -500)); err != nil {
+		t.Fatal(err)
+	}
+	if err := rec.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("uncompressed %s left behind: %v", path, err)
+	}
+	f, err := os.Open(path + ".gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatalf("gzip.NewReader: %v", err)
+	}
+	raw, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatalf("gunzip recording: %v", err)
+	}
+
+	if data := decodeRecording(t, bytes.NewReader(raw)); len(data) != 240*2 {
+		t.Errorf("recording has %d frames, want 240", len(data)/2)
+	}
+}
+
+func TestRecorderCheckFormat(t *testing.T) {
+	// The agent confirms another rate than the recorder was created for
+	srv := NewTestServer(&TestServerOptions{AckConfig: &StreamConfig{InputFormat: InputFormatPCM24000}})
+	defer srv.Close()
+
+	session := newTestSession(t, srv, Config{})
+
+	rec, err := NewDualChannelRecorder(filepath.Join(t.TempDir(), "call.wav"), 16000, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rec.Close()
+
+	if rec.CheckFormat(session.Config()) {
+		t.Error("CheckFormat accepted a 24000 Hz session for a 16000 Hz recording")
+	}
+	if !rec.CheckFormat(StreamConfig{InputFormat: InputFormatPCM16000}) {
+		t.Error("CheckFormat rejected a session at the recording's rate")
+	}
+	if !rec.CheckFormat(StreamConfig{InputFormat: InputFormatPCM, SampleRate: 16000}) {
+		t.Error("CheckFormat rejected pcm at the recording's rate")
+	}
+}
+
+func TestRecorderInsertGap(t *testing.T) {
+	srv := NewTestServer(&TestServerOptions{
+		OnStart: func(start *StartMessage) []Message {
+			return []Message{NewMediaOutputFromPCM(start.StreamID, loudPCM(300*time.Millisecond, 16000))}
+		},
+	})
+	defer srv.Close()
+
+	path := filepath.Join(t.TempDir(), "call.wav")
+	rec, err := NewDualChannelRecorder(path, 16000, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// One recording spans two connections with a 500ms outage between them
+	for i := 0; i < 2; i++ {
+		if i > 0 {
+			if err := rec.InsertGap(500 * time.Millisecond); err != nil {
+				t.Fatalf("InsertGap: %v", err)
+			}
+		}
+		session := newTestSession(t, srv, Config{})
+		if err := NewConversation(session, rec).DrainUntilSilence(context.Background(), 300*time.Millisecond); err != nil {
+			t.Fatalf("DrainUntilSilence: %v", err)
+		}
+		session.Close()
+	}
+	if err := rec.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	data := decodeRecording(t, f)
+	if len(data) != 17600*2 {
+		t.Fatalf("recording has %d frames, want 17600", len(data)/2)
+	}
+	for i := 0; i < 17600; i++ {
+		gap := i >= 4800 && i < 12800
+		if silent := data[i*2+1] == 0; silent != gap {
+			t.Fatalf("agent channel at frame %d is %d, want silence only in the gap", i, data[i*2+1])
+		}
+	}
+}
+
+func TestRecorderTimingFile(t *testing.T) {
+	dir := t.TempDir()
+	timingFile := filepath.Join(dir, "call.timing.jsonl")
+	rec, err := NewDualChannelRecorder(filepath.Join(dir, "call.wav"), 16000, &RecorderOptions{TimingFile: timingFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	before := time.Now()
+	if err := rec.WriteLeft(pcmOf(100, 1)); err != nil {
+		t.Fatal(err)
+	}
+	if err := rec.WriteRight(pcmOf(50, 1)); err != nil {
+		t.Fatal(err)
+	}
+	if err := rec.InsertGap(10 * time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if err := rec.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(timingFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	want := []TimingEntry{
+		{Channel: "left", Offset: 0, Frames: 100},
+		{Channel: "right", Offset: 100, Frames: 50},
+		{Channel: "gap", Offset: 150, Frames: 160},
+	}
+	var got []TimingEntry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var entry TimingEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatalf("invalid line %q: %v", scanner.Text(), err)
+		}
+		got = append(got, entry)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("timing file has %d entries, want %d", len(got), len(want))
+	}
+	for i, entry := range got {
+		if entry.Channel != want[i].Channel || entry.Offset != want[i].Offset || entry.Frames != want[i].Frames {
+			t.Errorf("entry %d = %+v, want %+v", i, entry, want[i])
+		}
+		if entry.Time.Before(before) || i > 0 && entry.Time.Before(got[i-1].Time) {
+			t.Errorf("entry %d written at %s, out of order", i, entry.Time)
+		}
+	}
+}
+
+func TestRecorderContinueOnError(t *testing.T) {
+	srv := newConversationServer(nil)
+	defer srv.Close()
+
+	session := newTestSession(t, srv, Config{})
+	rec, err := NewDualChannelRecorder(filepath.Join(t.TempDir(), "call.wav"), 16000, &RecorderOptions{ContinueOnError: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Every write fails, as on a full disk
+	rec.file.Close()
+
+	ctx := context.Background()
+	conversation := NewConversation(session, rec)
+	if err := conversation.DrainUntilSilence(ctx, 200*time.Millisecond); err != nil {
+		t.Fatalf("DrainUntilSilence of the greeting: %v", err)
+	}
+	if rec.Recording() {
+		t.Error("Recording() = true after a failed write")
+	}
+
+	// The conversation goes on without the recording, with the question and
+	// trailing silence the agent waits for
+	question := append(loudPCM(500*time.Millisecond, 16000), make([]byte, 32000)...)
+	if err := conversation.StreamAudio(ctx, question); err != nil {
+		t.Fatalf("StreamAudio: %v", err)
+	}
+	if err := conversation.DrainUntilSilence(ctx, 200*time.Millisecond); err != nil {
+		t.Fatalf("DrainUntilSilence of the answer: %v", err)
+	}
+	waitForFrames(t, srv, questionFrames)
+
+	// Without the option the write error ends the conversation
+	strict, err := NewDualChannelRecorder(filepath.Join(t.TempDir(), "strict.wav"), 16000, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	strict.file.Close()
+	if err := strict.WriteLeft(pcmOf(100, 1)); err == nil {
+		t.Error("WriteLeft to a failing file succeeded, want the error")
+	}
+	if !strict.Recording() {
+		t.Error("Recording() = false without ContinueOnError")
+	}
+}
+
+// readWAVInfo reads the LIST/INFO metadata of the WAV file at path.
+func readWAVInfo(t *testing.T, path string) *wav.Metadata {
+	t.Helper()
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	dec := wav.NewDecoder(f)
+	dec.ReadMetadata()
+	if err := dec.Err(); err != nil {
+		t.Fatalf("read metadata: %v", err)
+	}
+	if dec.Metadata == nil {
+		t.Fatal("recording has no INFO chunk")
+	}
+	return dec.Metadata
+}
+
+func TestRecorderMetadata(t *testing.T) {
+	srv := NewTestServer(&TestServerOptions{
+		OnStart: func(start *StartMessage) []Message {
+			return []Message{NewMediaOutputFromPCM(start.StreamID, loudPCM(100*time.Millisecond, 16000))}
+		},
+	})
+	defer srv.Close()
+
+	session := newTestSession(t, srv, Config{})
+	path := filepath.Join(t.TempDir(), "call.wav")
+	// An odd-sized value must not hide the entries after it
+	comments := "agent_id=agent stream_id=" + session.StreamID() + "x"
+	rec, err := NewDualChannelRecorder(path, 16000, &RecorderOptions{Metadata: map[string]string{
+		WAVInfoComments: comments,
+		"inam":          "greeting",
+		"ICRD":          "2026-10-16",
+	}})
+	if err != nil {
+		t.Fatalf("NewDualChannelRecorder: %v", err)
+	}
+	if err := NewConversation(session, rec).DrainUntilSilence(context.Background(), 300*time.Millisecond); err != nil {
+		t.Fatalf("DrainUntilSilence: %v", err)
+	}
+	if err := rec.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	check := func(when string) {
+		t.Helper()
+		meta := readWAVInfo(t, path)
+		if meta.Comments != comments || meta.Title != "greeting" || meta.CreationDate != "2026-10-16" {
+			t.Errorf("%s: INFO = %q, %q, %q; want %q, %q, %q", when,
+				meta.Comments, meta.Title, meta.CreationDate, comments, "greeting", "2026-10-16")
+		}
+	}
+	check("after recording")
+
+	// The metadata survives appending, which moves it after the new audio
+	appended, err := OpenDualChannelRecorder(path)
+	if err != nil {
+		t.Fatalf("OpenDualChannelRecorder: %v", err)
+	}
+	if err := appended.WriteLeft(pcmOf(1600, 1)); err != nil {
+		t.Fatal(err)
+	}
+	if err := appended.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	check("after appending")
+
+	// An empty recording keeps its metadata too, rather than taking it for
+	// audio that runs to the end of the file
+	empty := filepath.Join(t.TempDir(), "empty.wav")
+	rec, err = NewDualChannelRecorder(empty, 16000, &RecorderOptions{Metadata: map[string]string{WAVInfoComments: comments}})
+	if err != nil {
+		t.Fatalf("NewDualChannelRecorder: %v", err)
+	}
+	if err := rec.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if appended, err = OpenDualChannelRecorder(empty); err != nil {
+		t.Fatalf("OpenDualChannelRecorder: %v", err)
+	}
+	if err := appended.WriteLeft(pcmOf(100, 1000)); err != nil {
+		t.Fatal(err)
+	}
+	if err := appended.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if meta := readWAVInfo(t, empty); meta.Comments != comments {
+		t.Errorf("INFO comments after appending to an empty recording = %q, want %q", meta.Comments, comments)
+	}
+	f, err := os.Open(empty)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if samples := decodeRecording(t, f); len(samples) != 200 || samples[0] != 1000 {
+		t.Errorf("recording has %d samples starting %v, want only the 100 frames appended", len(samples), samples[:min(len(samples), 2)])
+	}
+
+	if _, err := NewDualChannelRecorder(filepath.Join(t.TempDir(), "bad.wav"), 16000,
+		&RecorderOptions{Metadata: map[string]string{"XXXX": "1"}}); err == nil {
+		t.Error("NewDualChannelRecorder accepted an unknown INFO ID")
+	}
+}
+
+func TestRecorderStats(t *testing.T) {
+	srv := newConversationServer(nil)
+	defer srv.Close()
+
+	session := newTestSession(t, srv, Config{})
+	path := filepath.Join(t.TempDir(), "call.wav")
+	rec, err := NewDualChannelRecorder(path, 16000, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A 300ms greeting, 500ms of speech and a second of silence from the
+	// user, and a 300ms answer
+	conversation := NewConversation(session, rec)
+	ctx := context.Background()
+	if err := conversation.DrainUntilSilence(ctx, 200*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if err := conversation.StreamAudio(ctx, loudPCM(500*time.Millisecond, 16000)); err != nil {
+		t.Fatal(err)
+	}
+	if err := conversation.EndTurn(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := conversation.DrainUntilSilence(ctx, 200*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if err := rec.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := RecorderStats{LeftFrames: 24000, RightFrames: 9600, Frames: 33600, Duration: 2100 * time.Millisecond}
+	if got := rec.Stats(); got != want {
+		t.Errorf("Stats() = %+v, want %+v", got, want)
+	}
+	in, err := readWAV(path)
+	if err != nil {
+		t.Fatalf("readWAV: %v", err)
+	}
+	if frames := int64(len(in.data) / 4); frames != want.Frames {
+		t.Errorf("recording holds %d frames, want the %d reported", frames, want.Frames)
+	}
+}
+
+func TestRecorderCloseRace(t *testing.T) {
+	// The agent talks in many short frames, so the conversation is still
+	// recording when the recorder is closed
+	srv := NewTestServer(&TestServerOptions{
+		OnStart: func(start *StartMessage) []Message {
+			var greeting []Message
+			for i := 0; i < 200; i++ {
+				greeting = append(greeting, NewMediaOutputFromPCM(start.StreamID, loudPCM(20*time.Millisecond, 16000)))
+			}
+			return greeting
+		},
+	})
+	defer srv.Close()
+
+	type statsRecorder interface {
+		Recorder
+		Stats() RecorderStats
+	}
+	recorders := map[string]func(path string) (statsRecorder, error){
+		"dual": func(path string) (statsRecorder, error) {
+			return NewDualChannelRecorder(path, 16000, nil)
+		},
+		"timeline": func(path string) (statsRecorder, error) {
+			return NewTimelineRecorder(path, 16000, nil)
+		},
+	}
+	for name, open := range recorders {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "call.wav")
+			rec, err := open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			session := newTestSession(t, srv, Config{})
+			drained := make(chan error, 1)
+			go func() {
+				drained <- NewConversation(session, rec).DrainUntilSilence(context.Background(), 300*time.Millisecond)
+			}()
+			// The user side writes concurrently too
+			written := make(chan error, 1)
+			go func() {
+				for {
+					if err := rec.WriteLeft(pcmOf(160, 1)); err != nil {
+						written <- err
+						return
+					}
+				}
+			}()
+
+			for rec.Stats().RightFrames == 0 {
+				time.Sleep(time.Millisecond)
+			}
+			if err := rec.Close(); err != nil {
+				t.Fatalf("Close: %v", err)
+			}
+
+			if err := <-written; !errors.Is(err, ErrRecorderClosed) {
+				t.Errorf("WriteLeft after Close = %v, want ErrRecorderClosed", err)
+			}
+			if err := <-drained; err != nil && !errors.Is(err, ErrRecorderClosed) {
+				t.Errorf("DrainUntilSilence = %v, want nil or ErrRecorderClosed", err)
+			}
+			if err := rec.Close(); !errors.Is(err, ErrRecorderClosed) {
+				t.Errorf("second Close = %v, want ErrRecorderClosed", err)
+			}
+
+			// Everything written before Close made it into a valid file
+			in, err := readWAV(path)
+			if err != nil {
+				t.Fatalf("readWAV: %v", err)
+			}
+			if frames := int64(len(in.data) / 4); frames != rec.Stats().Frames {
+				t.Errorf("recording holds %d frames, want the %d reported", frames, rec.Stats().Frames)
+			}
+		})
+	}
+}
+
+func TestRecorderTargetSampleRate(t *testing.T) {
+	// A 44.1 kHz agent greets with a second of audio in 100ms frames
+	srv := NewTestServer(&TestServerOptions{
+		OnStart: func(start *StartMessage) []Message {
+			var greeting []Message
+			for i := 0; i < 10; i++ {
+				greeting = append(greeting, NewMediaOutputFromPCM(start.StreamID, loudPCM(100*time.Millisecond, 44100)))
+			}
+			return greeting
+		},
+	})
+	defer srv.Close()
+
+	session := newTestSession(t, srv, Config{InputFormat: InputFormatPCM44100})
+	path := filepath.Join(t.TempDir(), "call.wav")
+	rec, err := NewDualChannelRecorder(path, session.Config().Rate(), &RecorderOptions{TargetSampleRate: 16000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rec.CheckFormat(session.Config()) {
+		t.Error("CheckFormat rejected a 44.1 kHz session for a resampled recording")
+	}
+
+	conversation := NewConversation(session, rec)
+	if err := conversation.DrainUntilSilence(context.Background(), 300*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	// and the user answers with 500ms
+	if err := conversation.StreamAudio(context.Background(), loudPCM(500*time.Millisecond, 44100)); err != nil {
+		t.Fatal(err)
+	}
+	if err := rec.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	in, err := readWAV(path)
+	if err != nil {
+		t.Fatalf("readWAV: %v", err)
+	}
+	if in.sampleRate != 16000 || in.channels != 2 {
+		t.Fatalf("recording is %s, want stereo at 16000 Hz", in)
+	}
+	// 1.5s at 16 kHz, give or take a sample per frame from resampling in pieces
+	frames := len(in.data) / 4
+	if frames < 24000-15 || frames > 24000+15 {
+		t.Errorf("recording has %d frames, want about 24000", frames)
+	}
+	stats := rec.Stats()
+	if d := stats.RightFrames - 16000; d < -10 || d > 10 {
+		t.Errorf("right channel has %d frames, want about 16000", stats.RightFrames)
+	}
+	if d := stats.LeftFrames - 8000; d < -5 || d > 5 {
+		t.Errorf("left channel has %d frames, want about 8000", stats.LeftFrames)
+	}
+
+	// The level of the audio survives resampling
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	samples := decodeRecording(t, f)
+	if right := samples[2*8000+1]; right != 0x4000 {
+		t.Errorf("agent sample mid-greeting = %d, want %d", right, 0x4000)
+	}
+}
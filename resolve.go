@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"fmt"
+)
+
+// ResolveAgent maps a human-friendly agent name to its ID with
+// Config.AgentResolver, caching the result for the lifetime of the client.
+// The streaming API has no name lookup of its own, so without a resolver the
+// name is returned unchanged and treated as an ID.
+func (c *Client) ResolveAgent(ctx context.Context, name string) (string, error) {
+	if c.resolveAgent == nil {
+		return name, nil
+	}
+
+	c.agentsMu.Lock()
+	id, ok := c.agents[name]
+	c.agentsMu.Unlock()
+	if ok {
+		return id, nil
+	}
+
+	id, err := c.resolveAgent(ctx, name)
+	if err != nil {
+		return "", fmt.Errorf("resolve agent %q error: %w", name, err)
+	}
+
+	c.agentsMu.Lock()
+	if c.agents == nil {
+		c.agents = make(map[string]string)
+	}
+	c.agents[name] = id
+	c.agentsMu.Unlock()
+
+	return id, nil
+}
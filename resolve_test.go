@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestResolveAgent(t *testing.T) {
+	// A registry mapping agent names to IDs over HTTP
+	var lookups atomic.Int32
+	registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lookups.Add(1)
+		if r.URL.Query().Get("name") != "Support Desk" {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id": "agent_123"})
+	}))
+	defer registry.Close()
+
+	resolve := func(ctx context.Context, name string) (string, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, registry.URL+"?name="+url.QueryEscape(name), nil)
+		if err != nil {
+			return "", err
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return "", fmt.Errorf("registry: %s", resp.Status)
+		}
+		var agent struct{ ID string }
+		err = json.NewDecoder(resp.Body).Decode(&agent)
+		return agent.ID, err
+	}
+
+	srv := NewTestServer(nil)
+	defer srv.Close()
+
+	paths := make(chan string, 2)
+	serve := srv.server.Config.Handler
+	srv.server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths <- r.URL.Path
+		serve.ServeHTTP(w, r)
+	})
+
+	client := newTestClient(t, srv, Config{AgentResolver: resolve})
+	for i := 0; i < 2; i++ {
+		session, err := client.NewSession(context.Background(), "Support Desk", nil)
+		if err != nil {
+			t.Fatalf("NewSession: %v", err)
+		}
+		session.Close()
+
+		if path := <-paths; !strings.Contains(path, "agent_123") {
+			t.Errorf("dialled %q, want the resolved agent ID", path)
+		}
+	}
+	if n := lookups.Load(); n != 1 {
+		t.Errorf("registry was asked %d times, want 1 with the result cached", n)
+	}
+
+	if _, err := client.NewSession(context.Background(), "Nobody", nil); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("NewSession of an unknown agent = %v, want the registry's error", err)
+	}
+	if len(paths) != 0 {
+		t.Error("an unresolved agent was dialled")
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"os"
+	"sync"
+	"time"
+
+	"github.com/go-audio/audio"
+	"github.com/go-audio/wav"
+)
+
+// RingRecorder keeps the most recent agent audio in a fixed-size memory
+// buffer, e.g. to replay what the agent just said. User audio is not kept.
+// Audio must be 16-bit PCM. Snapshot may be called while audio is written.
+type RingRecorder struct {
+	sampleRate int
+
+	mu   sync.Mutex
+	buf  []byte
+	pos  int  // next write position
+	full bool // buf has wrapped around at least once
+}
+
+// NewRingRecorder creates a recorder holding up to maxDuration of mono
+// audio at sampleRate.
+func NewRingRecorder(maxDuration time.Duration, sampleRate int) *RingRecorder {
+	cfg := StreamConfig{InputFormat: InputFormatPCM, SampleRate: sampleRate}
+
+	return &RingRecorder{
+		sampleRate: sampleRate,
+		buf:        make([]byte, max(cfg.BytesForDuration(maxDuration), 2)),
+	}
+}
+
+// WriteLeft drops user audio.
+func (r *RingRecorder) WriteLeft(data []byte) error {
+	return nil
+}
+
+// WriteRight appends agent audio, overwriting the oldest audio once full.
+func (r *RingRecorder) WriteRight(data []byte) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// Only the tail of an oversized write fits
+	if len(data) >= len(r.buf) {
+		copy(r.buf, data[len(data)-len(r.buf):])
+		r.pos = 0
+		r.full = true
+		return nil
+	}
+
+	n := copy(r.buf[r.pos:], data)
+	if n < len(data) {
+		copy(r.buf, data[n:])
+		r.full = true
+	}
+	r.pos = (r.pos + len(data)) % len(r.buf)
+	if r.pos == 0 {
+		r.full = true
+	}
+
+	return nil
+}
+
+// Bytes returns a copy of the buffered audio, oldest first.
+func (r *RingRecorder) Bytes() []byte {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !r.full {
+		return append([]byte(nil), r.buf[:r.pos]...)
+	}
+	return append(append([]byte(nil), r.buf[r.pos:]...), r.buf[:r.pos]...)
+}
+
+// Snapshot writes the buffered audio to a mono WAV file.
+func (r *RingRecorder) Snapshot(filename string) error {
+	data := r.Bytes()
+
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	encoder := wav.NewEncoder(file, r.sampleRate, 16, 1, 1)
+
+	samples := bytesToInt16(data)
+	buf := &audio.IntBuffer{
+		Format:         &audio.Format{NumChannels: 1, SampleRate: r.sampleRate},
+		Data:           make([]int, len(samples)),
+		SourceBitDepth: 16,
+	}
+	for i, s := range samples {
+		buf.Data[i] = int(s)
+	}
+
+	if err := encoder.Write(buf); err != nil {
+		return err
+	}
+	if err := encoder.Close(); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// Close is a no-op; the buffer stays available for Snapshot.
+func (r *RingRecorder) Close() error {
+	return nil
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRingRecorderSnapshot(t *testing.T) {
+	// A 500ms greeting of distinct samples in 100ms frames
+	greeting := make([]int16, 8000)
+	for i := range greeting {
+		greeting[i] = int16(i + 1000)
+	}
+	pcm := pcm16Codec{}.Encode(greeting)
+	srv := NewTestServer(&TestServerOptions{
+		OnStart: func(start *StartMessage) []Message {
+			var frames []Message
+			for off := 0; off < len(pcm); off += 3200 {
+				frames = append(frames, NewMediaOutputFromPCM(start.StreamID, pcm[off:off+3200]))
+			}
+			return frames
+		},
+	})
+	defer srv.Close()
+
+	session := newTestSession(t, srv, Config{})
+	ring := NewRingRecorder(250*time.Millisecond, 16000)
+	if err := NewConversation(session, ring).DrainUntilSilence(context.Background(), 300*time.Millisecond); err != nil {
+		t.Fatalf("DrainUntilSilence: %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "recent.wav")
+	if err := ring.Snapshot(path); err != nil {
+		t.Fatalf("Snapshot: %v", err)
+	}
+	in, err := readWAV(path)
+	if err != nil {
+		t.Fatalf("readWAV: %v", err)
+	}
+	if in.sampleRate != 16000 || in.channels != 1 {
+		t.Errorf("snapshot is %s, want mono at 16000 Hz", in)
+	}
+	// Only the last 250ms remain, oldest first
+	if tail := pcm[len(pcm)-8000:]; !bytes.Equal(in.data, tail) {
+		t.Errorf("snapshot holds %d bytes, want the last %d of the greeting", len(in.data), len(tail))
+	}
+}
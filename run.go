@@ -0,0 +1,378 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// ConversationOptions
+type ConversationOptions struct {
+	// Metadata is merged on top of Config.Metadata for the session.
+	Metadata Metadata
+
+	// Transcript, when set, receives the lines of the agent's transcript
+	// custom events, see NewTranscriptMessage.
+	Transcript string
+
+	// Events, when set, receives the control events on the recording's clock.
+	Events string
+
+	// Timeline records audio at the time it happened, keeping silences;
+	// see TimelineRecorder.
+	Timeline bool
+
+	// RecordSampleRate resamples the recording, 0 keeps the session's rate.
+	RecordSampleRate int
+
+	// Reconnect uses a ReconnectingSession, which resumes after dropped
+	// connections.
+	Reconnect bool
+
+	// Completion decides when each agent turn is over, by default after two
+	// seconds of silence.
+	Completion CompletionStrategy
+
+	// Started, when set, receives the conversation once the session is
+	// open, e.g. to end it early with Conversation.Stop.
+	Started func(c *Conversation)
+}
+
+// RunConversation runs a complete conversation with an agent: it connects,
+// waits for the agent's greeting, asks the question in inputWAV and waits
+// for the answer, recording both sides to the stereo WAV outputWAV (left
+// user, right agent). The input is read before dialing, so a bad file fails
+// without opening a session. ctx bounds the whole conversation; to end it
+// early without cutting off the recording use Conversation.Stop, see
+// ConversationOptions.Started, after which it returns nil.
+func RunConversation(ctx context.Context, cfg Config, agentID, inputWAV, outputWAV string) error {
+	return RunConversationWithOptions(ctx, cfg, agentID, inputWAV, outputWAV, nil)
+}
+
+// RunConversationWithOptions is RunConversation with the transcript, event
+// log, recording and turn-taking configured by opts, which may be nil.
+func RunConversationWithOptions(ctx context.Context, cfg Config, agentID, inputWAV, outputWAV string, opts *ConversationOptions) error {
+	if opts == nil {
+		opts = &ConversationOptions{}
+	}
+
+	// Ends the listener and its turn detector on every return
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Load the question before connecting
+	question, err := PrepareInput(inputWAV, cfg)
+	if err != nil {
+		return fmt.Errorf("failed to read input: %w", err)
+	}
+
+	// Create client
+	client, err := NewClient(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to create client: %w", err)
+	}
+
+	// Create session
+	var session Session
+	if opts.Reconnect {
+		session, err = client.NewReconnectingSession(ctx, agentID, opts.Metadata)
+	} else {
+		session, err = client.NewSession(ctx, agentID, opts.Metadata)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create session: %w", err)
+	}
+	defer session.Close()
+	logger := sessionLogger(session, "conversation")
+
+	// Convert the question again if the agent confirmed another format
+	requested := StreamConfig{InputFormat: cfg.InputFormat, SampleRate: cfg.SampleRate}
+	if confirmed := session.Config(); !confirmed.SameFormat(requested) {
+		cfg.InputFormat, cfg.SampleRate = confirmed.InputFormat, confirmed.SampleRate
+		if question, err = PrepareInput(inputWAV, cfg); err != nil {
+			return fmt.Errorf("failed to read input: %w", err)
+		}
+	}
+
+	// Initialize stereo audio recorder (left=user, right=agent) at the
+	// session's rate. Recording is optional, so the conversation continues without it.
+	// Write failures stop the recording rather than the conversation.
+	// With Timeline, silences are kept so the file plays back in real time.
+	recOpts := &RecorderOptions{
+		ContinueOnError:  true,
+		TargetSampleRate: opts.RecordSampleRate,
+		ByteOrder:        cfg.PCMByteOrder,
+		Logger:           cfg.Logger,
+		Metadata: map[string]string{
+			WAVInfoSoftware: "cartesia-agent-stream-example",
+			WAVInfoDate:     time.Now().Format("2006-01-02"),
+			WAVInfoComments: fmt.Sprintf("agent_id=%s stream_id=%s", agentID, session.StreamID()),
+		},
+	}
+	var (
+		recorder Recorder
+		rec      interface {
+			Recorder
+			Recording() bool
+			Stats() RecorderStats
+		}
+	)
+	if opts.Timeline {
+		rec, err = NewTimelineRecorder(outputWAV, session.Config().Rate(), recOpts)
+	} else {
+		rec, err = NewDualChannelRecorder(outputWAV, session.Config().Rate(), recOpts)
+	}
+	if err != nil {
+		logger.Warn("⚠️  Recording disabled", "err", err)
+	} else {
+		recorder = rec
+		defer func() {
+			if err := rec.Close(); err != nil {
+				logger.Warn("⚠️  Failed to close recording", "err", err)
+				return
+			}
+			stats := rec.Stats()
+			logger.Info("💾 Recorded", "duration", stats.Duration.Round(time.Millisecond),
+				"user_frames", stats.LeftFrames, "agent_frames", stats.RightFrames)
+			if timeline, ok := rec.(*TimelineRecorder); ok {
+				for _, span := range timeline.Overlaps() {
+					logger.Info("🗣️  Overlap", "at", span.Start.Round(time.Millisecond), "duration", span.Duration())
+				}
+			}
+		}()
+	}
+
+	// Optionally log the transcript alongside the recording
+	var transcript *TranscriptWriter
+	if opts.Transcript != "" {
+		transcript, err = NewTranscriptWriter(opts.Transcript)
+		if err != nil {
+			return fmt.Errorf("failed to create transcript: %w", err)
+		}
+		defer transcript.Close()
+	}
+
+	// Optionally log control events on the same clock as the recording
+	var events *EventLog
+	if opts.Events != "" {
+		events, err = NewEventLog(opts.Events)
+		if err != nil {
+			return fmt.Errorf("failed to create event log: %w", err)
+		}
+		defer events.Close()
+	}
+
+	// Agent turns end after a period of silence unless another strategy,
+	// e.g. SignalCompletion, is configured
+	completion := opts.Completion
+	if completion == nil {
+		completion = SilenceCompletion{Threshold: silenceThreshold}
+	}
+
+	conversation := NewConversation(session, recorder)
+	conversation.SetMaxInputDuration(cfg.MaxInputDuration)
+	if opts.Started != nil {
+		opts.Started(conversation)
+	}
+
+	// Coordination channels
+	sendQuestion := make(chan struct{})     // Signals when to send question
+	questionComplete := make(chan struct{}) // Signals question was sent
+	responseDone := make(chan error, 1)     // Signals conversation complete
+
+	// Start listener goroutine
+	go func() {
+		responseDone <- listenForResponses(ctx, conversation, transcript, events, completion, sendQuestion, questionComplete)
+	}()
+
+	// abort stops the listener and waits for it, so that it no longer
+	// writes to the recording once the deferred Close runs
+	abort := func(err error) error {
+		cancel()
+		<-responseDone
+		return err
+	}
+
+	// Wait for agent's initial greeting to complete
+	select {
+	case <-sendQuestion:
+		logger.Info("📤 Sending question...")
+	case err := <-responseDone:
+		return stopped(logger, err)
+	case <-ctx.Done():
+		return abort(ctx.Err())
+	}
+
+	// Send question audio
+	if err := sendAudio(ctx, conversation, question); err != nil {
+		if errors.Is(err, ErrConversationStopped) {
+			return stopped(logger, <-responseDone)
+		}
+		return abort(fmt.Errorf("failed to send audio: %w", err))
+	}
+	close(questionComplete)
+
+	// Wait for conversation to complete
+	if err := <-responseDone; err != nil {
+		return stopped(logger, err)
+	}
+
+	if recorder != nil && rec.Recording() {
+		logger.Info("💾 Audio saved", "path", outputWAV)
+	}
+	if transcript != nil {
+		logger.Info("📝 Transcript saved", "path", opts.Transcript)
+	}
+	return nil
+}
+
+// stopped treats a conversation ended by Stop as a success, so the deferred
+// cleanup finalizes the recording as after a normal end.
+func stopped(logger *slog.Logger, err error) error {
+	if errors.Is(err, ErrConversationStopped) {
+		logger.Info("🛑 Conversation stopped")
+		return nil
+	}
+	return err
+}
+
+// listenForResponses handles the conversation flow by monitoring agent audio
+// and coordinating turn-taking between agent greeting, user question, and agent response.
+// completion decides when each agent turn is over. Agent audio is recorded with the
+// conversation's recorder; a nil transcript discards the transcript events and a nil
+// event log the control events. Every message is passed to the conversation's
+// HandleMessage for flow control. It returns ErrConversationStopped after Stop.
+func listenForResponses(ctx context.Context, conversation *Conversation, transcript *TranscriptWriter, events *EventLog, completion CompletionStrategy, sendQuestion, questionComplete chan struct{}) error {
+	session, recorder, logger := conversation.session, conversation.recorder, conversation.logger
+	checkRecorderFormat(recorder, session.Config())
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	clock := sessionClock(session)
+	detector := NewTurnDetector(completion.Silence(), responseTimeout)
+	detector.SetClock(clock)
+	go detector.Run(ctx)
+
+	var (
+		greetingComplete = false
+		questionSent     = false
+		questionSentAt   time.Time
+	)
+
+	for {
+		select {
+		case msg, ok := <-session.Messages():
+			if !ok {
+				return fmt.Errorf("message channel closed")
+			}
+
+			if events != nil {
+				if err := events.Received(msg); err != nil {
+					return fmt.Errorf("write event error: %w", err)
+				}
+			}
+
+			// Flow-control hints pace the question while it is streamed
+			conversation.HandleMessage(msg)
+
+			// An explicit completion signal ends the turn without waiting for silence
+			if completion.Signal(msg) {
+				logger.Info("🏁 Completion signal received", "type", msg.Type())
+				detector.EndTurn()
+			}
+
+			switch m := msg.(type) {
+			case *MediaOutputMessage:
+				audioData, err := session.DecodeMedia(m.Media.Payload)
+				if err != nil {
+					logger.Warn("⚠️  Decode error", "err", err)
+					continue
+				}
+
+				if len(audioData) > 0 {
+					if err := writeAgentAudio(recorder, m, audioData); err != nil {
+						return fmt.Errorf("write audio error: %w", err)
+					}
+					detector.Audio()
+				}
+
+			case *CustomMessage:
+				role, text, ok := m.Transcript()
+				if !ok || transcript == nil {
+					continue
+				}
+				if err := transcript.Write(role, text); err != nil {
+					return fmt.Errorf("write transcript error: %w", err)
+				}
+
+			case *ClearMessage:
+				// Clear indicates agent buffer was cleared, not end of conversation
+				logger.Info("🔚 Clear event received")
+			}
+
+		case <-questionComplete:
+			if !questionSent {
+				logger.Info("📬 Question sent, waiting for response...")
+				questionSent = true
+				questionSentAt = clock.Now()
+				detector.ExpectResponse()
+			}
+			questionComplete = nil // Prevent repeat triggers
+
+		case ev, ok := <-detector.Events():
+			if !ok {
+				return ctx.Err()
+			}
+
+			// Ignore boundaries of agent audio that overlapped the question
+			if questionSent && ev.Time.Before(questionSentAt) {
+				continue
+			}
+
+			switch {
+			// Response started: the user-stop to agent-audio latency
+			case ev.Type == TurnStarted && questionSent:
+				logger.Info("⏱️  Response started", "latency", ev.Latency)
+
+			// Initial greeting complete: silence after agent starts speaking
+			case ev.Type == TurnEnded && !greetingComplete:
+				logger.Info("✅ Greeting complete")
+				greetingComplete = true
+				close(sendQuestion)
+
+			// Response complete: silence after agent responds to question
+			case ev.Type == TurnEnded && questionSent:
+				logger.Info("✅ Response complete")
+				return nil
+
+			// Timeout: no response after the question
+			case ev.Type == Timeout && questionSent:
+				logger.Warn("⚠️  No response", "timeout", responseTimeout)
+				return nil
+			}
+
+		case <-conversation.Stopped():
+			return ErrConversationStopped
+
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// sendAudio streams audio prepared in the session's format, e.g. by
+// PrepareInput, in real-time chunks followed by a second of silence to end
+// the turn, recording it to the left channel.
+func sendAudio(ctx context.Context, conversation *Conversation, audioData []byte) error {
+	if len(audioData) == 0 {
+		return ErrEmptyAudio
+	}
+
+	if err := conversation.StreamAudio(ctx, audioData); err != nil {
+		return err
+	}
+	return conversation.EndTurn(ctx)
+}
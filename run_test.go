@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunConversationWithoutRecording(t *testing.T) {
+	srv := newConversationServer(nil)
+	defer srv.Close()
+
+	// The recording cannot be created, the conversation goes on without it
+	input := writeTestWAV(t, "question.wav", loudPCM(500*time.Millisecond, 16000), 16000)
+	output := filepath.Join(t.TempDir(), "missing", "conversation.wav")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	err := RunConversationWithOptions(ctx, testConfig(srv, Config{}), "agent", input, output, &ConversationOptions{
+		Completion: SilenceCompletion{Threshold: 200 * time.Millisecond},
+	})
+	if err != nil {
+		t.Fatalf("RunConversationWithOptions: %v", err)
+	}
+	if n := mediaFrames(srv); n != questionFrames {
+		t.Errorf("server received %d media_input frames, want %d", n, questionFrames)
+	}
+	if _, err := os.Stat(output); !os.IsNotExist(err) {
+		t.Errorf("Stat(%s) = %v, want no recording", output, err)
+	}
+}
+
+func TestRunConversation(t *testing.T) {
+	srv := newConversationServer(nil)
+	defer srv.Close()
+
+	input := writeTestWAV(t, "question.wav", loudPCM(500*time.Millisecond, 16000), 16000)
+	output := filepath.Join(t.TempDir(), "conversation.wav")
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+	if err := RunConversation(ctx, testConfig(srv, Config{}), "agent", input, output); err != nil {
+		t.Fatalf("RunConversation: %v", err)
+	}
+
+	// The greeting, the question, the second of silence ending the turn
+	// and the answer follow each other in the recording
+	f, err := os.Open(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	samples := decodeRecording(t, f)
+
+	type run struct {
+		side   string
+		frames int
+	}
+	var runs []run
+	for i := 0; i < len(samples); i += 2 {
+		side := "silence"
+		switch {
+		case samples[i] != 0 && samples[i+1] != 0:
+			side = "both"
+		case samples[i] != 0:
+			side = "user"
+		case samples[i+1] != 0:
+			side = "agent"
+		}
+		if n := len(runs); n > 0 && runs[n-1].side == side {
+			runs[n-1].frames++
+			continue
+		}
+		runs = append(runs, run{side, 1})
+	}
+	want := []run{{"agent", 4800}, {"user", 8000}, {"silence", 16000}, {"agent", 4800}}
+	if !slices.Equal(runs, want) {
+		t.Errorf("recording runs = %v, want %v", runs, want)
+	}
+}
+
+func TestRunConversationClock(t *testing.T) {
+	srv := newConversationServer(nil)
+	defer srv.Close()
+
+	// Turn detection on Config.Clock waits for the clock, not real time
+	clock := NewFakeClock(time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC))
+	done := make(chan error, 1)
+	go func() {
+		_, err := runTestConversation(t, srv, Config{Clock: clock}, nil)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		t.Fatalf("conversation over without the clock moving: %v", err)
+	case <-time.After(500 * time.Millisecond):
+	}
+	if n := mediaFrames(srv); n != 0 {
+		t.Fatalf("question sent before the greeting ended on the clock, %d frames", n)
+	}
+
+	runFakeClock(t, clock, 20*time.Millisecond)
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("RunConversationWithOptions: %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("conversation did not end")
+	}
+	if n := mediaFrames(srv); n != questionFrames {
+		t.Errorf("server received %d media_input frames, want %d", n, questionFrames)
+	}
+}
+
+func TestRunConversationSendFailure(t *testing.T) {
+	srv := newConversationServer(nil)
+	defer srv.Close()
+
+	// The question is refused once the greeting is over, while the session
+	// is still open. The caller's context is never cancelled, so only the
+	// conversation itself can stop its goroutines.
+	input := writeTestWAV(t, "question.wav", loudPCM(500*time.Millisecond, 16000), 16000)
+	output := filepath.Join(t.TempDir(), "conversation.wav")
+	err := RunConversationWithOptions(context.Background(), testConfig(srv, Config{}), "agent", input, output, &ConversationOptions{
+		Completion: SilenceCompletion{Threshold: 200 * time.Millisecond},
+		Started:    func(c *Conversation) { c.SetMaxInputDuration(time.Millisecond) },
+	})
+	if !errors.Is(err, ErrInputTooLong) || !strings.Contains(err.Error(), "failed to send audio") {
+		t.Fatalf("RunConversationWithOptions = %v, want the failed send", err)
+	}
+
+	// Neither the listener nor its turn detector outlive the call
+	buf := make([]byte, 1<<20)
+	for i := 0; ; i++ {
+		stacks := string(buf[:runtime.Stack(buf, true)])
+		if !strings.Contains(stacks, "listenForResponses") && !strings.Contains(stacks, "(*TurnDetector).Run") {
+			break
+		}
+		if i == 100 {
+			t.Fatalf("conversation goroutines still running:\n%s", stacks)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+var (
+	ErrNoResponse = errors.New("no response from agent")
+)
+
+// TurnTiming records when a scripted turn was sent and answered.
+type TurnTiming struct {
+	File          string
+	SendStart     time.Time
+	SendEnd       time.Time
+	ResponseStart time.Time // first agent audio after the turn was sent
+	ResponseEnd   time.Time // last agent audio before the silence threshold
+}
+
+// Latency returns the time from the end of the user's turn to the agent's
+// first audio, or 0 if the agent did not answer.
+func (t TurnTiming) Latency() time.Duration {
+	if t.ResponseStart.IsZero() {
+		return 0
+	}
+	return t.ResponseStart.Sub(t.SendEnd)
+}
+
+// RunScript sends each WAV file as a separate user turn and waits for the
+// agent to answer before moving on to the next one. It should be called once
+// the agent's greeting is over. User audio is recorded to the left channel and
+// agent audio to the right; a nil recorder discards both. Files in another
+// format are converted to the session's format. All files are read before
+// the first turn, so a bad one fails the script without sending anything.
+//
+// The returned timings cover every attempted turn, including the one that
+// failed when an error is returned.
+func RunScript(ctx context.Context, session Session, recorder Recorder, files []string) ([]TurnTiming, error) {
+	if recorder == nil {
+		recorder = DiscardRecorder{}
+	}
+	checkRecorderFormat(recorder, session.Config())
+
+	cfg := session.Config()
+	turns := make([][]byte, len(files))
+	for i, file := range files {
+		audio, err := PrepareInput(file, Config{
+			InputFormat:      cfg.InputFormat,
+			SampleRate:       cfg.SampleRate,
+			AutoConvertInput: true,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("turn %d: read WAV error: %w", i+1, err)
+		}
+		turns[i] = audio
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	clock, logger := sessionClock(session), sessionLogger(session, "script")
+	detector := NewTurnDetector(silenceThreshold, responseTimeout)
+	detector.SetClock(clock)
+	go detector.Run(ctx)
+
+	timings := make([]TurnTiming, 0, len(files))
+	for i, file := range files {
+		logger.Info("📤 Sending turn", "turn", i+1, "turns", len(files), "file", file)
+
+		detector.Reset()
+		timing, err := runTurn(ctx, session, recorder, detector, clock, logger, file, turns[i])
+		timings = append(timings, timing)
+		if err != nil {
+			return timings, fmt.Errorf("turn %d (%s): %w", i+1, file, err)
+		}
+
+		logger.Info("✅ Turn complete", "turn", i+1, "latency", timing.Latency())
+	}
+
+	return timings, nil
+}
+
+// runTurn streams the audio of one file while recording agent audio, then
+// waits for the agent's response to finish. detector must have been reset
+// for the turn and run on clock, which also times the sending.
+func runTurn(ctx context.Context, session Session, recorder Recorder, detector *TurnDetector, clock Clock, logger *slog.Logger, file string, audio []byte) (TurnTiming, error) {
+	timing := TurnTiming{File: file, SendStart: clock.Now()}
+
+	turnCtx, cancelTurn := context.WithCancel(ctx)
+	defer cancelTurn()
+
+	sendDone := make(chan error, 1)
+	go func() {
+		sendDone <- sendAudio(turnCtx, NewConversation(session, recorder), audio)
+	}()
+
+	sent := false
+
+	for {
+		select {
+		case err := <-sendDone:
+			if err != nil {
+				return timing, err
+			}
+			sent = true
+			timing.SendEnd = clock.Now()
+			detector.ExpectResponse()
+			sendDone = nil
+
+		case msg, ok := <-session.Messages():
+			if !ok {
+				return timing, fmt.Errorf("message channel closed")
+			}
+
+			m, isMedia := msg.(*MediaOutputMessage)
+			if !isMedia {
+				continue
+			}
+
+			audioData, err := session.DecodeMedia(m.Media.Payload)
+			if err != nil {
+				logger.Warn("⚠️  Decode error", "err", err)
+				continue
+			}
+			if len(audioData) == 0 {
+				continue
+			}
+
+			if err := recorder.WriteRight(audioData); err != nil {
+				return timing, fmt.Errorf("write audio error: %w", err)
+			}
+			detector.Audio()
+
+		case ev, ok := <-detector.Events():
+			if !ok {
+				return timing, ctx.Err()
+			}
+
+			// Audio that arrives while the turn is still being sent is
+			// recorded but does not count as the response
+			if !sent || ev.Time.Before(timing.SendEnd) {
+				continue
+			}
+
+			switch ev.Type {
+			case TurnStarted:
+				timing.ResponseStart = ev.Time
+			case TurnEnded:
+				timing.ResponseEnd = ev.Time
+				return timing, nil
+			case Timeout:
+				return timing, ErrNoResponse
+			}
+
+		case <-ctx.Done():
+			return timing, ctx.Err()
+		}
+	}
+}
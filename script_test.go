@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRunScript(t *testing.T) {
+	// Each turn is 200ms of speech and the second of silence ending it, 12
+	// frames, which the agent answers
+	const turnFrames = 12
+	var frames atomic.Int32
+	srv := NewTestServer(&TestServerOptions{
+		Respond: func(msg Message) []Message {
+			m, ok := msg.(*MediaInputMessage)
+			if !ok || frames.Add(1)%turnFrames != 0 {
+				return nil
+			}
+			time.Sleep(100 * time.Millisecond)
+			return []Message{NewMediaOutputFromPCM(m.StreamID, loudPCM(300*time.Millisecond, 16000))}
+		},
+	})
+	defer srv.Close()
+
+	files := []string{
+		writeTestWAV(t, "first.wav", loudPCM(200*time.Millisecond, 16000), 16000),
+		writeTestWAV(t, "second.wav", loudPCM(200*time.Millisecond, 8000), 8000), // converted
+	}
+
+	session := newTestSession(t, srv, Config{})
+	recorder := &testRecorder{}
+	timings, err := RunScript(context.Background(), session, recorder, files)
+	if err != nil {
+		t.Fatalf("RunScript: %v", err)
+	}
+
+	if len(timings) != 2 {
+		t.Fatalf("RunScript returned %d timings, want 2", len(timings))
+	}
+	for i, timing := range timings {
+		if timing.File != files[i] {
+			t.Errorf("turn %d is %s, want %s", i+1, timing.File, files[i])
+		}
+		if timing.Latency() <= 0 || timing.ResponseEnd.Before(timing.ResponseStart) {
+			t.Errorf("turn %d timing = %+v, want an answer after the turn was sent", i+1, timing)
+		}
+	}
+	if n := mediaFrames(srv); n != 2*turnFrames {
+		t.Errorf("server received %d frames, want %d", n, 2*turnFrames)
+	}
+	if n := recorder.rightBytes(); n != 2*9600 {
+		t.Errorf("recorded %d bytes of answers, want %d", n, 2*9600)
+	}
+}
+
+func TestRunScriptClock(t *testing.T) {
+	// The agent answers the 12 frames of the turn once it has been sent
+	const turnFrames = 12
+	var frames atomic.Int32
+	srv := NewTestServer(&TestServerOptions{
+		Respond: func(msg Message) []Message {
+			m, ok := msg.(*MediaInputMessage)
+			if !ok || frames.Add(1) != turnFrames {
+				return nil
+			}
+			time.Sleep(100 * time.Millisecond)
+			return []Message{NewMediaOutputFromPCM(m.StreamID, loudPCM(300*time.Millisecond, 16000))}
+		},
+	})
+	defer srv.Close()
+
+	// Sending, silence and the turn timings all follow Config.Clock
+	start := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := NewFakeClock(start)
+	session := newTestSession(t, srv, Config{Clock: clock})
+	runFakeClock(t, clock, 20*time.Millisecond)
+
+	file := writeTestWAV(t, "turn.wav", loudPCM(200*time.Millisecond, 16000), 16000)
+	timings, err := RunScript(context.Background(), session, nil, []string{file})
+	if err != nil {
+		t.Fatalf("RunScript: %v", err)
+	}
+	timing := timings[0]
+	if timing.SendStart.Before(start) || timing.SendEnd.Before(timing.SendStart) ||
+		timing.ResponseStart.Year() != 2000 || timing.Latency() < 0 || timing.ResponseEnd.Before(timing.ResponseStart) {
+		t.Errorf("turn timing = %+v, want the turn and its answer on the fake clock", timing)
+	}
+}
@@ -2,56 +2,242 @@ package main
 
 import (
 	"context"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
-	"log"
+	"fmt"
+	"io"
+	"log/slog"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/coder/websocket"
 )
 
 const (
-	pingDeadline = 20 * time.Second
+	pingDeadline      = 20 * time.Second
+	maxInvalidPayload = 256 // bytes of an invalid frame quoted in its error
 )
 
 var (
 	ErrSessionClosed = errors.New("session is closed")
+	ErrSendCancelled = errors.New("send cancelled")
+
+	// ErrMaxDurationExceeded is the cause reported by WaitClosed when a
+	// session was closed because it reached Config.MaxSessionDuration.
+	ErrMaxDurationExceeded = errors.New("max session duration exceeded")
+
+	// ErrSilenceTimeout is the cause reported by WaitClosed when a session
+	// was closed because neither side spoke for Config.MaxSilence.
+	ErrSilenceTimeout = errors.New("silence timeout")
+
+	// ErrReadFrameTimeout fails a session when a message took longer than
+	// Config.ReadFrameTimeout to arrive.
+	ErrReadFrameTimeout = errors.New("read frame timeout")
+
+	// ErrInvalidMessage ends a session with Config.StrictMessages when a
+	// frame cannot be parsed or has an unknown event type.
+	ErrInvalidMessage = errors.New("invalid message")
+)
+
+// SendError reports a failed write of an outbound message.
+type SendError struct {
+	Type MessageType
+	Size int // encoded frame length in bytes
+
+	// Written is false when the frame was rejected before reaching the
+	// connection, so the session is still in a consistent state. When true
+	// the frame may have been partially written and the connection is
+	// closed by the websocket library.
+	Written bool
+
+	Err error
+}
+
+func (e *SendError) Error() string {
+	return fmt.Sprintf("send %s (%d bytes, written: %t): %v", e.Type, e.Size, e.Written, e.Err)
+}
+
+func (e *SendError) Unwrap() error {
+	return e.Err
+}
+
+// SessionStats
+type SessionStats struct {
+	BytesSent     int64 // payload bytes of frames written by Send
+	BytesReceived int64 // payload bytes of frames read, including unparseable ones
+
+	// HandshakeDuration is the time from dialing to receiving the ack
+	HandshakeDuration time.Duration
+
+	// TerminatedBy is what ended the session, empty while it is open
+	TerminatedBy TerminationSource
+}
+
+// TerminationSource names the worker or call that terminated a session.
+// WaitClosed and Context report the error that went with it.
+type TerminationSource string
+
+const (
+	TerminatedByRead        TerminationSource = "read"         // reading failed or, with Config.StrictMessages, a frame was invalid
+	TerminatedByPing        TerminationSource = "ping"         // a ping was not answered within the ping interval
+	TerminatedByKeepalive   TerminationSource = "keepalive"    // the Config.AppKeepalive worker stopped
+	TerminatedByClose       TerminationSource = "close"        // Close was called
+	TerminatedByIdle        TerminationSource = "idle"         // Config.MaxSilence passed without speech
+	TerminatedByMaxDuration TerminationSource = "max_duration" // Config.MaxSessionDuration passed
 )
 
 // Session
 type Session interface {
 	StreamID() string
+	Config() StreamConfig
 	Send(ctx context.Context, m Message) error
+	SendJSON(ctx context.Context, raw json.RawMessage) error
+	SendSilence(ctx context.Context, d time.Duration) error
+	SendText(ctx context.Context, text string) error
+	SendEncodedMedia(ctx context.Context, payload string) error
+	Metadata() Metadata
+	SetMetadata(ctx context.Context, md Metadata) error
 	Messages() <-chan Message
+	Subscribe(types ...MessageType) <-chan Message
+	AudioOut() <-chan []byte
+	DecodeMedia(payload string) ([]byte, error)
 	Close() error
+	WaitClosed(ctx context.Context) error
+	Context() context.Context
+	Stats() SessionStats
+	Log() []LoggedMessage
+}
+
+// sessionOptions
+type sessionOptions struct {
+	encoding     *base64.Encoding
+	appKeepalive time.Duration
+	clock        Clock
+	maxMedia     int
+	pingInterval time.Duration // negative disables pings, 0 uses pingDeadline
+	frameTimeout time.Duration // 0 means no limit
+	logger       *slog.Logger
+	metrics      Metrics
+	maxDuration  time.Duration // 0 means no limit
+	maxSilence   time.Duration // 0 means no limit
+	wireLog      *wireLog      // closed with the session, may be nil
+	logMessages  bool
+	strict       bool     // unparseable frames end the session
+	metadata     Metadata // sent with the start event
+	audioOutRate int      // AudioOut resampling, 0 keeps the session rate
+	bigEndian    bool     // the application's PCM is big-endian
 }
 
 // session
 type session struct {
 	streamID string
+	configMu sync.RWMutex
+	config   StreamConfig // updated by repeated acks
+	metadata Metadata     // start metadata with SetMetadata updates, guarded by configMu
+	acked    bool         // the handshake ack was queued, owned by read
 	conn     *websocket.Conn
+	decoder  *mediaDecoder
+	clock    Clock
+	logger   *slog.Logger
+	root     *slog.Logger // Config.Logger, for the components using the session
+	metrics  Metrics
+	wireLog  *wireLog
+	messages *messageLog // nil unless Config.LogMessages is set
+	strict   bool
+	audioOut *audioOutput
+	silence  time.Duration // Config.MaxSilence, 0 when not tracked
+	deadline time.Duration // Config.ReadFrameTimeout, 0 for none
+	swap     bool          // PCM is big-endian on the application's side
 
-	cancel context.CancelFunc
+	ctx    context.Context
+	cancel context.CancelCauseFunc
 	readCh chan Message
+	subs   subscriptions
 	wg     sync.WaitGroup
+
+	done     chan struct{} // closed once the workers exited and the socket is closed
+	closeErr error
+
+	termMu       sync.Mutex
+	terminatedBy TerminationSource // the first source to terminate the session
+
+	bytesSent     atomic.Int64
+	bytesReceived atomic.Int64
+	lastSpeech    atomic.Int64  // clock time of the last speech in unix nanoseconds
+	handshake     time.Duration // set by NewSession before the session is returned
 }
 
-func newSession(streamID string, conn *websocket.Conn) (*session, error) {
-	ctx, cancel := context.WithCancel(context.Background())
+func newSession(streamID string, config StreamConfig, conn *websocket.Conn, opts sessionOptions) (*session, error) {
+	ctx, cancel := context.WithCancelCause(context.Background())
+
+	if opts.clock == nil {
+		opts.clock = realClock{}
+	}
+	if opts.logger == nil {
+		opts.logger = slog.Default()
+	}
+	if opts.metrics == nil {
+		opts.metrics = noopMetrics{}
+	}
+	if opts.pingInterval == 0 {
+		opts.pingInterval = pingDeadline
+	}
 
+	logger := opts.logger.With("component", "session")
 	s := &session{
 		streamID: streamID,
+		config:   config,
 		conn:     conn,
+		decoder:  newMediaDecoder(opts.encoding, opts.maxMedia, logger),
+		clock:    opts.clock,
+		logger:   logger,
+		root:     opts.logger,
+		metrics:  opts.metrics,
+		wireLog:  opts.wireLog,
+		strict:   opts.strict,
+		metadata: opts.metadata,
+		audioOut: &audioOutput{rate: opts.audioOutRate, bigEndian: opts.bigEndian, logger: logger},
+		silence:  opts.maxSilence,
+		deadline: opts.frameTimeout,
+		swap:     opts.bigEndian,
 
+		ctx:    ctx,
 		cancel: cancel,
 		readCh: make(chan Message, 10),
+		done:   make(chan struct{}),
 	}
 
-	s.wg.Add(2)
+	if opts.logMessages {
+		s.messages = &messageLog{}
+	}
 
+	s.wg.Add(1)
 	go s.read(ctx)
-	go s.ping(ctx)
+
+	if opts.pingInterval > 0 {
+		s.wg.Add(1)
+		go s.ping(ctx, opts.pingInterval)
+	}
+
+	if opts.appKeepalive > 0 {
+		s.wg.Add(1)
+		go s.keepalive(ctx, opts.appKeepalive)
+	}
+
+	if opts.maxDuration > 0 {
+		s.wg.Add(1)
+		go s.expire(ctx, opts.maxDuration)
+	}
+
+	if opts.maxSilence > 0 {
+		s.lastSpeech.Store(s.clock.Now().UnixNano())
+		s.wg.Add(1)
+		go s.hangUpOnSilence(ctx, opts.maxSilence)
+	}
+
+	go s.wait()
 
 	return s, nil
 }
@@ -60,80 +246,550 @@ func (s *session) StreamID() string {
 	return s.streamID
 }
 
+func (s *session) Config() StreamConfig {
+	s.configMu.RLock()
+	defer s.configMu.RUnlock()
+
+	return s.config
+}
+
 func (s *session) Send(ctx context.Context, m Message) error {
+	if media, ok := m.(*MediaInputMessage); ok && s.swapsPCM() {
+		m = swapMediaInput(media)
+	}
+
 	payload, err := json.Marshal(m)
 	if err != nil {
 		return err
 	}
 
-	log.Printf("Sending message - type: %s, len: %d", m.Type(), len(payload))
+	s.logger.Info("Sending message", "type", m.Type(), "len", len(payload))
 
-	return s.conn.Write(ctx, websocket.MessageText, payload)
+	if err := s.write(ctx, m.Type(), payload); err != nil {
+		return err
+	}
+	s.messages.add("out", m.Type(), m, nil)
+	if media, ok := m.(*MediaInputMessage); ok {
+		s.metrics.AudioSent(decodedSize(media.Media.Payload))
+		s.hear(media.Media.Payload, base64.StdEncoding.DecodeString)
+	}
+
+	return nil
+}
+
+// SendJSON sends a raw JSON object with the session's stream_id stamped in,
+// for message types that have no typed support yet.
+func (s *session) SendJSON(ctx context.Context, raw json.RawMessage) error {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return fmt.Errorf("raw message must be a JSON object: %w", err)
+	}
+	if fields == nil {
+		return errors.New("raw message must be a JSON object")
+	}
+
+	streamID, err := json.Marshal(s.streamID)
+	if err != nil {
+		return err
+	}
+	fields["stream_id"] = streamID
+
+	var typ MessageType
+	if event, ok := fields["event"]; ok {
+		if err := json.Unmarshal(event, &typ); err != nil {
+			return fmt.Errorf("invalid event field: %w", err)
+		}
+	}
+
+	payload, err := json.Marshal(fields)
+	if err != nil {
+		return err
+	}
+
+	s.logger.Info("Sending message", "type", typ, "len", len(payload))
+
+	if err := s.write(ctx, typ, payload); err != nil {
+		return err
+	}
+	s.messages.add("out", typ, nil, payload)
+
+	return nil
+}
+
+// write sends an encoded frame, wrapping failures in a SendError.
+//
+// ctx only guards the start of the write: a frame is either sent whole or
+// not at all. The websocket library closes the connection when a write is
+// interrupted, so once a frame is on its way it is bound to the session
+// instead and a late cancellation of ctx no longer affects it.
+func (s *session) write(ctx context.Context, typ MessageType, payload []byte) error {
+	// Nothing reaches the connection if the caller or session is already done
+	if err := ctx.Err(); err != nil {
+		return &SendError{Type: typ, Size: len(payload), Err: fmt.Errorf("%w: %w", ErrSendCancelled, err)}
+	}
+	if s.ctx.Err() != nil {
+		return &SendError{Type: typ, Size: len(payload), Err: context.Cause(s.ctx)}
+	}
+
+	s.wireLog.write("out", payload)
+	if err := s.conn.Write(s.ctx, websocket.MessageText, payload); err != nil {
+		return &SendError{Type: typ, Size: len(payload), Written: true, Err: err}
+	}
+	s.bytesSent.Add(int64(len(payload)))
+	s.metrics.MessageSent(typ, len(payload))
+
+	return nil
+}
+
+// SendSilence streams d of format-correct silence with real-time pacing.
+func (s *session) SendSilence(ctx context.Context, d time.Duration) error {
+	cfg := s.Config()
+	silence := cfg.Silence(d)
+	chunkSize := cfg.BytesForDuration(chunkDuration)
+
+	return streamChunks(ctx, s.clock, silence, chunkSize, func(chunk []byte) error {
+		return s.Send(ctx, NewMediaInputFromPCM(s.streamID, chunk))
+	})
+}
+
+// SendEncodedMedia sends audio that is already base64 encoded, e.g. proxied
+// from another source, in a media_input message as is. The payload must be
+// padded standard base64 of whole samples in the session's format, which is
+// checked without decoding it; otherwise ErrInvalidMedia is returned.
+func (s *session) SendEncodedMedia(ctx context.Context, payload string) error {
+	if err := validateEncodedMedia(payload, s.Config()); err != nil {
+		return err
+	}
+
+	return s.Send(ctx, &MediaInputMessage{
+		Event:    MessageTypeMediaInput,
+		StreamID: s.streamID,
+		Media:    Media{Payload: payload},
+	})
+}
+
+// Metadata returns a copy of the session metadata: what was sent with the
+// start event, merged with every update made by SetMetadata.
+func (s *session) Metadata() Metadata {
+	s.configMu.RLock()
+	defer s.configMu.RUnlock()
+
+	return s.metadata.merge(nil)
+}
+
+// SetMetadata sends md, e.g. context learned once the caller authenticated,
+// as a custom event whose metadata has "type": "metadata_update" and the
+// changed entries under "metadata", and merges it into Metadata once sent.
+// The protocol has no event to change metadata after start, so only agents
+// that look for this event act on it.
+func (s *session) SetMetadata(ctx context.Context, md Metadata) error {
+	if err := md.Validate(); err != nil {
+		return err
+	}
+
+	err := s.Send(ctx, &CustomMessage{
+		Event:    MessageTypeCustom,
+		StreamID: s.streamID,
+		Metadata: Metadata{"type": metadataUpdateType, "metadata": md},
+	})
+	if err != nil {
+		return err
+	}
+
+	s.configMu.Lock()
+	defer s.configMu.Unlock()
+
+	s.metadata = s.metadata.merge(md)
+	return nil
+}
+
+// SendText sends typed user input as a custom event whose metadata has
+// "type": "text_input" and the text under "text". The protocol has no text
+// input event, so only agents that look for this one act on it.
+func (s *session) SendText(ctx context.Context, text string) error {
+	return s.Send(ctx, &CustomMessage{
+		Event:    MessageTypeCustom,
+		StreamID: s.streamID,
+		Metadata: Metadata{"type": textInputType, "text": text},
+	})
 }
 
 func (s *session) Messages() <-chan Message {
 	return s.readCh
 }
 
+// Subscribe returns a channel of the received messages of the given types,
+// or of every type if none are given. Each subscriber gets its own copy and
+// its channel is closed when the session terminates. Messages no subscriber
+// wants are still delivered on Messages, which must keep being read.
+func (s *session) Subscribe(types ...MessageType) <-chan Message {
+	return s.subs.add(types)
+}
+
+// AudioOut returns a channel of the agent's audio as 16-bit PCM in
+// Config.PCMByteOrder, little-endian by default, decoded from base64 and the
+// session's encoding and resampled to Config.AudioOutSampleRate if set. The
+// first call subscribes to media_output, so those messages no longer reach
+// Messages, while audio received before it, such as a greeting sent with
+// the ack, stays on Messages. The channel must keep being read and is
+// closed when the session terminates.
+func (s *session) AudioOut() <-chan []byte {
+	return s.audioOut.get(s)
+}
+
+// DecodeMedia decodes a media_output payload with the configured base64
+// alphabet, falling back to the other alphabets if it fails. Payloads over
+// Config.MaxMediaBytes fail with ErrMediaTooLarge. PCM is returned in
+// Config.PCMByteOrder.
+func (s *session) DecodeMedia(payload string) ([]byte, error) {
+	data, err := s.decoder.Decode(payload)
+	if err != nil {
+		return nil, err
+	}
+	if s.swapsPCM() {
+		data = swapPCM16(data)
+	}
+
+	s.logger.Debug("Decoded media", "bytes", len(data), "duration", s.Config().Duration(len(data)))
+
+	return data, nil
+}
+
+func (s *session) Stats() SessionStats {
+	return SessionStats{
+		BytesSent:     s.bytesSent.Load(),
+		BytesReceived: s.bytesReceived.Load(),
+
+		HandshakeDuration: s.handshake,
+		TerminatedBy:      s.terminationSource(),
+	}
+}
+
+// Log returns the messages sent and received so far, oldest first, when
+// Config.LogMessages is set, and nil otherwise.
+func (s *session) Log() []LoggedMessage {
+	return s.messages.snapshot()
+}
+
 func (s *session) Close() error {
-	s.cancel()
-	s.wg.Wait()
+	s.terminate(TerminatedByClose, ErrSessionClosed)
+	<-s.done
 
-	return s.conn.Close(websocket.StatusNormalClosure, "")
+	return s.closeErr
+}
+
+// WaitClosed blocks until the session has terminated, either through Close or
+// because the connection failed, and returns the cause. ErrSessionClosed is
+// returned after Close. If ctx expires first its error is returned instead.
+func (s *session) WaitClosed(ctx context.Context) error {
+	select {
+	case <-s.done:
+		return context.Cause(s.ctx)
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Context returns a context that is cancelled as soon as the session starts
+// terminating, for deriving work that should not outlive it. context.Cause
+// reports why, like WaitClosed, which also waits for the socket to close.
+func (s *session) Context() context.Context {
+	return s.ctx
+}
+
+// terminate cancels the session with cause and records source, unless the
+// session is already terminating, in which case the first source and cause
+// stand.
+func (s *session) terminate(source TerminationSource, cause error) {
+	s.termMu.Lock()
+	defer s.termMu.Unlock()
+
+	if s.ctx.Err() != nil {
+		return
+	}
+	s.terminatedBy = source
+	s.cancel(cause)
+	s.logger.Info("Session terminated", "source", source, "cause", context.Cause(s.ctx))
+}
+
+// terminationSource returns what terminated the session, or "" if it is open.
+func (s *session) terminationSource() TerminationSource {
+	s.termMu.Lock()
+	defer s.termMu.Unlock()
+
+	return s.terminatedBy
+}
+
+// wait closes the socket once all workers have exited.
+func (s *session) wait() {
+	s.wg.Wait()
+	s.closeErr = s.conn.Close(websocket.StatusNormalClosure, "")
+	s.wireLog.Close()
+	close(s.done)
 }
 
 func (s *session) read(ctx context.Context) {
 	defer s.wg.Done()
-	defer s.cancel()
+	defer s.terminate(TerminatedByRead, nil)
+	defer s.subs.closeAll()
 
 	for {
 		select {
 		case <-ctx.Done():
-			log.Println("Closing the read worker")
+			s.logger.Info("Closing the read worker")
 			return
 		default:
 		}
 
-		_, payload, err := s.conn.Read(ctx)
+		payload, err := s.readFrame(ctx)
 		if err != nil {
-			log.Printf("Error while reading message: %v", err)
+			s.logger.Error("Error while reading message", "err", err)
+			s.terminate(TerminatedByRead, err)
 			return
 		}
+		s.bytesReceived.Add(int64(len(payload)))
+		s.wireLog.write("in", payload)
 
 		m, err := UnmarshalMessage(payload)
 		if err != nil {
-			log.Printf("Error while unmarshaling message: %v", err)
+			s.logger.Error("Error while unmarshaling message", "err", err)
+			if s.strict {
+				s.terminate(TerminatedByRead, fmt.Errorf("%w: %w: %s", ErrInvalidMessage, err, truncate(payload, maxInvalidPayload)))
+				return
+			}
 			continue
 		}
 
-		log.Printf("Received message - type: %s", m.Type())
+		s.logger.Info("Received message", "type", m.Type())
+
+		s.metrics.MessageReceived(m.Type(), len(payload))
+		s.messages.add("in", m.Type(), m, nil)
+
+		// Only the handshake ack is for the caller, later ones just
+		// restate or update the stream config. Acks are applied here, in
+		// the order they arrive, so a duplicate always wins.
+		if ack, ok := m.(*AckMessage); ok {
+			if s.acked {
+				s.reack(ack)
+				continue
+			}
+			s.acked = true
+			if ack.Err() == nil && ack.Config.Rate() != 0 {
+				s.confirm(ack.Config)
+			}
+		}
+		if media, ok := m.(*MediaOutputMessage); ok {
+			s.metrics.AudioReceived(decodedSize(media.Media.Payload))
+			s.hear(media.Media.Payload, s.decoder.Decode)
+		}
+
+		if s.subs.route(ctx, m) {
+			continue
+		}
 
 		select {
 		case s.readCh <- m:
-			log.Printf("Queued message - type: %s", m.Type())
+			s.logger.Info("Queued message", "type", m.Type())
 		case <-ctx.Done():
-			log.Println("Closing the read worker")
+			s.logger.Info("Closing the read worker")
 			return
 		}
 	}
 }
 
-func (s *session) ping(ctx context.Context) {
-	ticker := time.NewTicker(pingDeadline)
+// readFrame reads the next message. With Config.ReadFrameTimeout, waiting
+// for it is unbounded but once its header has arrived the rest must follow
+// within the timeout, otherwise the read is cancelled, which closes the
+// connection.
+func (s *session) readFrame(ctx context.Context) ([]byte, error) {
+	if s.deadline <= 0 {
+		_, payload, err := s.conn.Read(ctx)
+		return payload, err
+	}
+
+	frameCtx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	_, r, err := s.conn.Reader(frameCtx)
+	if err != nil {
+		return nil, err
+	}
+
+	go func() {
+		select {
+		case <-s.clock.After(s.deadline):
+			cancel(ErrReadFrameTimeout)
+		case <-frameCtx.Done():
+		}
+	}()
+
+	payload, err := io.ReadAll(r)
+	if err != nil && errors.Is(context.Cause(frameCtx), ErrReadFrameTimeout) {
+		return nil, fmt.Errorf("%w: message not complete after %s: %w", ErrReadFrameTimeout, s.deadline, err)
+	}
+	return payload, err
+}
+
+// reack applies a duplicate ack to the stream config.
+func (s *session) reack(ack *AckMessage) {
+	if err := ack.Err(); err != nil {
+		s.logger.Warn("Ignoring duplicate ack with error", "err", err)
+		return
+	}
+	if ack.Config.Rate() == 0 {
+		s.logger.Info("Ignoring duplicate ack")
+		return
+	}
+
+	if s.confirm(ack.Config) {
+		s.logger.Info("Duplicate ack updated the config",
+			"input_format", ack.Config.InputFormat, "sample_rate", ack.Config.Rate())
+	}
+}
+
+// confirm replaces the stream config with the one the server acked, which
+// sending, pacing and conversion follow from then on. It reports whether
+// the format changed.
+func (s *session) confirm(cfg StreamConfig) bool {
+	s.configMu.Lock()
+	defer s.configMu.Unlock()
+
+	changed := !cfg.SameFormat(s.config)
+	s.config = cfg
+	return changed
+}
+
+// ping sends a ping every interval and terminates the session when one is
+// not answered before the next is due.
+func (s *session) ping(ctx context.Context, interval time.Duration) {
+	ticker := s.clock.NewTicker(interval)
 	defer ticker.Stop()
 
 	defer s.wg.Done()
-	defer s.cancel()
 
 	for {
 		select {
-		case <-ticker.C:
-			if err := s.conn.Ping(ctx); err != nil {
-				log.Printf("Error while sending ping: %v", err)
+		case <-ticker.C():
+			start := time.Now()
+			pingCtx, cancel := context.WithTimeout(ctx, interval)
+			err := s.conn.Ping(pingCtx)
+			cancel()
+			if err != nil {
+				if ctx.Err() != nil {
+					s.logger.Info("Closing the ping worker")
+					return
+				}
+				s.logger.Error("Error while sending ping", "err", err)
+				s.terminate(TerminatedByPing, fmt.Errorf("ping error: %w", err))
+				return
 			}
+			s.metrics.PingRTT(time.Since(start))
 		case <-ctx.Done():
-			log.Println("Closing the ping worker")
+			s.logger.Info("Closing the ping worker")
 			return
 		}
 	}
 }
+
+// keepalive sends an application-level keepalive event every interval.
+func (s *session) keepalive(ctx context.Context, interval time.Duration) {
+	ticker := s.clock.NewTicker(interval)
+	defer ticker.Stop()
+
+	defer s.wg.Done()
+	defer s.terminate(TerminatedByKeepalive, nil)
+
+	for {
+		select {
+		case <-ticker.C():
+			msg := &CustomMessage{
+				Event:    MessageTypeCustom,
+				StreamID: s.streamID,
+				Metadata: Metadata{"type": "keepalive"},
+			}
+			if err := s.Send(ctx, msg); err != nil {
+				s.logger.Error("Error while sending keepalive", "err", err)
+			}
+		case <-ctx.Done():
+			s.logger.Info("Closing the keepalive worker")
+			return
+		}
+	}
+}
+
+// expire closes the session once it has been open for d.
+func (s *session) expire(ctx context.Context, d time.Duration) {
+	defer s.wg.Done()
+
+	select {
+	case <-s.clock.After(d):
+		s.logger.Info("Session reached its maximum duration, closing", "max_duration", d)
+		s.terminate(TerminatedByMaxDuration, ErrMaxDurationExceeded)
+	case <-ctx.Done():
+	}
+}
+
+// swapsPCM reports whether audio is converted between the little-endian PCM
+// of the protocol and big-endian PCM on the application's side.
+func (s *session) swapsPCM() bool {
+	return s.swap && isPCM16(s.Config().InputFormat)
+}
+
+// swapMediaInput returns m with its big-endian PCM converted to the
+// protocol's little-endian. A payload that is not valid base64 is left as
+// is for the server to reject.
+func swapMediaInput(m *MediaInputMessage) *MediaInputMessage {
+	data, err := base64.StdEncoding.DecodeString(m.Media.Payload)
+	if err != nil {
+		return m
+	}
+
+	swapped := *m
+	swapped.Media.Payload = base64.StdEncoding.EncodeToString(swapPCM16(data))
+	return &swapped
+}
+
+// hear records the time of audio in a media payload, sent or received, if
+// it is speech and Config.MaxSilence is set.
+func (s *session) hear(payload string, decode func(string) ([]byte, error)) {
+	if s.silence <= 0 {
+		return
+	}
+
+	data, err := decode(payload)
+	if err != nil {
+		return
+	}
+	if isSpeech(decodeSamples(s.Config().InputFormat, data)) {
+		s.lastSpeech.Store(s.clock.Now().UnixNano())
+	}
+}
+
+// hangUpOnSilence closes the session once neither side has spoken for d.
+func (s *session) hangUpOnSilence(ctx context.Context, d time.Duration) {
+	defer s.wg.Done()
+
+	for {
+		last := time.Unix(0, s.lastSpeech.Load())
+		remaining := d - s.clock.Now().Sub(last)
+		if remaining <= 0 {
+			s.logger.Info("No speech for the maximum silence, closing", "max_silence", d)
+			s.terminate(TerminatedByIdle, ErrSilenceTimeout)
+			return
+		}
+
+		select {
+		case <-s.clock.After(remaining):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// truncate returns data as a string of at most n bytes, marking cut input.
+func truncate(data []byte, n int) string {
+	if len(data) <= n {
+		return string(data)
+	}
+	return string(data[:n]) + "..."
+}
@@ -0,0 +1,882 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/coder/websocket"
+)
+
+func TestSendCancelled(t *testing.T) {
+	texts := make(chan string, 2)
+	srv := NewTestServer(&TestServerOptions{
+		Respond: func(msg Message) []Message {
+			if custom, ok := msg.(*CustomMessage); ok {
+				texts <- custom.Metadata["text"].(string)
+			}
+			return nil
+		},
+	})
+	defer srv.Close()
+
+	session := newTestSession(t, srv, Config{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := session.SendText(ctx, "dropped")
+	if !errors.Is(err, ErrSendCancelled) {
+		t.Fatalf("SendText with a cancelled context = %v, want ErrSendCancelled", err)
+	}
+	var sendErr *SendError
+	if !errors.As(err, &sendErr) || sendErr.Written {
+		t.Errorf("SendText error = %#v, want an unwritten SendError", err)
+	}
+
+	// The cancelled send leaves the session usable
+	if session.Context().Err() != nil {
+		t.Fatalf("session terminated by a cancelled send: %v", context.Cause(session.Context()))
+	}
+	if err := session.SendText(context.Background(), "sent"); err != nil {
+		t.Fatalf("SendText after a cancelled send: %v", err)
+	}
+
+	select {
+	case text := <-texts:
+		if text != "sent" {
+			t.Errorf("server received text %q, want %q", text, "sent")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("server received no text")
+	}
+}
+
+// advanceUntilClosed advances clock by step until session terminates.
+func advanceUntilClosed(t *testing.T, clock *FakeClock, session Session, step time.Duration) {
+	t.Helper()
+
+	for i := 0; i < 100; i++ {
+		clock.Advance(step)
+		select {
+		case <-session.Context().Done():
+			return
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	t.Fatal("session still open")
+}
+
+func TestSessionContext(t *testing.T) {
+	srv := NewTestServer(nil)
+	defer srv.Close()
+
+	session := newTestSession(t, srv, Config{})
+	child, cancel := context.WithCancel(session.Context())
+	defer cancel()
+	if err := child.Err(); err != nil {
+		t.Fatalf("context of an open session = %v, want it live", err)
+	}
+
+	session.Close()
+	select {
+	case <-child.Done():
+		if cause := context.Cause(child); !errors.Is(cause, ErrSessionClosed) {
+			t.Errorf("context.Cause = %v, want ErrSessionClosed", cause)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("derived context not cancelled after Close")
+	}
+}
+
+func TestTerminationSource(t *testing.T) {
+	tests := []struct {
+		name      string
+		cfg       Config
+		opts      *TestServerOptions
+		terminate func(t *testing.T, cfg Config, session Session)
+		want      TerminationSource
+		cause     error
+	}{
+		{
+			name: "close",
+			cfg:  Config{AppKeepalive: time.Hour},
+			terminate: func(t *testing.T, cfg Config, session Session) {
+				session.Close()
+			},
+			want:  TerminatedByClose,
+			cause: ErrSessionClosed,
+		},
+		{
+			name: "read",
+			opts: &TestServerOptions{Drop: func(msg Message) bool {
+				_, ok := msg.(*CustomMessage)
+				return ok
+			}},
+			terminate: func(t *testing.T, cfg Config, session Session) {
+				session.SendText(context.Background(), "hang up")
+			},
+			want: TerminatedByRead,
+		},
+		{
+			// The server stops reading, so pings go unanswered
+			name: "ping",
+			cfg:  Config{PingInterval: 50 * time.Millisecond},
+			opts: &TestServerOptions{Respond: func(msg Message) []Message {
+				time.Sleep(time.Second)
+				return nil
+			}},
+			terminate: func(t *testing.T, cfg Config, session Session) {
+				session.SendText(context.Background(), "stall")
+			},
+			want: TerminatedByPing,
+		},
+		{
+			name: "idle",
+			cfg:  Config{MaxSilence: 30 * time.Second, Clock: NewFakeClock(time.Unix(0, 0))},
+			terminate: func(t *testing.T, cfg Config, session Session) {
+				advanceUntilClosed(t, cfg.Clock.(*FakeClock), session, 10*time.Second)
+			},
+			want:  TerminatedByIdle,
+			cause: ErrSilenceTimeout,
+		},
+		{
+			name: "max_duration",
+			cfg:  Config{MaxSessionDuration: time.Minute, Clock: NewFakeClock(time.Unix(0, 0))},
+			terminate: func(t *testing.T, cfg Config, session Session) {
+				advanceUntilClosed(t, cfg.Clock.(*FakeClock), session, 20*time.Second)
+			},
+			want:  TerminatedByMaxDuration,
+			cause: ErrMaxDurationExceeded,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := NewTestServer(tt.opts)
+			defer srv.Close()
+
+			session := newTestSession(t, srv, tt.cfg)
+			if got := session.Stats().TerminatedBy; got != "" {
+				t.Fatalf("TerminatedBy of an open session = %q, want empty", got)
+			}
+
+			tt.terminate(t, tt.cfg, session)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			err := session.WaitClosed(ctx)
+			if ctx.Err() != nil {
+				t.Fatal("session did not terminate")
+			}
+			if tt.cause != nil && !errors.Is(err, tt.cause) {
+				t.Errorf("WaitClosed = %v, want %v", err, tt.cause)
+			}
+			if got := session.Stats().TerminatedBy; got != tt.want {
+				t.Errorf("TerminatedBy = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSendSilence(t *testing.T) {
+	tests := []struct {
+		format InputFormat
+		frame  int  // bytes per 100ms frame
+		value  byte // encoded silence
+	}{
+		{InputFormatPCM16000, 3200, 0x00},
+		{InputFormatMulaw8000, 800, 0xff},
+		{InputFormatAlaw8000, 800, 0xd5},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.format), func(t *testing.T) {
+			srv := NewTestServer(nil)
+			defer srv.Close()
+
+			session := newTestSession(t, srv, Config{InputFormat: tt.format})
+			if err := session.SendSilence(context.Background(), 300*time.Millisecond); err != nil {
+				t.Fatalf("SendSilence: %v", err)
+			}
+
+			waitForFrames(t, srv, 3)
+			if n := mediaFrames(srv); n != 3 {
+				t.Errorf("server received %d frames, want 3", n)
+			}
+			for _, msg := range srv.Received() {
+				m, ok := msg.(*MediaInputMessage)
+				if !ok {
+					continue
+				}
+				data, err := base64.StdEncoding.DecodeString(m.Media.Payload)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(data, bytes.Repeat([]byte{tt.value}, tt.frame)) {
+					t.Errorf("frame of %d bytes is not %d bytes of silence", len(data), tt.frame)
+				}
+			}
+		})
+	}
+}
+
+func TestSendSilenceEndsTurn(t *testing.T) {
+	// An agent that starts answering at the first silent frame, the closest
+	// thing to a flush of its output
+	srv := NewTestServer(&TestServerOptions{
+		Respond: func(msg Message) []Message {
+			m, ok := msg.(*MediaInputMessage)
+			if !ok {
+				return nil
+			}
+			data, err := base64.StdEncoding.DecodeString(m.Media.Payload)
+			if err != nil || !bytes.Equal(data, make([]byte, len(data))) {
+				return nil
+			}
+			return []Message{NewMediaOutputFromPCM(m.StreamID, loudPCM(100*time.Millisecond, 16000))}
+		},
+	})
+	defer srv.Close()
+
+	session := newTestSession(t, srv, Config{})
+	if err := session.Send(context.Background(), NewMediaInputFromPCM(session.StreamID(), loudPCM(100*time.Millisecond, 16000))); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if err := session.SendSilence(context.Background(), time.Second); err != nil {
+		t.Fatalf("SendSilence: %v", err)
+	}
+
+	select {
+	case m := <-session.Messages():
+		if m.Type() != MessageTypeMediaOutput {
+			t.Fatalf("received %s, want the agent's answer", m.Type())
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the agent did not answer after the silence")
+	}
+	for _, msg := range srv.Received() {
+		if m, ok := msg.(*MediaInputMessage); ok && m.StreamID != session.StreamID() {
+			t.Errorf("media_input on stream %q, want %q", m.StreamID, session.StreamID())
+		}
+	}
+}
+
+func TestSendAfterClose(t *testing.T) {
+	srv := NewTestServer(nil)
+	defer srv.Close()
+
+	session := newTestSession(t, srv, Config{})
+	session.Close()
+
+	err := session.SendText(context.Background(), "too late")
+	var sendErr *SendError
+	if !errors.As(err, &sendErr) {
+		t.Fatalf("SendText after Close = %v, want a SendError", err)
+	}
+	if sendErr.Type != MessageTypeCustom || sendErr.Size == 0 || sendErr.Written {
+		t.Errorf("SendError = %+v, want an unwritten custom event with its size", sendErr)
+	}
+	if !errors.Is(err, ErrSessionClosed) {
+		t.Errorf("SendText after Close = %v, want it to match ErrSessionClosed", err)
+	}
+}
+
+func TestSendJSON(t *testing.T) {
+	received := make(chan *DTMFMessage, 1)
+	srv := NewTestServer(&TestServerOptions{
+		Respond: func(msg Message) []Message {
+			if m, ok := msg.(*DTMFMessage); ok {
+				received <- m
+			}
+			return nil
+		},
+	})
+	defer srv.Close()
+
+	session := newTestSession(t, srv, Config{})
+	if err := session.SendJSON(context.Background(), json.RawMessage(`{"event": "dtmf", "dtmf": "5", "stream_id": "other"}`)); err != nil {
+		t.Fatalf("SendJSON: %v", err)
+	}
+
+	select {
+	case m := <-received:
+		if m.DTMF != "5" || m.StreamID != session.StreamID() {
+			t.Errorf("server received digit %q on stream %q, want 5 on %q", m.DTMF, m.StreamID, session.StreamID())
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("server received no dtmf event")
+	}
+
+	for _, raw := range []string{`[1, 2]`, `null`, `{"event": `} {
+		if err := session.SendJSON(context.Background(), json.RawMessage(raw)); err == nil {
+			t.Errorf("SendJSON(%s) succeeded, want an error", raw)
+		}
+	}
+}
+
+func TestWaitClosed(t *testing.T) {
+	srv := NewTestServer(nil)
+	defer srv.Close()
+
+	session := newTestSession(t, srv, Config{})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := session.WaitClosed(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("WaitClosed of an open session = %v, want the context's error", err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- session.WaitClosed(context.Background())
+	}()
+	session.Close()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, ErrSessionClosed) {
+			t.Errorf("WaitClosed = %v, want ErrSessionClosed", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("WaitClosed did not return after Close")
+	}
+}
+
+func TestAppKeepalive(t *testing.T) {
+	keepalives := make(chan *CustomMessage, 10)
+	srv := NewTestServer(&TestServerOptions{
+		Respond: func(msg Message) []Message {
+			if m, ok := msg.(*CustomMessage); ok && m.Metadata["type"] == "keepalive" {
+				keepalives <- m
+			}
+			return nil
+		},
+	})
+	defer srv.Close()
+
+	clock := NewFakeClock(time.Unix(0, 0))
+	session := newTestSession(t, srv, Config{AppKeepalive: 30 * time.Second, Clock: clock})
+
+	select {
+	case <-keepalives:
+		t.Fatal("keepalive sent before the interval passed")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	for i := 0; i < 2; i++ {
+		clock.Advance(30 * time.Second)
+		select {
+		case m := <-keepalives:
+			if m.StreamID != session.StreamID() {
+				t.Errorf("keepalive on stream %q, want %q", m.StreamID, session.StreamID())
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no keepalive %d", i+1)
+		}
+	}
+}
+
+func TestSessionByteCounters(t *testing.T) {
+	srv := NewTestServer(&TestServerOptions{
+		Respond: func(msg Message) []Message {
+			if m, ok := msg.(*CustomMessage); ok {
+				return []Message{NewMediaOutputFromPCM(m.StreamID, make([]byte, 3200))}
+			}
+			return nil
+		},
+	})
+	defer srv.Close()
+
+	session := newTestSession(t, srv, Config{})
+	before := session.Stats()
+
+	msg := &CustomMessage{Event: MessageTypeCustom, StreamID: session.StreamID(), Metadata: Metadata{"text": "hello"}}
+	if err := session.Send(context.Background(), msg); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	select {
+	case <-session.Messages():
+	case <-time.After(5 * time.Second):
+		t.Fatal("no reply")
+	}
+
+	sent, err := json.Marshal(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	received, err := json.Marshal(NewMediaOutputFromPCM(session.StreamID(), make([]byte, 3200)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	after := session.Stats()
+	if n := after.BytesSent - before.BytesSent; n != int64(len(sent)) {
+		t.Errorf("BytesSent grew by %d, want the %d bytes of the frame", n, len(sent))
+	}
+	if n := after.BytesReceived - before.BytesReceived; n != int64(len(received)) {
+		t.Errorf("BytesReceived grew by %d, want the %d bytes of the frame", n, len(received))
+	}
+}
+
+// pingMetrics reports each answered websocket ping.
+type pingMetrics struct {
+	noopMetrics
+	pings chan time.Duration
+}
+
+func (m pingMetrics) PingRTT(d time.Duration) {
+	select {
+	case m.pings <- d:
+	default:
+	}
+}
+
+func TestPingUsesClock(t *testing.T) {
+	srv := NewTestServer(nil)
+	defer srv.Close()
+
+	clock := NewFakeClock(time.Unix(0, 0))
+	metrics := pingMetrics{pings: make(chan time.Duration, 1)}
+	newTestSession(t, srv, Config{PingInterval: 30 * time.Second, Clock: clock, Metrics: metrics})
+
+	// The ping worker may register its ticker after the session is returned,
+	// so advance in steps until the ping
+	for elapsed := time.Second; ; elapsed += time.Second {
+		clock.Advance(time.Second)
+		select {
+		case <-metrics.pings:
+			if elapsed < 30*time.Second {
+				t.Fatalf("ping sent after %s, before the interval passed", elapsed)
+			}
+			return
+		case <-time.After(10 * time.Millisecond):
+		}
+		if elapsed > time.Minute {
+			t.Fatal("no ping after advancing the clock past the interval")
+		}
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	srv := NewTestServer(&TestServerOptions{
+		Respond: func(msg Message) []Message {
+			m, ok := msg.(*CustomMessage)
+			if !ok {
+				return nil
+			}
+			return []Message{
+				NewMediaOutputFromPCM(m.StreamID, make([]byte, 320)),
+				&ClearMessage{Event: MessageTypeClear, StreamID: m.StreamID},
+				&CustomMessage{Event: MessageTypeCustom, StreamID: m.StreamID, Metadata: Metadata{"type": "note"}},
+			}
+		},
+	})
+	defer srv.Close()
+
+	client := newTestClient(t, srv, Config{})
+	session, err := client.NewSession(context.Background(), "agent", nil)
+	if err != nil {
+		t.Fatalf("NewSession: %v", err)
+	}
+	defer session.Close()
+
+	media := session.Subscribe(MessageTypeMediaOutput)
+	control := session.Subscribe(MessageTypeClear, MessageTypeMediaOutput)
+	if err := session.SendText(context.Background(), "hello"); err != nil {
+		t.Fatal(err)
+	}
+
+	next := func(ch <-chan Message) MessageType {
+		t.Helper()
+		select {
+		case m := <-ch:
+			return m.Type()
+		case <-time.After(5 * time.Second):
+			t.Fatal("no message")
+			return ""
+		}
+	}
+	if typ := next(media); typ != MessageTypeMediaOutput {
+		t.Errorf("media subscriber got %s first, want media_output", typ)
+	}
+	if typ := next(control); typ != MessageTypeMediaOutput {
+		t.Errorf("second subscriber got %s first, want its own copy of media_output", typ)
+	}
+	if typ := next(control); typ != MessageTypeClear {
+		t.Errorf("second subscriber got %s next, want clear", typ)
+	}
+	// Messages no one subscribed to stay on Messages
+	if typ := next(session.Messages()); typ != MessageTypeCustom {
+		t.Errorf("Messages got %s, want the custom event", typ)
+	}
+
+	session.Close()
+	for _, ch := range []<-chan Message{media, control} {
+		if m, ok := <-ch; ok {
+			t.Errorf("subscriber got %s after Close, want its channel closed", m.Type())
+		}
+	}
+}
+
+func TestPingDisabled(t *testing.T) {
+	srv := NewTestServer(nil)
+	defer srv.Close()
+
+	clock := NewFakeClock(time.Unix(0, 0))
+	metrics := pingMetrics{pings: make(chan time.Duration, 1)}
+	session, err := newTestClient(t, srv, Config{PingInterval: -1, Clock: clock, Metrics: metrics}).
+		NewSession(context.Background(), "agent", nil)
+	if err != nil {
+		t.Fatalf("NewSession: %v", err)
+	}
+
+	for i := 0; i < 10; i++ {
+		clock.Advance(pingDeadline)
+		time.Sleep(10 * time.Millisecond)
+	}
+	select {
+	case <-metrics.pings:
+		t.Fatal("ping sent with pings disabled")
+	default:
+	}
+
+	if err := session.Close(); err != nil {
+		t.Errorf("Close = %v, want a clean close", err)
+	}
+	if source := session.Stats().TerminatedBy; source != TerminatedByClose {
+		t.Errorf("session terminated by %q, want %q", source, TerminatedByClose)
+	}
+}
+
+func TestMaxSessionDuration(t *testing.T) {
+	srv := NewTestServer(nil)
+	defer srv.Close()
+
+	var dials atomic.Int32
+	serve := srv.server.Config.Handler
+	srv.server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		dials.Add(1)
+		serve.ServeHTTP(w, r)
+	})
+
+	client := newTestClient(t, srv, Config{MaxSessionDuration: 100 * time.Millisecond, Reconnect: &ReconnectConfig{}})
+	start := time.Now()
+	plain, err := client.NewSession(context.Background(), "agent", nil)
+	if err != nil {
+		t.Fatalf("NewSession: %v", err)
+	}
+	reconnecting, err := client.NewReconnectingSession(context.Background(), "agent", nil)
+	if err != nil {
+		t.Fatalf("NewReconnectingSession: %v", err)
+	}
+
+	// Both close themselves; the cap is not a dropped connection to replace
+	for _, session := range []Session{plain, reconnecting} {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		err := session.WaitClosed(ctx)
+		cancel()
+		if !errors.Is(err, ErrMaxDurationExceeded) {
+			t.Errorf("WaitClosed = %v, want ErrMaxDurationExceeded", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("sessions closed after %s, before the cap", elapsed)
+	}
+	if n := dials.Load(); n != 2 {
+		t.Errorf("server was dialled %d times, want 2 without reconnects", n)
+	}
+}
+
+func TestDuplicateAck(t *testing.T) {
+	// The server acks again with another format, then carries on
+	srv := NewTestServer(&TestServerOptions{
+		OnStart: func(start *StartMessage) []Message {
+			return []Message{
+				&AckMessage{Event: MessageTypeAck, StreamID: start.StreamID, Config: StreamConfig{InputFormat: InputFormatPCM24000}},
+				&ClearMessage{Event: MessageTypeClear, StreamID: start.StreamID},
+			}
+		},
+	})
+	defer srv.Close()
+
+	session := newTestSession(t, srv, Config{})
+	select {
+	case m := <-session.Messages():
+		if m.Type() != MessageTypeClear {
+			t.Errorf("first message is %s, want clear with the duplicate ack consumed", m.Type())
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no message")
+	}
+	if rate := session.Config().Rate(); rate != 24000 {
+		t.Errorf("session rate = %d, want the 24000 of the latest ack", rate)
+	}
+}
+
+func TestMessageLog(t *testing.T) {
+	srv := NewTestServer(&TestServerOptions{EchoMedia: true})
+	defer srv.Close()
+
+	if log := newTestSession(t, srv, Config{}).Log(); log != nil {
+		t.Errorf("Log() = %d entries without LogMessages, want nil", len(log))
+	}
+
+	session := newTestSession(t, srv, Config{LogMessages: true})
+	for i := 0; i < 2; i++ {
+		if err := session.Send(context.Background(), NewMediaInputFromPCM(session.StreamID(), make([]byte, 320))); err != nil {
+			t.Fatalf("Send: %v", err)
+		}
+		select {
+		case <-session.Messages():
+		case <-time.After(5 * time.Second):
+			t.Fatal("no echo")
+		}
+	}
+	if err := session.SendJSON(context.Background(), json.RawMessage(`{"event": "dtmf", "dtmf": "1"}`)); err != nil {
+		t.Fatalf("SendJSON: %v", err)
+	}
+
+	want := []struct {
+		direction string
+		typ       MessageType
+	}{
+		{"out", MessageTypeStart},
+		{"in", MessageTypeAck},
+		{"out", MessageTypeMediaInput},
+		{"in", MessageTypeMediaOutput},
+		{"out", MessageTypeMediaInput},
+		{"in", MessageTypeMediaOutput},
+		{"out", MessageTypeDTMF},
+	}
+	log := session.Log()
+	if len(log) != len(want) {
+		t.Fatalf("Log() has %d entries, want %d", len(log), len(want))
+	}
+	for i, entry := range log {
+		if entry.Direction != want[i].direction || entry.Type != want[i].typ {
+			t.Errorf("entry %d = %s %s, want %s %s", i, entry.Direction, entry.Type, want[i].direction, want[i].typ)
+		}
+		if i > 0 && entry.Time.Before(log[i-1].Time) {
+			t.Errorf("entry %d logged at %s, before the previous one", i, entry.Time)
+		}
+	}
+	if start, ok := log[0].Message.(*StartMessage); !ok || start.StreamID != session.StreamID() {
+		t.Errorf("first entry holds %#v, want the start event", log[0].Message)
+	}
+	// Raw messages keep their payload, stamped with the stream ID
+	if raw := log[len(log)-1]; raw.Message != nil || !bytes.Contains(raw.Raw, []byte(session.StreamID())) {
+		t.Errorf("raw entry = %#v, %s; want the sent JSON only", raw.Message, raw.Raw)
+	}
+}
+
+func TestStrictMessages(t *testing.T) {
+	for _, frame := range []string{`{"event": "media_output", "media": `, `{"event": "bogus", "stream_id": "s"}`} {
+		for _, strict := range []bool{true, false} {
+			srv := NewTestServer(nil)
+			defer srv.Close()
+
+			// The server follows the ack with the frame and a clear
+			srv.server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				conn, err := websocket.Accept(w, r, nil)
+				if err != nil {
+					return
+				}
+				defer conn.CloseNow()
+
+				_, data, err := conn.Read(r.Context())
+				if err != nil {
+					return
+				}
+				start, err := unmarshalClientMessage(data)
+				if err != nil {
+					return
+				}
+				streamID := start.(*StartMessage).StreamID
+				if writeMessage(r.Context(), conn, &AckMessage{Event: MessageTypeAck, StreamID: streamID, Config: start.(*StartMessage).Config}) != nil ||
+					conn.Write(r.Context(), websocket.MessageText, []byte(frame)) != nil ||
+					writeMessage(r.Context(), conn, &ClearMessage{Event: MessageTypeClear, StreamID: streamID}) != nil {
+					return
+				}
+				conn.Read(r.Context())
+			})
+
+			session := newTestSession(t, srv, Config{StrictMessages: strict})
+			if !strict {
+				// The frame is skipped
+				select {
+				case m := <-session.Messages():
+					if m.Type() != MessageTypeClear {
+						t.Errorf("received %s after %s, want the clear", m.Type(), frame)
+					}
+				case <-time.After(5 * time.Second):
+					t.Errorf("session stopped at %s, want it skipped", frame)
+				}
+				continue
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			err := session.WaitClosed(ctx)
+			cancel()
+			if !errors.Is(err, ErrInvalidMessage) || !strings.Contains(err.Error(), frame) {
+				t.Errorf("strict session ended with %v, want ErrInvalidMessage quoting %s", err, frame)
+			}
+			if got := session.Stats().TerminatedBy; got != TerminatedByRead {
+				t.Errorf("TerminatedBy = %q, want %q", got, TerminatedByRead)
+			}
+		}
+	}
+}
+
+func TestSetMetadata(t *testing.T) {
+	updates := make(chan *CustomMessage, 1)
+	srv := NewTestServer(&TestServerOptions{
+		Respond: func(msg Message) []Message {
+			if m, ok := msg.(*CustomMessage); ok && m.Metadata["type"] == "metadata_update" {
+				updates <- m
+			}
+			return nil
+		},
+		Drop: func(msg Message) bool {
+			m, ok := msg.(*CustomMessage)
+			return ok && m.Metadata["text"] == "drop"
+		},
+	})
+	defer srv.Close()
+
+	client := newTestClient(t, srv, Config{Reconnect: &ReconnectConfig{InitialBackoff: 10 * time.Millisecond}})
+	session, err := client.NewReconnectingSession(context.Background(), "agent", map[string]interface{}{"team": "qa", "tier": 1})
+	if err != nil {
+		t.Fatalf("NewReconnectingSession: %v", err)
+	}
+	defer session.Close()
+
+	// The caller authenticates mid-call
+	if err := session.SetMetadata(context.Background(), Metadata{"tier": 2, "caller": "verified"}); err != nil {
+		t.Fatalf("SetMetadata: %v", err)
+	}
+	select {
+	case m := <-updates:
+		// Only the changes are sent
+		delta, ok := m.Metadata["metadata"].(map[string]interface{})
+		if !ok || len(delta) != 2 || delta["tier"] != 2.0 || delta["caller"] != "verified" {
+			t.Errorf("update carries %v, want the tier and caller", m.Metadata["metadata"])
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("server received no metadata update")
+	}
+
+	check := func(md Metadata) {
+		t.Helper()
+		if len(md) != 3 || md["team"] != "qa" || fmt.Sprint(md["tier"]) != "2" || md["caller"] != "verified" {
+			t.Errorf("metadata = %v, want the start metadata merged with the update", md)
+		}
+	}
+	check(session.Metadata())
+
+	// Invalid metadata is neither sent nor merged
+	if err := session.SetMetadata(context.Background(), Metadata{"bad": make(chan int)}); err == nil {
+		t.Error("SetMetadata of unencodable metadata succeeded")
+	}
+	check(session.Metadata())
+
+	// A new connection starts with the merged metadata
+	if err := session.SendText(context.Background(), "drop"); err != nil {
+		t.Fatal(err)
+	}
+	var starts []*StartMessage
+	for deadline := time.Now().Add(5 * time.Second); len(starts) < 2 && time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		starts = starts[:0]
+		for _, msg := range srv.Received() {
+			if start, ok := msg.(*StartMessage); ok {
+				starts = append(starts, start)
+			}
+		}
+	}
+	if len(starts) < 2 {
+		t.Fatal("session did not reconnect")
+	}
+	check(starts[1].Metadata)
+}
+
+func TestPCMByteOrder(t *testing.T) {
+	srv := NewTestServer(&TestServerOptions{EchoMedia: true})
+	defer srv.Close()
+
+	// 1000 and -2 in both byte orders
+	little := []byte{0xe8, 0x03, 0xfe, 0xff}
+	big := []byte{0x03, 0xe8, 0xff, 0xfe}
+
+	cfg := Config{PCMByteOrder: binary.BigEndian}
+	session := newTestSession(t, srv, cfg)
+	path := filepath.Join(t.TempDir(), "call.wav")
+	rec, err := NewDualChannelRecorder(path, 16000, &RecorderOptions{ByteOrder: binary.BigEndian})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Big-endian audio goes out little-endian and comes back big-endian
+	if err := session.Send(context.Background(), NewMediaInputFromPCM(session.StreamID(), big)); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	var echo []byte
+	select {
+	case m := <-session.Messages():
+		media, ok := m.(*MediaOutputMessage)
+		if !ok {
+			t.Fatalf("received %s, want the echo", m.Type())
+		}
+		if echo, err = session.DecodeMedia(media.Media.Payload); err != nil || !bytes.Equal(echo, big) {
+			t.Errorf("DecodeMedia = %v, %v; want %v", echo, err, big)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no echo")
+	}
+	sent, _ := base64.StdEncoding.DecodeString(srv.Received()[1].(*MediaInputMessage).Media.Payload)
+	if !bytes.Equal(sent, little) {
+		t.Errorf("server received %v, want %v", sent, little)
+	}
+
+	// and so does AudioOut
+	out := session.AudioOut()
+	if err := session.Send(context.Background(), NewMediaInputFromPCM(session.StreamID(), big)); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if got := nextAudio(t, out); !bytes.Equal(got, big) {
+		t.Errorf("AudioOut delivered %v, want %v", got, big)
+	}
+
+	// The recorder writes both turns as little-endian WAV samples
+	if err := rec.WriteLeft(big); err != nil {
+		t.Fatal(err)
+	}
+	if err := rec.WriteRight(echo); err != nil {
+		t.Fatal(err)
+	}
+	if err := rec.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if got, want := decodeRecording(t, f), []int{1000, 0, -2, 0, 0, 1000, 0, -2}; !slices.Equal(got, want) {
+		t.Errorf("recording = %v, want %v", got, want)
+	}
+
+	// PrepareInput reads the little-endian WAV into big-endian audio
+	if data, err := PrepareInput(writeTestWAV(t, "question.wav", little, 16000), testConfig(srv, cfg)); err != nil || !bytes.Equal(data, big) {
+		t.Errorf("PrepareInput = %v, %v; want %v", data, err, big)
+	}
+}
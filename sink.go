@@ -0,0 +1,134 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+// AudioSink receives agent audio as 16-bit PCM at the session's sample
+// rate, with the time it arrived, e.g. to forward it to a transcription
+// service or drive a level meter. Unlike the FIFOSink and FFmpegSink
+// recorders, which take audio as received, it gets µ-law and A-law decoded.
+type AudioSink interface {
+	Write(pcm []byte, ts time.Time) error
+}
+
+// AudioSinkFunc adapts a function to an AudioSink.
+type AudioSinkFunc func(pcm []byte, ts time.Time) error
+
+func (f AudioSinkFunc) Write(pcm []byte, ts time.Time) error {
+	return f(pcm, ts)
+}
+
+// RecorderAudioSink writes to the agent channel of a Recorder, so further
+// recordings can be fed from a FanOut. The recorder receives PCM whatever
+// the session format. Closing the FanOut does not close r.
+func RecorderAudioSink(r Recorder) AudioSink {
+	return AudioSinkFunc(func(pcm []byte, ts time.Time) error {
+		return r.WriteRight(pcm)
+	})
+}
+
+// FanOut is a Recorder that hands agent audio, decoded once, to a recorder
+// and to every registered sink. The recorder gets the audio in the session
+// format, the sinks as PCM. A failing sink does not stop the others; their
+// errors are joined.
+type FanOut struct {
+	recorder Recorder
+	now      func() time.Time
+
+	mu     sync.RWMutex
+	sinks  []AudioSink
+	format InputFormat // session format set by CheckFormat, PCM if unset
+}
+
+// NewFanOut writes to recorder, which may be nil, and to sinks.
+func NewFanOut(recorder Recorder, sinks ...AudioSink) *FanOut {
+	if recorder == nil {
+		recorder = DiscardRecorder{}
+	}
+	return &FanOut{recorder: recorder, now: time.Now, sinks: sinks}
+}
+
+// AddSink registers another sink. It may be called while audio flows.
+func (f *FanOut) AddSink(s AudioSink) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.sinks = append(f.sinks, s)
+}
+
+// WriteLeft records user audio; sinks only receive agent audio.
+func (f *FanOut) WriteLeft(data []byte) error {
+	return f.recorder.WriteLeft(data)
+}
+
+// WriteRight records agent audio and passes it to every sink.
+func (f *FanOut) WriteRight(data []byte) error {
+	return f.fanOut(f.recorder.WriteRight(data), data)
+}
+
+// WriteTrack records labeled agent audio on recorders that keep tracks apart
+// and passes it to every sink.
+func (f *FanOut) WriteTrack(track string, data []byte) error {
+	var err error
+	if t, ok := f.recorder.(TrackRecorder); ok {
+		err = t.WriteTrack(track, data)
+	} else {
+		err = f.recorder.WriteRight(data)
+	}
+	return f.fanOut(err, data)
+}
+
+// fanOut passes data, decoded to PCM, to every sink and joins their errors
+// with err, the recorder's.
+func (f *FanOut) fanOut(err error, data []byte) error {
+	ts := f.now()
+
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	if len(f.sinks) == 0 {
+		return err
+	}
+
+	pcm := data
+	if f.format != "" && !isPCM16(f.format) {
+		pcm = pcm16Codec{}.Encode(decodeSamples(f.format, data))
+	}
+
+	errs := []error{err}
+	for _, s := range f.sinks {
+		errs = append(errs, s.Write(pcm, ts))
+	}
+	return errors.Join(errs...)
+}
+
+// CheckFormat notes the session format that sinks get audio decoded from
+// and prepares the recorder for it when it supports that.
+func (f *FanOut) CheckFormat(cfg StreamConfig) bool {
+	f.mu.Lock()
+	f.format = cfg.InputFormat
+	f.mu.Unlock()
+
+	if c, ok := f.recorder.(interface{ CheckFormat(StreamConfig) bool }); ok {
+		return c.CheckFormat(cfg)
+	}
+	return true
+}
+
+// Close closes the recorder and the sinks that are io.Closers.
+func (f *FanOut) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	errs := []error{f.recorder.Close()}
+	for _, s := range f.sinks {
+		if c, ok := s.(io.Closer); ok {
+			errs = append(errs, c.Close())
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// collectSink keeps the audio written to it.
+type collectSink struct {
+	mu  sync.Mutex
+	pcm []byte
+}
+
+func (s *collectSink) Write(pcm []byte, ts time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.pcm = append(s.pcm, pcm...)
+	return nil
+}
+
+func (s *collectSink) bytes() []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.pcm
+}
+
+func TestFanOutDecodesForSinks(t *testing.T) {
+	samples := make([]int16, 800) // 100ms at 8 kHz
+	for i := range samples {
+		samples[i] = int16(i%200*100 - 10000)
+	}
+	mulaw := mulawCodec{}.Encode(samples)
+
+	srv := NewTestServer(&TestServerOptions{
+		OnStart: func(start *StartMessage) []Message {
+			return []Message{NewMediaOutputFromPCM(start.StreamID, mulaw)}
+		},
+	})
+	defer srv.Close()
+
+	session := newTestSession(t, srv, Config{InputFormat: InputFormatMulaw8000})
+
+	recorder := &testRecorder{}
+	first, second := &collectSink{}, &collectSink{}
+	fanOut := NewFanOut(recorder, first)
+	fanOut.AddSink(second)
+	fanOut.CheckFormat(session.Config())
+
+	conversation := NewConversation(session, fanOut)
+	if err := conversation.DrainUntilSilence(context.Background(), 300*time.Millisecond); err != nil {
+		t.Fatalf("DrainUntilSilence: %v", err)
+	}
+
+	if n := recorder.rightBytes(); n != len(mulaw) {
+		t.Errorf("recorder got %d bytes, want the %d of µ-law as received", n, len(mulaw))
+	}
+	want := pcm16Codec{}.Encode(mulawCodec{}.Decode(mulaw))
+	for i, sink := range []*collectSink{first, second} {
+		if !bytes.Equal(sink.bytes(), want) {
+			t.Errorf("sink %d got %d bytes, want the %d of decoded PCM", i, len(sink.bytes()), len(want))
+		}
+	}
+}
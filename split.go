@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/go-audio/audio"
+	"github.com/go-audio/wav"
+)
+
+// SplitStereoWAV writes the left and right channels of a stereo PCM WAV, such
+// as a DualChannelRecorder recording, to separate mono WAVs at the same rate
+// and bit depth, e.g. to transcribe the user and agent apart.
+func SplitStereoWAV(input, leftOut, rightOut string) error {
+	file, err := os.Open(input)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	decoder := wav.NewDecoder(file)
+	if !decoder.IsValidFile() {
+		return fmt.Errorf("%w: %s", ErrUnsupportedWAV, input)
+	}
+	if decoder.NumChans != 2 || decoder.WavAudioFormat != wavFormatPCM {
+		return fmt.Errorf("%w: %s: expected stereo PCM, got format %d with %d channels",
+			ErrUnsupportedWAV, input, decoder.WavAudioFormat, decoder.NumChans)
+	}
+
+	buf, err := decoder.FullPCMBuffer()
+	if err != nil {
+		return fmt.Errorf("read %s error: %w", input, err)
+	}
+
+	frames := len(buf.Data) / 2
+	left := make([]int, frames)
+	right := make([]int, frames)
+	for i := 0; i < frames; i++ {
+		left[i] = buf.Data[i*2]
+		right[i] = buf.Data[i*2+1]
+	}
+
+	rate, depth := int(decoder.SampleRate), int(decoder.BitDepth)
+	if err := writeMonoWAV(leftOut, left, rate, depth); err != nil {
+		return err
+	}
+	return writeMonoWAV(rightOut, right, rate, depth)
+}
+
+// writeMonoWAV writes samples to a new mono PCM WAV.
+func writeMonoWAV(filename string, samples []int, sampleRate, bitDepth int) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+
+	encoder := wav.NewEncoder(file, sampleRate, bitDepth, 1, wavFormatPCM)
+	err = encoder.Write(&audio.IntBuffer{
+		Data:           samples,
+		Format:         &audio.Format{SampleRate: sampleRate, NumChannels: 1},
+		SourceBitDepth: bitDepth,
+	})
+	if err == nil {
+		err = encoder.Close()
+	}
+
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("write %s error: %w", filename, err)
+	}
+	return nil
+}
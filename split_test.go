@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/go-audio/wav"
+)
+
+// decodeMono decodes the mono WAV at path into samples at rate.
+func decodeMono(t *testing.T, path string, rate int) []int {
+	t.Helper()
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	dec := wav.NewDecoder(f)
+	buf, err := dec.FullPCMBuffer()
+	if err != nil {
+		t.Fatalf("decode %s: %v", path, err)
+	}
+	if dec.NumChans != 1 || int(dec.SampleRate) != rate || dec.BitDepth != 16 {
+		t.Fatalf("%s has %d channels at %d Hz, %d bits; want mono 16-bit at %d Hz", path, dec.NumChans, dec.SampleRate, dec.BitDepth, rate)
+	}
+	return buf.Data
+}
+
+func TestSplitStereoWAV(t *testing.T) {
+	srv := newConversationServer(nil)
+	defer srv.Close()
+
+	recording, err := runTestConversation(t, srv, Config{}, nil)
+	if err != nil {
+		t.Fatalf("RunConversationWithOptions: %v", err)
+	}
+	f, err := os.Open(recording)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stereo := decodeRecording(t, f)
+	f.Close()
+
+	dir := t.TempDir()
+	leftOut, rightOut := filepath.Join(dir, "user.wav"), filepath.Join(dir, "agent.wav")
+	if err := SplitStereoWAV(recording, leftOut, rightOut); err != nil {
+		t.Fatalf("SplitStereoWAV: %v", err)
+	}
+
+	var wantLeft, wantRight []int
+	for i := 0; i < len(stereo); i += 2 {
+		wantLeft = append(wantLeft, stereo[i])
+		wantRight = append(wantRight, stereo[i+1])
+	}
+	left, right := decodeMono(t, leftOut, 16000), decodeMono(t, rightOut, 16000)
+	if !slices.Equal(left, wantLeft) {
+		t.Errorf("user file holds %d samples, want the %d of the left channel", len(left), len(wantLeft))
+	}
+	if !slices.Equal(right, wantRight) {
+		t.Errorf("agent file holds %d samples, want the %d of the right channel", len(right), len(wantRight))
+	}
+	// The question and the agent's turns do not line up, so a mixed up
+	// split would show
+	if slices.Equal(left, right) {
+		t.Error("both channels hold the same audio")
+	}
+
+	// A mono file has nothing to split
+	mono := writeTestWAV(t, "mono.wav", loudPCM(100*time.Millisecond, 16000), 16000)
+	if err := SplitStereoWAV(mono, leftOut, rightOut); !errors.Is(err, ErrUnsupportedWAV) {
+		t.Errorf("SplitStereoWAV of a mono file = %v, want ErrUnsupportedWAV", err)
+	}
+}
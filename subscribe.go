@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+// subscriptions fans incoming messages out to per-type subscribers.
+type subscriptions struct {
+	mu     sync.Mutex
+	subs   []subscription
+	closed bool
+}
+
+// subscription
+type subscription struct {
+	types map[MessageType]bool // nil for every type
+	ch    chan Message
+}
+
+// add registers a subscriber for types, or for every type if none are given.
+// After closeAll the returned channel is already closed.
+func (s *subscriptions) add(types []MessageType) <-chan Message {
+	sub := subscription{ch: make(chan Message, 10)}
+	if len(types) > 0 {
+		sub.types = make(map[MessageType]bool, len(types))
+		for _, t := range types {
+			sub.types[t] = true
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		close(sub.ch)
+	} else {
+		s.subs = append(s.subs, sub)
+	}
+	return sub.ch
+}
+
+// route delivers m to every subscriber of its type, blocking until each has
+// room or ctx is done. It reports whether any subscriber wanted m.
+func (s *subscriptions) route(ctx context.Context, m Message) bool {
+	s.mu.Lock()
+	var targets []chan Message
+	for _, sub := range s.subs {
+		if sub.types == nil || sub.types[m.Type()] {
+			targets = append(targets, sub.ch)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, ch := range targets {
+		select {
+		case ch <- m:
+		case <-ctx.Done():
+			return true
+		}
+	}
+	return len(targets) > 0
+}
+
+// closeAll closes every subscriber channel. Only the goroutine calling route
+// may call it.
+func (s *subscriptions) closeAll() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+	for _, sub := range s.subs {
+		close(sub.ch)
+	}
+	s.subs = nil
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+
+	"github.com/coder/websocket"
+)
+
+// TestServerOptions
+type TestServerOptions struct {
+	// AckConfig is confirmed by the ack instead of the config of the start
+	// event, e.g. to test a server that requires another input format.
+	AckConfig *StreamConfig
+
+	// OnStart returns messages to send right after the ack, e.g. a greeting.
+	OnStart func(start *StartMessage) []Message
+
+	// EchoMedia sends every media_input payload back as media_output.
+	EchoMedia bool
+
+	// Respond returns messages to send in reply to each message the client
+	// sends after start, after any echo.
+	Respond func(msg Message) []Message
+
+	// Drop closes the connection without a close frame after the replies
+	// to each message it returns true for, e.g. to test reconnects.
+	Drop func(msg Message) bool
+}
+
+// TestServer is an in-process agent stream endpoint for integration tests.
+// It acks the start event, echoing its config, and replies to each client
+// message as scripted by TestServerOptions before reading the next one, so
+// a conversation against it always plays out the same way.
+type TestServer struct {
+	server *httptest.Server
+	opts   TestServerOptions
+
+	mu       sync.Mutex
+	received []Message
+}
+
+// NewTestServer starts a test server. opts may be nil. Point Config.BaseURL
+// at URL and call Close when done.
+func NewTestServer(opts *TestServerOptions) *TestServer {
+	if opts == nil {
+		opts = &TestServerOptions{}
+	}
+
+	s := &TestServer{opts: *opts}
+	s.server = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+// URL returns the ws:// base URL of the server for Config.BaseURL. Every
+// agent ID is accepted.
+func (s *TestServer) URL() string {
+	return "ws" + strings.TrimPrefix(s.server.URL, "http")
+}
+
+// Received returns the messages clients sent, including start events, in
+// the order they arrived.
+func (s *TestServer) Received() []Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]Message(nil), s.received...)
+}
+
+// Close shuts the server down, closing open connections.
+func (s *TestServer) Close() {
+	s.server.CloseClientConnections()
+	s.server.Close()
+}
+
+func (s *TestServer) serve(w http.ResponseWriter, r *http.Request) {
+	conn, err := websocket.Accept(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.CloseNow()
+
+	ctx := r.Context()
+	for {
+		_, data, err := conn.Read(ctx)
+		if err != nil {
+			return
+		}
+
+		msg, err := unmarshalClientMessage(data)
+		if err != nil {
+			log.Printf("Test server ignoring message: %v", err)
+			continue
+		}
+
+		s.mu.Lock()
+		s.received = append(s.received, msg)
+		s.mu.Unlock()
+
+		for _, reply := range s.replies(msg) {
+			if err := writeMessage(ctx, conn, reply); err != nil {
+				return
+			}
+		}
+		if s.opts.Drop != nil && s.opts.Drop(msg) {
+			return
+		}
+	}
+}
+
+// replies returns the scripted answers to msg.
+func (s *TestServer) replies(msg Message) []Message {
+	if start, ok := msg.(*StartMessage); ok {
+		cfg := start.Config
+		if s.opts.AckConfig != nil {
+			cfg = *s.opts.AckConfig
+		}
+
+		replies := []Message{&AckMessage{Event: MessageTypeAck, StreamID: start.StreamID, Config: cfg}}
+		if s.opts.OnStart != nil {
+			replies = append(replies, s.opts.OnStart(start)...)
+		}
+		return replies
+	}
+
+	var replies []Message
+	if media, ok := msg.(*MediaInputMessage); ok && s.opts.EchoMedia {
+		replies = append(replies, &MediaOutputMessage{
+			Event:    MessageTypeMediaOutput,
+			StreamID: media.StreamID,
+			Media:    media.Media,
+		})
+	}
+	if s.opts.Respond != nil {
+		replies = append(replies, s.opts.Respond(msg)...)
+	}
+	return replies
+}
+
+// writeMessage sends m as a text frame.
+func writeMessage(ctx context.Context, conn *websocket.Conn, m Message) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return conn.Write(ctx, websocket.MessageText, data)
+}
+
+// unmarshalClientMessage parses a message sent by a client, the counterpart
+// of UnmarshalMessage for the server side.
+func unmarshalClientMessage(data []byte) (Message, error) {
+	var genericMsg struct {
+		Event MessageType `json:"event"`
+	}
+
+	if err := json.Unmarshal(data, &genericMsg); err != nil {
+		return nil, err
+	}
+
+	var msg Message
+	switch genericMsg.Event {
+	case MessageTypeStart:
+		msg = &StartMessage{}
+	case MessageTypeMediaInput:
+		msg = &MediaInputMessage{}
+	case MessageTypeDTMF:
+		msg = &DTMFMessage{}
+	case MessageTypeCustom:
+		msg = &CustomMessage{}
+	}
+
+	if msg == nil {
+		return nil, ErrUnknownMessageType
+	}
+
+	if err := json.Unmarshal(data, msg); err != nil {
+		return nil, err
+	}
+
+	return msg, nil
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-audio/wav"
+)
+
+// testConfig returns cfg pointed at srv, PCM at 16 kHz unless cfg sets a
+// format.
+func testConfig(srv *TestServer, cfg Config) Config {
+	cfg.BaseURL = srv.URL()
+	if cfg.Version == "" {
+		cfg.Version = VERSION
+	}
+	if cfg.InputFormat == "" {
+		cfg.InputFormat = InputFormatPCM16000
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	}
+	return cfg
+}
+
+// newTestClient returns a client for srv with cfg's other settings.
+func newTestClient(t *testing.T, srv *TestServer, cfg Config) *Client {
+	t.Helper()
+
+	client, err := NewClient(testConfig(srv, cfg))
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	return client
+}
+
+// newTestSession opens a session on srv, closed when the test ends.
+func newTestSession(t *testing.T, srv *TestServer, cfg Config) Session {
+	t.Helper()
+
+	session, err := newTestClient(t, srv, cfg).NewSession(context.Background(), "agent", nil)
+	if err != nil {
+		t.Fatalf("NewSession: %v", err)
+	}
+	t.Cleanup(func() { session.Close() })
+	return session
+}
+
+// loudPCM returns d of 16-bit PCM at rate, loud enough to count as speech.
+func loudPCM(d time.Duration, rate int) []byte {
+	pcm := make([]byte, int(d*time.Duration(rate)/time.Second)*2)
+	for i := 0; i < len(pcm); i += 2 {
+		pcm[i+1] = 0x40
+	}
+	return pcm
+}
+
+// writeTestWAV writes mono 16-bit PCM at rate to a WAV file in the test's
+// temporary directory.
+func writeTestWAV(t *testing.T, name string, pcm []byte, rate int) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	h := &wavHeader{tag: wavFormatPCM, sampleRate: rate, sampleBytes: 2}
+	if err := os.WriteFile(path, append(h.bytes(int64(len(pcm))), pcm...), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// runFakeClock advances clock by step every millisecond until the test
+// ends, so that waits on it pass quickly but in order.
+func runFakeClock(t *testing.T, clock *FakeClock, step time.Duration) {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	t.Cleanup(func() {
+		close(done)
+		<-stopped
+	})
+
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				clock.Advance(step)
+			case <-done:
+				return
+			}
+		}
+	}()
+}
+
+// questionFrames is the number of 100ms media_input frames RunConversation
+// sends for the question of runTestConversation and the silence ending it.
+const questionFrames = 15
+
+// newConversationServer returns a server for RunConversation whose agent
+// greets, then answers once the question has arrived. extra, if set, adds
+// messages after the greeting, as frame 0, and after each frame of the
+// question.
+func newConversationServer(extra func(streamID string, frame int) []Message) *TestServer {
+	var frames atomic.Int32
+	return NewTestServer(&TestServerOptions{
+		OnStart: func(start *StartMessage) []Message {
+			replies := []Message{NewMediaOutputFromPCM(start.StreamID, loudPCM(300*time.Millisecond, 16000))}
+			if extra != nil {
+				replies = append(replies, extra(start.StreamID, 0)...)
+			}
+			return replies
+		},
+		Respond: func(msg Message) []Message {
+			m, ok := msg.(*MediaInputMessage)
+			if !ok {
+				return nil
+			}
+			frame := int(frames.Add(1))
+
+			var replies []Message
+			if frame == questionFrames {
+				time.Sleep(100 * time.Millisecond) // after the client noted the question as sent
+				replies = append(replies, NewMediaOutputFromPCM(m.StreamID, loudPCM(300*time.Millisecond, 16000)))
+			}
+			if extra != nil {
+				replies = append(replies, extra(m.StreamID, frame)...)
+			}
+			return replies
+		},
+	})
+}
+
+// runTestConversation runs a conversation with srv, asking 500ms of speech,
+// and returns the path of the recording.
+func runTestConversation(t *testing.T, srv *TestServer, cfg Config, opts *ConversationOptions) (string, error) {
+	t.Helper()
+
+	if opts == nil {
+		opts = &ConversationOptions{}
+	}
+	if opts.Completion == nil {
+		opts.Completion = SilenceCompletion{Threshold: 200 * time.Millisecond}
+	}
+
+	input := writeTestWAV(t, "question.wav", loudPCM(500*time.Millisecond, 16000), 16000)
+	output := filepath.Join(t.TempDir(), "conversation.wav")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return output, RunConversationWithOptions(ctx, testConfig(srv, cfg), "agent", input, output, opts)
+}
+
+func TestTestServerConversation(t *testing.T) {
+	srv := newConversationServer(nil)
+	defer srv.Close()
+
+	output, err := runTestConversation(t, srv, Config{}, nil)
+	if err != nil {
+		t.Fatalf("RunConversationWithOptions: %v", err)
+	}
+
+	if _, ok := srv.Received()[0].(*StartMessage); !ok {
+		t.Errorf("first message is %T, want *StartMessage", srv.Received()[0])
+	}
+	if n := mediaFrames(srv); n != questionFrames {
+		t.Errorf("server received %d media_input frames, want %d", n, questionFrames)
+	}
+
+	file, err := os.Open(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	decoder := wav.NewDecoder(file)
+	if !decoder.IsValidFile() || decoder.NumChans != 2 {
+		t.Fatalf("recording is not a valid stereo WAV")
+	}
+	if d, err := decoder.Duration(); err != nil || d < 1500*time.Millisecond {
+		t.Errorf("recording lasts %s (%v), want the greeting, question, silence and answer", d, err)
+	}
+}
+
+func TestTestServerDrop(t *testing.T) {
+	srv := NewTestServer(&TestServerOptions{
+		Drop: func(msg Message) bool {
+			_, ok := msg.(*CustomMessage)
+			return ok
+		},
+	})
+	defer srv.Close()
+
+	session := newTestSession(t, srv, Config{})
+	if err := session.SendText(context.Background(), "bye"); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := session.WaitClosed(ctx); err == nil || errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("WaitClosed = %v, want the connection error", err)
+	}
+}
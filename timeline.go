@@ -0,0 +1,179 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// TimelineRecorder records a stereo WAV like DualChannelRecorder, but places
+// each write at the moment it happened instead of appending it, so overlap
+// and pauses between user and agent audio are preserved. Audio that arrives
+// faster than real time continues where the channel's previous write ended.
+// Writes from both sides may happen concurrently. Intervals where both sides
+// speak at once are reported by Overlaps.
+type TimelineRecorder struct {
+	rec *DualChannelRecorder
+	now func() time.Time
+
+	mu        sync.Mutex
+	closed    bool
+	start     time.Time  // time of the first write
+	committed int64      // frames handed to rec
+	pending   [2][]int16 // left and right frames from committed onwards
+	cursor    [2]int64   // end of each channel's audio in frames
+	written   [2]int64   // frames of audio placed on each channel
+	overlap   overlapDetector
+}
+
+const (
+	leftChannel  = 0
+	rightChannel = 1
+)
+
+// NewTimelineRecorder creates a time-aligned stereo WAV recorder. opts may be nil.
+func NewTimelineRecorder(filename string, sampleRate int, opts *RecorderOptions) (*TimelineRecorder, error) {
+	rec, err := NewDualChannelRecorder(filename, sampleRate, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &TimelineRecorder{
+		rec:     rec,
+		now:     time.Now,
+		overlap: overlapDetector{sampleRate: rec.sampleRate},
+	}, nil
+}
+
+// WriteLeft places user audio on the left channel.
+func (r *TimelineRecorder) WriteLeft(data []byte) error {
+	return r.write(leftChannel, data)
+}
+
+// WriteRight places agent audio on the right channel.
+func (r *TimelineRecorder) WriteRight(data []byte) error {
+	return r.write(rightChannel, data)
+}
+
+func (r *TimelineRecorder) write(channel int, data []byte) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		return ErrRecorderClosed
+	}
+
+	now := r.now()
+	if r.start.IsZero() {
+		r.start = now
+	}
+	nowFrame := int64(now.Sub(r.start)) * int64(r.rec.sampleRate) / int64(time.Second)
+
+	samples := r.rec.decode(channel, data)
+	pos := max(nowFrame, r.cursor[channel])
+	end := pos + int64(len(samples))
+
+	r.grow(end)
+	copy(r.pending[channel][pos-r.committed:], samples)
+	r.cursor[channel] = end
+	r.written[channel] += int64(len(samples))
+
+	// Later writes land at or after now, so everything before it is final
+	return r.flush(nowFrame)
+}
+
+// grow extends both pending channels with silence up to frame end.
+func (r *TimelineRecorder) grow(end int64) {
+	for ch := range r.pending {
+		if n := end - r.committed - int64(len(r.pending[ch])); n > 0 {
+			r.pending[ch] = append(r.pending[ch], make([]int16, n)...)
+		}
+	}
+}
+
+// flush writes the frames before upto to the recording.
+func (r *TimelineRecorder) flush(upto int64) error {
+	n := upto - r.committed
+	if n <= 0 {
+		return nil
+	}
+	r.grow(upto)
+
+	interleaved := make([]int, n*2)
+	for i := int64(0); i < n; i++ {
+		interleaved[i*2] = int(r.pending[leftChannel][i])
+		interleaved[i*2+1] = int(r.pending[rightChannel][i])
+	}
+
+	r.overlap.add(r.pending[leftChannel][:n], r.pending[rightChannel][:n])
+
+	for ch := range r.pending {
+		r.pending[ch] = r.pending[ch][n:]
+	}
+	r.committed = upto
+
+	return r.rec.record("timeline", interleaved)
+}
+
+// CheckFormat prepares the recorder for audio in cfg, see DualChannelRecorder.CheckFormat.
+func (r *TimelineRecorder) CheckFormat(cfg StreamConfig) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.rec.CheckFormat(cfg)
+}
+
+// Recording reports whether audio is still being recorded.
+func (r *TimelineRecorder) Recording() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.rec.Recording()
+}
+
+// Stats reports the audio placed on each channel and the length of the
+// recording. Audio still pending is only included in Frames after Close.
+func (r *TimelineRecorder) Stats() RecorderStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stats := r.rec.Stats()
+	stats.LeftFrames = r.written[leftChannel]
+	stats.RightFrames = r.written[rightChannel]
+	return stats
+}
+
+// Overlaps returns the intervals, as offsets into the recording, during
+// which both the user and the agent were speaking, e.g. to measure how
+// often and how long the agent talks over a barge-in. Speech is audio above
+// -50 dBFS, judged in 10ms windows. Audio is analyzed as it is written to
+// the file, so the result is complete only after Close.
+func (r *TimelineRecorder) Overlaps() []OverlapSpan {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]OverlapSpan(nil), r.overlap.spans...)
+}
+
+// Close writes the remaining audio, padded with silence up to the time of
+// the call so the recording lasts as long as the conversation, and
+// finalizes the WAV file.
+func (r *TimelineRecorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		return ErrRecorderClosed
+	}
+	r.closed = true
+
+	end := max(r.cursor[leftChannel], r.cursor[rightChannel])
+	if !r.start.IsZero() {
+		end = max(end, int64(r.now().Sub(r.start))*int64(r.rec.sampleRate)/int64(time.Second))
+	}
+
+	err := r.flush(end)
+	r.overlap.finish()
+	if closeErr := r.rec.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTimelineRecorderAlignment(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "call.wav")
+	rec, err := NewTimelineRecorder(path, 1000, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Unix(0, 0)
+	now := start
+	rec.now = func() time.Time { return now }
+
+	at := func(d time.Duration) { now = start.Add(d) }
+	write := func(write func([]byte) error, frames int, value int16) {
+		t.Helper()
+		if err := write(pcmOf(frames, value)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	at(0)
+	write(rec.WriteLeft, 100, 8000) // user speaks for 100ms
+	at(50 * time.Millisecond)
+	write(rec.WriteRight, 100, 9000) // the agent talks over the last 50ms
+	write(rec.WriteRight, 50, 10000) // and carries on where it left off
+	at(500 * time.Millisecond)
+	write(rec.WriteLeft, 10, 11000) // after a pause the user speaks again
+	at(time.Second)
+	if err := rec.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	samples := decodeRecording(t, f)
+	if len(samples) != 2*1000 {
+		t.Fatalf("recording has %d frames, want 1000 up to the Close", len(samples)/2)
+	}
+
+	expect := func(frame int) (left, right int) {
+		switch {
+		case frame < 100:
+			left = 8000
+		case frame >= 500 && frame < 510:
+			left = 11000
+		}
+		switch {
+		case frame >= 50 && frame < 150:
+			right = 9000
+		case frame >= 150 && frame < 200:
+			right = 10000
+		}
+		return left, right
+	}
+	for frame := 0; frame < 1000; frame++ {
+		left, right := expect(frame)
+		if samples[2*frame] != left || samples[2*frame+1] != right {
+			t.Fatalf("frame %d = %d, %d; want %d, %d", frame, samples[2*frame], samples[2*frame+1], left, right)
+		}
+	}
+
+	overlaps := rec.Overlaps()
+	if len(overlaps) != 1 || overlaps[0] != (OverlapSpan{Start: 50 * time.Millisecond, End: 100 * time.Millisecond}) {
+		t.Errorf("Overlaps() = %v, want 50ms-100ms", overlaps)
+	}
+}
+
+func TestTimelineRecorderPadsAgentGaps(t *testing.T) {
+	// The agent greets, then answers 300ms after the user's message
+	const delay = 300 * time.Millisecond
+	greeting := pcmOf(1600, 8000) // 100ms
+	answer := pcmOf(1600, 9000)
+	srv := NewTestServer(&TestServerOptions{
+		OnStart: func(start *StartMessage) []Message {
+			return []Message{NewMediaOutputFromPCM(start.StreamID, greeting)}
+		},
+		Respond: func(msg Message) []Message {
+			m, ok := msg.(*MediaInputMessage)
+			if !ok {
+				return nil
+			}
+			time.Sleep(delay)
+			return []Message{NewMediaOutputFromPCM(m.StreamID, answer)}
+		},
+	})
+	defer srv.Close()
+
+	session := newTestSession(t, srv, Config{})
+	path := filepath.Join(t.TempDir(), "call.wav")
+	rec, err := NewTimelineRecorder(path, 16000, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := session.Send(context.Background(), NewMediaInputFromPCM(session.StreamID(), pcmOf(160, 0))); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if err := NewConversation(session, rec).DrainUntilSilence(context.Background(), time.Second); err != nil {
+		t.Fatalf("DrainUntilSilence: %v", err)
+	}
+	if err := rec.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	samples := decodeRecording(t, f)
+
+	// The right channel holds the greeting, silence until the answer came
+	// and the answer, with the left channel silent throughout
+	var runs []struct{ value, frames int }
+	for i := 1; i < len(samples); i += 2 {
+		if samples[i-1] != 0 {
+			t.Fatalf("left channel frame %d = %d, want silence", i/2, samples[i-1])
+		}
+		if n := len(runs); n > 0 && runs[n-1].value == samples[i] {
+			runs[n-1].frames++
+			continue
+		}
+		runs = append(runs, struct{ value, frames int }{samples[i], 1})
+	}
+	if len(runs) < 3 || runs[0].value != 8000 || runs[0].frames != 1600 || runs[1].value != 0 || runs[2].value != 9000 || runs[2].frames != 1600 {
+		t.Fatalf("right channel runs = %v, want the greeting, silence and the answer", runs)
+	}
+	// The answer was sent 300ms after the greeting at the earliest, which
+	// ended after 100ms
+	want := delay - 100*time.Millisecond
+	gap := time.Duration(runs[1].frames) * time.Second / 16000
+	if gap < want-50*time.Millisecond || gap > want+200*time.Millisecond {
+		t.Errorf("agent gap padded with %s of silence, want about %s", gap, want)
+	}
+	// and the recording runs on until the conversation ended
+	if len(runs) != 4 || runs[3].value != 0 || runs[3].frames < 16000*9/10 {
+		t.Errorf("right channel runs = %v, want a second of silence after the answer", runs)
+	}
+}
+
+func TestTimelineRecorderOverlaps(t *testing.T) {
+	// The agent greets for a second
+	srv := NewTestServer(&TestServerOptions{
+		OnStart: func(start *StartMessage) []Message {
+			var greeting []Message
+			for i := 0; i < 10; i++ {
+				greeting = append(greeting, NewMediaOutputFromPCM(start.StreamID, loudPCM(100*time.Millisecond, 16000)))
+			}
+			return greeting
+		},
+	})
+	defer srv.Close()
+
+	session := newTestSession(t, srv, Config{})
+	rec, err := NewTimelineRecorder(filepath.Join(t.TempDir(), "call.wav"), 16000, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conversation := NewConversation(session, rec)
+	drained := make(chan error, 1)
+	go func() {
+		drained <- conversation.DrainUntilSilence(context.Background(), 500*time.Millisecond)
+	}()
+	for rec.Stats().RightFrames == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// and the user talks over it twice, 200ms in and 600ms in
+	time.Sleep(200 * time.Millisecond)
+	speech := loudPCM(200*time.Millisecond, 16000)
+	question := append(append(append([]byte(nil), speech...), make([]byte, len(speech))...), speech...)
+	if err := conversation.StreamAudio(context.Background(), question); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-drained; err != nil {
+		t.Fatal(err)
+	}
+	if err := rec.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	overlaps := rec.Overlaps()
+	if len(overlaps) != 2 {
+		t.Fatalf("Overlaps() = %v, want two spans", overlaps)
+	}
+	for i, start := range []time.Duration{200 * time.Millisecond, 600 * time.Millisecond} {
+		span := overlaps[i]
+		if d := span.Start - start; d < -20*time.Millisecond || d > 100*time.Millisecond {
+			t.Errorf("span %d starts at %s, want about %s", i, span.Start, start)
+		}
+		if d := span.Duration(); d < 150*time.Millisecond || d > 250*time.Millisecond {
+			t.Errorf("span %d lasts %s, want about 200ms", i, d)
+		}
+	}
+}
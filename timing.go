@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// TimingEntry maps a span of recorded frames to the moment it was written.
+type TimingEntry struct {
+	Channel string    `json:"channel"` // "left", "right" or "gap"
+	Offset  int64     `json:"offset"`  // first frame of the span
+	Frames  int       `json:"frames"`
+	Time    time.Time `json:"time"`
+}
+
+// timingLog appends TimingEntry lines to a JSONL sidecar file.
+type timingLog struct {
+	file *os.File
+	w    *bufio.Writer
+}
+
+func newTimingLog(filename string) (*timingLog, error) {
+	file, err := os.Create(filename)
+	if err != nil {
+		return nil, err
+	}
+	return &timingLog{file: file, w: bufio.NewWriter(file)}, nil
+}
+
+func (t *timingLog) Write(entry TimingEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(t.w, "%s\n", line)
+	return err
+}
+
+func (t *timingLog) Close() error {
+	if err := t.w.Flush(); err != nil {
+		t.file.Close()
+		return err
+	}
+	return t.file.Close()
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"errors"
+	"sync"
+)
+
+// TrackRecorder is implemented by recorders that keep labeled agent audio
+// apart, see MediaOutputMessage.Label.
+type TrackRecorder interface {
+	Recorder
+	WriteTrack(track string, data []byte) error
+}
+
+// writeAgentAudio records agent audio from m, routing it by label when the
+// recorder supports tracks.
+func writeAgentAudio(recorder Recorder, m *MediaOutputMessage, data []byte) error {
+	if t, ok := recorder.(TrackRecorder); ok {
+		return t.WriteTrack(m.Label(), data)
+	}
+	return recorder.WriteRight(data)
+}
+
+// TrackRouter sends each labeled agent track to its own recorder, created
+// on first use. User audio and unlabeled agent audio go to the default
+// recorder.
+type TrackRouter struct {
+	def      Recorder
+	newTrack func(track string) (Recorder, error)
+
+	mu     sync.Mutex
+	tracks map[string]Recorder
+}
+
+// NewTrackRouter routes unlabeled audio to def and opens a recorder for every
+// new track with newTrack, e.g. a DualChannelRecorder per file. With a nil
+// newTrack all agent audio goes to def.
+func NewTrackRouter(def Recorder, newTrack func(track string) (Recorder, error)) *TrackRouter {
+	if def == nil {
+		def = DiscardRecorder{}
+	}
+	return &TrackRouter{
+		def:      def,
+		newTrack: newTrack,
+		tracks:   make(map[string]Recorder),
+	}
+}
+
+// WriteLeft records user audio with the default recorder.
+func (r *TrackRouter) WriteLeft(data []byte) error {
+	return r.def.WriteLeft(data)
+}
+
+// WriteRight records unlabeled agent audio with the default recorder.
+func (r *TrackRouter) WriteRight(data []byte) error {
+	return r.def.WriteRight(data)
+}
+
+// WriteTrack records agent audio to the recorder for track.
+func (r *TrackRouter) WriteTrack(track string, data []byte) error {
+	if track == "" || r.newTrack == nil {
+		return r.def.WriteRight(data)
+	}
+
+	rec, err := r.track(track)
+	if err != nil {
+		return err
+	}
+	return rec.WriteRight(data)
+}
+
+func (r *TrackRouter) track(track string) (Recorder, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if rec, ok := r.tracks[track]; ok {
+		return rec, nil
+	}
+
+	rec, err := r.newTrack(track)
+	if err != nil {
+		return nil, err
+	}
+	r.tracks[track] = rec
+	return rec, nil
+}
+
+// Close closes the default recorder and every track recorder.
+func (r *TrackRouter) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	errs := []error{r.def.Close()}
+	for _, rec := range r.tracks {
+		errs = append(errs, rec.Close())
+	}
+	return errors.Join(errs...)
+}
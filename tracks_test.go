@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMediaOutputLabel(t *testing.T) {
+	tests := []struct {
+		json  string
+		label string
+	}{
+		{`{"event": "media_output", "stream_id": "s", "media": {"payload": "AAA="}}`, ""},
+		{`{"event": "media_output", "stream_id": "s", "media": {"payload": "AAA="}, "speaker": "agent_b"}`, "agent_b"},
+		{`{"event": "media_output", "stream_id": "s", "media": {"payload": "AAA="}, "speaker": "agent_b", "track": "music"}`, "music"},
+	}
+
+	for _, tt := range tests {
+		msg, err := UnmarshalMessage([]byte(tt.json))
+		if err != nil {
+			t.Fatalf("UnmarshalMessage(%s): %v", tt.json, err)
+		}
+		m, ok := msg.(*MediaOutputMessage)
+		if !ok {
+			t.Fatalf("UnmarshalMessage(%s) = %T, want *MediaOutputMessage", tt.json, msg)
+		}
+		if got := m.Label(); got != tt.label {
+			t.Errorf("Label() of %s = %q, want %q", tt.json, got, tt.label)
+		}
+	}
+}
+
+func TestTrackRouter(t *testing.T) {
+	labeled := func(streamID string, n int, speaker, track string) Message {
+		m := NewMediaOutputFromPCM(streamID, loudPCM(time.Duration(n)*10*time.Millisecond, 16000))
+		m.Speaker, m.Track = speaker, track
+		return m
+	}
+	srv := NewTestServer(&TestServerOptions{
+		OnStart: func(start *StartMessage) []Message {
+			return []Message{
+				labeled(start.StreamID, 1, "", ""),
+				labeled(start.StreamID, 2, "", "music"),
+				labeled(start.StreamID, 3, "agent_b", ""),
+				labeled(start.StreamID, 4, "", "music"),
+			}
+		},
+	})
+	defer srv.Close()
+
+	def := &testRecorder{}
+	var mu sync.Mutex
+	tracks := make(map[string]*testRecorder)
+	router := NewTrackRouter(def, func(track string) (Recorder, error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		tracks[track] = &testRecorder{}
+		return tracks[track], nil
+	})
+
+	session := newTestSession(t, srv, Config{})
+	conversation := NewConversation(session, router)
+	if err := conversation.DrainUntilSilence(context.Background(), 300*time.Millisecond); err != nil {
+		t.Fatalf("DrainUntilSilence: %v", err)
+	}
+	if err := conversation.EndTurn(context.Background()); err != nil {
+		t.Fatalf("EndTurn: %v", err)
+	}
+	if err := router.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 10ms of 16 kHz PCM is 320 bytes
+	if def.rightBytes() != 320 || def.leftBytes() != 32000 {
+		t.Errorf("default recorder got %d bytes of agent and %d of user audio, want 320 and 32000", def.rightBytes(), def.leftBytes())
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(tracks) != 2 || tracks["music"] == nil || tracks["agent_b"] == nil {
+		t.Fatalf("opened %d track recorders, want music and agent_b", len(tracks))
+	}
+	if music, agentB := tracks["music"].rightBytes(), tracks["agent_b"].rightBytes(); music != 6*320 || agentB != 3*320 {
+		t.Errorf("music got %d bytes and agent_b %d, want 60ms and 30ms", music, agentB)
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// TranscriptWriter appends role-tagged transcript lines to a file.
+// Files ending in .jsonl get one JSON object per line, anything else plain text.
+type TranscriptWriter struct {
+	file  *os.File
+	w     *bufio.Writer
+	jsonl bool
+}
+
+// transcriptEntry is the JSONL representation of a transcript line.
+type transcriptEntry struct {
+	Time time.Time `json:"time"`
+	Role string    `json:"role"`
+	Text string    `json:"text"`
+}
+
+// NewTranscriptWriter creates a transcript file, truncating any existing one.
+func NewTranscriptWriter(filename string) (*TranscriptWriter, error) {
+	file, err := os.Create(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TranscriptWriter{
+		file:  file,
+		w:     bufio.NewWriter(file),
+		jsonl: filepath.Ext(filename) == ".jsonl",
+	}, nil
+}
+
+// Write appends a line of text spoken by role stamped with the current time.
+func (t *TranscriptWriter) Write(role, text string) error {
+	now := time.Now()
+
+	if t.jsonl {
+		line, err := json.Marshal(transcriptEntry{Time: now, Role: role, Text: text})
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(t.w, "%s\n", line)
+		return err
+	}
+
+	_, err := fmt.Fprintf(t.w, "[%s] %s: %s\n", now.Format(time.RFC3339Nano), role, text)
+	return err
+}
+
+// Close flushes buffered lines and closes the file.
+func (t *TranscriptWriter) Close() error {
+	if err := t.w.Flush(); err != nil {
+		t.file.Close()
+		return err
+	}
+	return t.file.Close()
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// transcriptServer is a conversation server whose agent transcribes the
+// greeting, the question and the answer, among other custom events.
+func transcriptServer() *TestServer {
+	return newConversationServer(func(streamID string, frame int) []Message {
+		transcript := func(role, text string) Message {
+			return NewTranscriptMessage(streamID, role, text)
+		}
+		switch frame {
+		case 0:
+			return []Message{
+				&CustomMessage{Event: MessageTypeCustom, StreamID: streamID, Metadata: Metadata{"type": "caller", "text": "not said"}},
+				transcript("agent", "Hello, how can I help?"),
+			}
+		case questionFrames:
+			return []Message{transcript("user", "When do you open?"), transcript("agent", "At nine.")}
+		}
+		return nil
+	})
+}
+
+func TestTranscriptText(t *testing.T) {
+	srv := transcriptServer()
+	defer srv.Close()
+
+	path := filepath.Join(t.TempDir(), "transcript.txt")
+	if _, err := runTestConversation(t, srv, Config{}, &ConversationOptions{Transcript: path}); err != nil {
+		t.Fatalf("RunConversationWithOptions: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	want := []string{"agent: Hello, how can I help?", "user: When do you open?", "agent: At nine."}
+	if len(lines) != len(want) {
+		t.Fatalf("transcript has %d lines, want %d:\n%s", len(lines), len(want), data)
+	}
+	for i, line := range lines {
+		if !strings.HasPrefix(line, "[") || !strings.HasSuffix(line, "] "+want[i]) {
+			t.Errorf("line %d = %q, want a timestamped %q", i, line, want[i])
+		}
+	}
+}
+
+func TestTranscriptJSONL(t *testing.T) {
+	srv := transcriptServer()
+	defer srv.Close()
+
+	path := filepath.Join(t.TempDir(), "transcript.jsonl")
+	if _, err := runTestConversation(t, srv, Config{}, &ConversationOptions{Transcript: path}); err != nil {
+		t.Fatalf("RunConversationWithOptions: %v", err)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	var entries []transcriptEntry
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry transcriptEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatalf("invalid line %q: %v", scanner.Text(), err)
+		}
+		if entry.Time.IsZero() {
+			t.Errorf("line %q has no time", scanner.Text())
+		}
+		entries = append(entries, entry)
+	}
+	if len(entries) != 3 || entries[1].Role != "user" || entries[1].Text != "When do you open?" {
+		t.Errorf("transcript = %+v, want the greeting, question and answer", entries)
+	}
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	silenceThreshold = 2 * time.Second  // agent silence that ends its turn
+	responseTimeout  = 10 * time.Second // wait for the agent to start answering
+	turnCheckPeriod  = 100 * time.Millisecond
+)
+
+// TurnEventType
+type TurnEventType int
+
+const (
+	TurnStarted TurnEventType = iota // agent started speaking
+	TurnEnded                        // agent has been silent for the silence threshold
+	Timeout                          // agent did not answer within the response timeout
+)
+
+func (t TurnEventType) String() string {
+	switch t {
+	case TurnStarted:
+		return "turn_started"
+	case TurnEnded:
+		return "turn_ended"
+	case Timeout:
+		return "timeout"
+	}
+	return "unknown"
+}
+
+// TurnEvent marks a boundary in the agent's speech. Time is the first audio
+// of the turn for TurnStarted, the last audio for TurnEnded, and the moment
+// the timeout expired for Timeout.
+type TurnEvent struct {
+	Type TurnEventType
+	Time time.Time
+
+	// Latency is set on the TurnStarted event answering ExpectResponse: the
+	// time from that call, i.e. the end of the user's turn, to the agent's
+	// first audio.
+	Latency time.Duration
+}
+
+// CompletionStrategy decides when the agent has finished a turn.
+type CompletionStrategy interface {
+	// Signal reports whether msg explicitly marks the end of the agent's turn.
+	Signal(msg Message) bool
+
+	// Silence returns how long the agent must be quiet for its turn to end,
+	// or 0 to rely on signals alone.
+	Silence() time.Duration
+}
+
+// SilenceCompletion ends a turn once the agent has been silent for Threshold.
+type SilenceCompletion struct {
+	Threshold time.Duration
+}
+
+func (c SilenceCompletion) Signal(msg Message) bool { return false }
+func (c SilenceCompletion) Silence() time.Duration  { return c.Threshold }
+
+// SignalCompletion ends a turn as soon as Match accepts a message from the
+// agent, e.g. a custom event the agent emits when it is done speaking. The
+// protocol has no standard end-of-turn event, so Match must be provided.
+// Fallback, when positive, still ends the turn after that much silence in
+// case the signal never arrives.
+type SignalCompletion struct {
+	Match    func(msg Message) bool
+	Fallback time.Duration
+}
+
+func (c SignalCompletion) Signal(msg Message) bool { return c.Match != nil && c.Match(msg) }
+func (c SignalCompletion) Silence() time.Duration  { return c.Fallback }
+
+// TurnDetector turns a stream of agent audio into turn boundary events.
+// Feed it with Audio whenever agent audio arrives and call ExpectResponse once
+// the user has finished speaking; Run emits the resulting events on Events.
+type TurnDetector struct {
+	silence time.Duration
+	timeout time.Duration
+	events  chan TurnEvent
+	wake    chan struct{}
+	clock   Clock
+
+	mu           sync.Mutex
+	speaking     bool
+	pendingAudio bool      // audio received since the last check
+	firstAudio   time.Time // first audio since the last check
+	lastAudio    time.Time
+	waitingSince time.Time // set by ExpectResponse until the agent answers
+	endRequested bool      // set by EndTurn
+}
+
+// NewTurnDetector creates a detector that ends a turn after silence without
+// audio and reports a timeout if no turn starts within timeout of ExpectResponse.
+// A zero silence disables silence detection, leaving EndTurn to end turns.
+func NewTurnDetector(silence, timeout time.Duration) *TurnDetector {
+	return &TurnDetector{
+		silence: silence,
+		timeout: timeout,
+		events:  make(chan TurnEvent, 10),
+		wake:    make(chan struct{}, 1),
+		clock:   realClock{},
+	}
+}
+
+// SetClock replaces the system clock, e.g. with a FakeClock in tests. It must
+// be called before Run.
+func (d *TurnDetector) SetClock(clock Clock) {
+	d.clock = clock
+}
+
+// Events returns the channel of turn events. It is closed when Run returns.
+func (d *TurnDetector) Events() <-chan TurnEvent {
+	return d.events
+}
+
+// Audio records that agent audio was just received.
+func (d *TurnDetector) Audio() {
+	d.mu.Lock()
+	now := d.clock.Now()
+	if !d.pendingAudio {
+		d.firstAudio = now
+	}
+	d.pendingAudio = true
+	d.lastAudio = now
+	d.mu.Unlock()
+
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// EndTurn ends the agent's turn immediately, e.g. because the agent signalled
+// that it is done. A TurnEnded event is emitted even if no audio was received.
+func (d *TurnDetector) EndTurn() {
+	d.mu.Lock()
+	d.endRequested = true
+	d.mu.Unlock()
+
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// ExpectResponse starts waiting for the agent to answer. Any agent turn in
+// progress is discarded, so only audio after this call counts as the response.
+func (d *TurnDetector) ExpectResponse() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := d.clock.Now()
+	d.speaking = false
+	d.pendingAudio = false
+	d.lastAudio = now
+	d.waitingSince = now
+}
+
+// Reset clears the turn state so the next turn is detected independently of
+// the previous one: the agent is no longer considered speaking, pending
+// audio, EndTurn requests and response timeouts are dropped, and events not
+// yet read from Events are discarded. Run keeps running.
+func (d *TurnDetector) Reset() {
+	d.mu.Lock()
+	d.speaking = false
+	d.pendingAudio = false
+	d.firstAudio = time.Time{}
+	d.lastAudio = time.Time{}
+	d.waitingSince = time.Time{}
+	d.endRequested = false
+	d.mu.Unlock()
+
+	for {
+		select {
+		case _, ok := <-d.events:
+			if !ok {
+				return
+			}
+		default:
+			return
+		}
+	}
+}
+
+// Run evaluates turn boundaries until ctx is done, then closes Events.
+func (d *TurnDetector) Run(ctx context.Context) {
+	defer close(d.events)
+
+	ticker := d.clock.NewTicker(turnCheckPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-d.wake:
+		case <-ticker.C():
+		case <-ctx.Done():
+			return
+		}
+
+		for _, ev := range d.check(d.clock.Now()) {
+			select {
+			case d.events <- ev:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+// check updates the turn state and returns the events it produced.
+func (d *TurnDetector) check(now time.Time) []TurnEvent {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var events []TurnEvent
+
+	if d.pendingAudio {
+		d.pendingAudio = false
+		if !d.speaking {
+			ev := TurnEvent{Type: TurnStarted, Time: d.firstAudio}
+			if !d.waitingSince.IsZero() {
+				ev.Latency = d.firstAudio.Sub(d.waitingSince)
+			}
+			d.speaking = true
+			d.waitingSince = time.Time{}
+			events = append(events, ev)
+		}
+	}
+
+	if d.endRequested {
+		d.endRequested = false
+		d.waitingSince = time.Time{}
+
+		end := now
+		if d.speaking {
+			end = d.lastAudio
+		}
+		d.speaking = false
+		events = append(events, TurnEvent{Type: TurnEnded, Time: end})
+	}
+
+	if d.speaking && d.silence > 0 && now.Sub(d.lastAudio) > d.silence {
+		d.speaking = false
+		events = append(events, TurnEvent{Type: TurnEnded, Time: d.lastAudio})
+	}
+
+	if !d.speaking && !d.waitingSince.IsZero() && now.Sub(d.waitingSince) > d.timeout {
+		d.waitingSince = time.Time{}
+		events = append(events, TurnEvent{Type: Timeout, Time: now})
+	}
+
+	return events
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// nextTurnEvent returns the next event of d, failing the test if none comes.
+func nextTurnEvent(t *testing.T, d *TurnDetector) TurnEvent {
+	t.Helper()
+
+	select {
+	case ev := <-d.Events():
+		return ev
+	case <-time.After(5 * time.Second):
+		t.Fatal("no turn event")
+		return TurnEvent{}
+	}
+}
+
+func TestTurnDetector(t *testing.T) {
+	start := time.Unix(1000, 0)
+	clock := NewFakeClock(start)
+	d := NewTurnDetector(2*time.Second, 10*time.Second)
+	d.SetClock(clock)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go d.Run(ctx)
+
+	// The agent answers a second after the user's turn
+	d.ExpectResponse()
+	clock.Advance(time.Second)
+	d.Audio()
+	ev := nextTurnEvent(t, d)
+	if ev.Type != TurnStarted || !ev.Time.Equal(start.Add(time.Second)) || ev.Latency != time.Second {
+		t.Fatalf("event = %+v, want turn_started a second in with a latency of 1s", ev)
+	}
+
+	// and stops speaking a second later
+	clock.Advance(time.Second)
+	d.Audio()
+	clock.Advance(2100 * time.Millisecond)
+	ev = nextTurnEvent(t, d)
+	if ev.Type != TurnEnded || !ev.Time.Equal(start.Add(2*time.Second)) {
+		t.Fatalf("event = %+v, want turn_ended at the last audio", ev)
+	}
+
+	// The next turn goes unanswered
+	d.ExpectResponse()
+	clock.Advance(10100 * time.Millisecond)
+	if ev = nextTurnEvent(t, d); ev.Type != Timeout {
+		t.Fatalf("event = %+v, want timeout", ev)
+	}
+
+	// EndTurn ends a turn without waiting for silence
+	d.Audio()
+	if ev = nextTurnEvent(t, d); ev.Type != TurnStarted {
+		t.Fatalf("event = %+v, want turn_started", ev)
+	}
+	d.EndTurn()
+	if ev = nextTurnEvent(t, d); ev.Type != TurnEnded {
+		t.Fatalf("event = %+v, want turn_ended", ev)
+	}
+
+	cancel()
+	for range d.Events() {
+	}
+}
+
+func TestTurnDetectorLatency(t *testing.T) {
+	// The agent starts answering 300ms after the end of the question
+	const delay = 300 * time.Millisecond
+	srv := NewTestServer(&TestServerOptions{
+		Respond: func(msg Message) []Message {
+			m, ok := msg.(*MediaInputMessage)
+			if !ok {
+				return nil
+			}
+			time.Sleep(delay)
+			return []Message{NewMediaOutputFromPCM(m.StreamID, loudPCM(100*time.Millisecond, 16000))}
+		},
+	})
+	defer srv.Close()
+
+	session := newTestSession(t, srv, Config{})
+	d := NewTurnDetector(time.Second, 10*time.Second)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go d.Run(ctx)
+
+	if err := session.Send(ctx, NewMediaInputFromPCM(session.StreamID(), loudPCM(100*time.Millisecond, 16000))); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	d.ExpectResponse()
+
+	select {
+	case m := <-session.Messages():
+		if m.Type() == MessageTypeMediaOutput {
+			d.Audio()
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no answer")
+	}
+
+	// The server's delay may start just before ExpectResponse
+	ev := nextTurnEvent(t, d)
+	if ev.Type != TurnStarted || ev.Latency < delay-50*time.Millisecond || ev.Latency > delay+time.Second {
+		t.Errorf("event = %+v, want turn_started with a latency of about %s", ev, delay)
+	}
+
+	cancel()
+	for range d.Events() {
+	}
+}
+
+func TestTurnDetectorReset(t *testing.T) {
+	start := time.Unix(1000, 0)
+	clock := NewFakeClock(start)
+	d := NewTurnDetector(2*time.Second, 10*time.Second)
+	d.SetClock(clock)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go d.Run(ctx)
+
+	noEvent := func(what string) {
+		t.Helper()
+		select {
+		case ev := <-d.Events():
+			t.Fatalf("event %+v after Reset, want no %s", ev, what)
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+
+	// A turn in progress and a pending response are forgotten
+	d.Audio()
+	if ev := nextTurnEvent(t, d); ev.Type != TurnStarted {
+		t.Fatalf("event = %+v, want turn_started", ev)
+	}
+	d.ExpectResponse()
+	d.Reset()
+	clock.Advance(11 * time.Second)
+	noEvent("turn_ended or timeout")
+
+	// The next turn is detected on its own, without the earlier wait
+	d.ExpectResponse()
+	clock.Advance(time.Second)
+	d.Audio()
+	ev := nextTurnEvent(t, d)
+	if ev.Type != TurnStarted || ev.Latency != time.Second {
+		t.Fatalf("event = %+v, want turn_started with a latency of 1s", ev)
+	}
+	clock.Advance(2100 * time.Millisecond)
+	if ev = nextTurnEvent(t, d); ev.Type != TurnEnded || !ev.Time.Equal(start.Add(12*time.Second)) {
+		t.Fatalf("event = %+v, want turn_ended at the new turn's audio", ev)
+	}
+
+	cancel()
+	for range d.Events() {
+	}
+}
+
+func TestSignalCompletion(t *testing.T) {
+	// The agent marks the end of the greeting and of the answer, the only
+	// way turns end without a silence fallback
+	done := func(streamID string) Message {
+		return &CustomMessage{Event: MessageTypeCustom, StreamID: streamID, Metadata: Metadata{"type": "turn_done"}}
+	}
+	srv := newConversationServer(func(streamID string, frame int) []Message {
+		if frame == 0 || frame == questionFrames {
+			return []Message{done(streamID)}
+		}
+		return nil
+	})
+	defer srv.Close()
+
+	var signals atomic.Int32
+	_, err := runTestConversation(t, srv, Config{}, &ConversationOptions{
+		Completion: SignalCompletion{Match: func(msg Message) bool {
+			m, ok := msg.(*CustomMessage)
+			if ok && m.Metadata["type"] == "turn_done" {
+				signals.Add(1)
+				return true
+			}
+			return false
+		}},
+	})
+	if err != nil {
+		t.Fatalf("RunConversationWithOptions: %v", err)
+	}
+	if n := signals.Load(); n != 2 {
+		t.Errorf("matched %d completion signals, want 2", n)
+	}
+}
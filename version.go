@@ -0,0 +1,30 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+var (
+	ErrUnsupportedVersion = errors.New("unsupported API version")
+)
+
+// SupportedVersions lists the Cartesia-Version values this client has been
+// tested against. Other versions are sent as configured, with a warning.
+var SupportedVersions = []string{"2025-04-16"}
+
+// isSupportedVersion reports whether version is one of SupportedVersions.
+func isSupportedVersion(version string) bool {
+	return slices.Contains(SupportedVersions, version)
+}
+
+// versionError wraps err with ErrUnsupportedVersion if the server's reason
+// for rejecting the connection mentions the API version.
+func versionError(version, reason string, err error) error {
+	if !strings.Contains(strings.ToLower(reason), "version") {
+		return err
+	}
+	return fmt.Errorf("%w %q: %w", ErrUnsupportedVersion, version, err)
+}
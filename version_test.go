@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/coder/websocket"
+)
+
+// versionServer returns a test server that serves the supported versions
+// and turns others down with reject.
+func versionServer(reject func(w http.ResponseWriter, r *http.Request)) *TestServer {
+	srv := NewTestServer(nil)
+	handler := srv.server.Config.Handler
+	srv.server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isSupportedVersion(r.Header.Get("Cartesia-Version")) {
+			handler.ServeHTTP(w, r)
+			return
+		}
+		reject(w, r)
+	})
+	return srv
+}
+
+func TestUnsupportedVersion(t *testing.T) {
+	// The ways a server may turn a version down
+	rejections := map[string]func(reason string) func(w http.ResponseWriter, r *http.Request){
+		"status": func(reason string) func(w http.ResponseWriter, r *http.Request) {
+			return func(w http.ResponseWriter, r *http.Request) {
+				http.Error(w, reason, http.StatusBadRequest)
+			}
+		},
+		"close": func(reason string) func(w http.ResponseWriter, r *http.Request) {
+			return func(w http.ResponseWriter, r *http.Request) {
+				conn, err := websocket.Accept(w, r, nil)
+				if err != nil {
+					return
+				}
+				conn.Read(r.Context())
+				conn.Close(websocket.StatusPolicyViolation, reason)
+			}
+		},
+		"ack": func(reason string) func(w http.ResponseWriter, r *http.Request) {
+			return func(w http.ResponseWriter, r *http.Request) {
+				conn, err := websocket.Accept(w, r, nil)
+				if err != nil {
+					return
+				}
+				defer conn.CloseNow()
+
+				_, data, err := conn.Read(r.Context())
+				if err != nil {
+					return
+				}
+				start, err := unmarshalClientMessage(data)
+				if err != nil {
+					return
+				}
+				ack := &AckMessage{Event: MessageTypeAck, StreamID: start.(*StartMessage).StreamID, Status: "error", Error: reason}
+				if writeMessage(r.Context(), conn, ack) == nil {
+					conn.Read(r.Context())
+				}
+			}
+		},
+	}
+
+	for name, reject := range rejections {
+		t.Run(name, func(t *testing.T) {
+			srv := versionServer(reject("Unsupported Cartesia-Version"))
+			defer srv.Close()
+
+			session := newTestSession(t, srv, Config{})
+			session.Close()
+
+			_, err := newTestClient(t, srv, Config{Version: "2024-01-01"}).NewSession(context.Background(), "agent", nil)
+			if !errors.Is(err, ErrUnsupportedVersion) || !strings.Contains(err.Error(), `"2024-01-01"`) {
+				t.Errorf("NewSession = %v, want ErrUnsupportedVersion naming the version", err)
+			}
+
+			// Other reasons are not mistaken for a version mismatch
+			other := versionServer(reject("agent disabled"))
+			defer other.Close()
+			_, err = newTestClient(t, other, Config{Version: "2024-01-01"}).NewSession(context.Background(), "agent", nil)
+			if err == nil || errors.Is(err, ErrUnsupportedVersion) {
+				t.Errorf("NewSession = %v, want an error other than ErrUnsupportedVersion", err)
+			}
+		})
+	}
+}
+
+func TestUnsupportedVersionWarning(t *testing.T) {
+	srv := NewTestServer(nil)
+	defer srv.Close()
+
+	for _, version := range []string{SupportedVersions[0], "2024-01-01"} {
+		var out logBuffer
+		logger := slog.New(slog.NewTextHandler(&out, nil))
+		newTestClient(t, srv, Config{Version: version, Logger: logger})
+
+		warned := strings.Contains(out.String(), "API version is not among the supported versions")
+		if want := version != SupportedVersions[0]; warned != want {
+			t.Errorf("version %s warned: %t, want %t\n%s", version, warned, want, out.String())
+		}
+	}
+}
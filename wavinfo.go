@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/go-audio/wav"
+)
+
+// Common INFO chunk IDs for RecorderOptions.Metadata
+const (
+	WAVInfoTitle    = "INAM"
+	WAVInfoComments = "ICMT"
+	WAVInfoDate     = "ICRD" // YYYY-MM-DD
+	WAVInfoSoftware = "ISFT"
+	WAVInfoSubject  = "ISBJ"
+	WAVInfoSource   = "ISRC"
+)
+
+// wavInfoFields maps the INFO chunk IDs go-audio/wav can write to the
+// Metadata field that holds them.
+var wavInfoFields = map[string]func(m *wav.Metadata, v string){
+	"IART": func(m *wav.Metadata, v string) { m.Artist = v },
+	"ICMT": func(m *wav.Metadata, v string) { m.Comments = v },
+	"ICOP": func(m *wav.Metadata, v string) { m.Copyright = v },
+	"ICRD": func(m *wav.Metadata, v string) { m.CreationDate = v },
+	"IENG": func(m *wav.Metadata, v string) { m.Engineer = v },
+	"ITCH": func(m *wav.Metadata, v string) { m.Technician = v },
+	"IGNR": func(m *wav.Metadata, v string) { m.Genre = v },
+	"IKEY": func(m *wav.Metadata, v string) { m.Keywords = v },
+	"IMED": func(m *wav.Metadata, v string) { m.Medium = v },
+	"INAM": func(m *wav.Metadata, v string) { m.Title = v },
+	"IPRD": func(m *wav.Metadata, v string) { m.Product = v },
+	"ISBJ": func(m *wav.Metadata, v string) { m.Subject = v },
+	"ISFT": func(m *wav.Metadata, v string) { m.Software = v },
+	"ISRC": func(m *wav.Metadata, v string) { m.Source = v },
+	"IARL": func(m *wav.Metadata, v string) { m.Location = v },
+	"ITRK": func(m *wav.Metadata, v string) { m.TrackNbr = v },
+}
+
+// newWAVInfo converts INFO chunk entries to encoder metadata, rejecting IDs
+// the encoder cannot write. It returns nil for no entries.
+func newWAVInfo(entries map[string]string) (*wav.Metadata, error) {
+	if len(entries) == 0 {
+		return nil, nil
+	}
+
+	meta := &wav.Metadata{}
+	for id, value := range entries {
+		set, ok := wavInfoFields[strings.ToUpper(id)]
+		if !ok {
+			return nil, fmt.Errorf("unsupported WAV INFO ID %q, use one of %s", id, strings.Join(wavInfoIDs(), ", "))
+		}
+		set(meta, padInfo(value))
+	}
+	return meta, nil
+}
+
+// padInfo NUL-pads value to an even size including its terminator. RIFF
+// chunks are word aligned but the encoder does not pad them, which makes
+// readers lose every entry after an odd-sized one.
+func padInfo(value string) string {
+	if len(value)%2 == 0 {
+		return value + "\x00"
+	}
+	return value
+}
+
+// wavInfoIDs returns the supported INFO chunk IDs in order.
+func wavInfoIDs() []string {
+	ids := make([]string, 0, len(wavInfoFields))
+	for id := range wavInfoFields {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// wireLog appends every frame of a session, as raw JSON, to a JSONL file for
+// protocol debugging. Audio payloads are replaced by their size unless audio
+// is set.
+type wireLog struct {
+	mu    sync.Mutex
+	file  *os.File
+	audio bool
+}
+
+// wireEntry is one line of a wire log.
+type wireEntry struct {
+	Time      time.Time       `json:"time"`
+	Direction string          `json:"direction"` // "in" from the agent, "out" to it
+	Frame     json.RawMessage `json:"frame,omitempty"`
+	Invalid   string          `json:"invalid,omitempty"` // a frame that is not JSON
+}
+
+// openWireLog opens filename for appending, so sessions of one client, and
+// of several runs, share the file.
+func openWireLog(filename string, audio bool) (*wireLog, error) {
+	file, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("open wire log error: %w", err)
+	}
+	return &wireLog{file: file, audio: audio}, nil
+}
+
+// write logs frame, ignoring a nil log. Errors are not reported: the wire log
+// must never interfere with the session.
+func (l *wireLog) write(direction string, frame []byte) {
+	if l == nil {
+		return
+	}
+
+	entry := wireEntry{Time: time.Now(), Direction: direction}
+	if json.Valid(frame) {
+		entry.Frame = l.redact(frame)
+	} else {
+		entry.Invalid = string(frame)
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// One write per line keeps lines whole when sessions share the file
+	l.file.Write(append(line, '\n'))
+}
+
+// redact replaces media.payload with a note of its length.
+func (l *wireLog) redact(frame []byte) []byte {
+	if l.audio || !bytes.Contains(frame, []byte(`"payload"`)) {
+		return frame
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(frame, &fields); err != nil {
+		return frame
+	}
+	var media map[string]json.RawMessage
+	if err := json.Unmarshal(fields["media"], &media); err != nil {
+		return frame
+	}
+	var payload string
+	if err := json.Unmarshal(media["payload"], &payload); err != nil {
+		return frame
+	}
+
+	media["payload"], _ = json.Marshal(fmt.Sprintf("[%d bytes of audio]", decodedSize(payload)))
+	fields["media"], _ = json.Marshal(media)
+
+	redacted, err := json.Marshal(fields)
+	if err != nil {
+		return frame
+	}
+	return redacted
+}
+
+func (l *wireLog) Close() error {
+	if l == nil {
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.file.Close()
+}
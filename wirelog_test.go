@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWireLog(t *testing.T) {
+	audio := loudPCM(100*time.Millisecond, 16000)
+	payload := base64.StdEncoding.EncodeToString(audio)
+
+	for _, withAudio := range []bool{false, true} {
+		srv := NewTestServer(&TestServerOptions{EchoMedia: true})
+		defer srv.Close()
+
+		path := filepath.Join(t.TempDir(), "wire.jsonl")
+		session := newTestSession(t, srv, Config{WireLogPath: path, WireLogAudio: withAudio})
+		if err := session.Send(context.Background(), NewMediaInputFromPCM(session.StreamID(), audio)); err != nil {
+			t.Fatalf("Send: %v", err)
+		}
+		select {
+		case <-session.Messages():
+		case <-time.After(5 * time.Second):
+			t.Fatal("no echo")
+		}
+		session.Close()
+
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+
+		type frame struct {
+			Event MessageType `json:"event"`
+			Media struct {
+				Payload string `json:"payload"`
+			} `json:"media"`
+		}
+		var got []string
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(nil, 1<<20)
+		for scanner.Scan() {
+			var entry wireEntry
+			var fr frame
+			if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil || json.Unmarshal(entry.Frame, &fr) != nil {
+				t.Fatalf("invalid line %q", scanner.Text())
+			}
+			got = append(got, entry.Direction+" "+string(fr.Event))
+
+			if fr.Event == MessageTypeMediaInput || fr.Event == MessageTypeMediaOutput {
+				want := "[3200 bytes of audio]"
+				if withAudio {
+					want = payload
+				}
+				if fr.Media.Payload != want {
+					t.Errorf("logged %s payload of %d characters, want %q", fr.Event, len(fr.Media.Payload), want[:min(len(want), 30)])
+				}
+			}
+		}
+
+		want := []string{"out start", "in ack", "out media_input", "in media_output"}
+		if len(got) != len(want) {
+			t.Fatalf("wire log = %q, want %q", got, want)
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Errorf("wire log = %q, want %q", got, want)
+				break
+			}
+		}
+	}
+}